@@ -32,6 +32,9 @@ const maxCellWhs = 64
 
 var crcTable = crc32.MakeTable(crc32.Castagnoli)
 
+// ErrCRC32CMismatch is returned when a crc32c hashsum of a BOC doesn't match its content.
+var ErrCRC32CMismatch = errors.New("crc32c hashsum mismatch")
+
 func readNBytesUIntFromArray(n int, arr []byte) uint {
 	var res uint = 0
 	for i := 0; i < n; i++ {
@@ -152,7 +155,7 @@ func parseBocHeader(boc []byte) (*bocHeader, error) {
 			return nil, errors.New("not enough bytes for crc32c hashsum")
 		}
 		if binary.LittleEndian.Uint32(boc[0:4]) != checkSum {
-			return nil, errors.New("crc32c hashsum mismatch")
+			return nil, ErrCRC32CMismatch
 		}
 		boc = boc[4:]
 	}
@@ -269,6 +272,16 @@ func DeserializeBoc(boc []byte) ([]*Cell, error) {
 	return rootCells, nil
 }
 
+// HasCRC32C reports whether the given BOC carries a crc32c hashsum.
+// If the hashsum is present, it is checked and ErrCRC32CMismatch is returned on mismatch.
+func HasCRC32C(boc []byte) (bool, error) {
+	header, err := parseBocHeader(boc)
+	if err != nil {
+		return false, err
+	}
+	return header.hasCrc32, nil
+}
+
 func DeserializeBocBase64(boc string) ([]*Cell, error) {
 	bocData, err := base64.StdEncoding.DecodeString(boc)
 	if err != nil {
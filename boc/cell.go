@@ -106,6 +106,20 @@ func (c *Cell) HashString() (string, error) {
 	return hex.EncodeToString(h), err
 }
 
+// HashAtLevel returns a hash of the given level.
+// A hash of level 0 of a cell containing pruned branches is equal to
+// the hash of the original cell before its subtrees were pruned.
+func (c *Cell) HashAtLevel(level int) ([]byte, error) {
+	if level < 0 || level > maxLevel {
+		return nil, fmt.Errorf("invalid level %v", level)
+	}
+	imc, err := newImmutableCell(c, map[*Cell]*immutableCell{})
+	if err != nil {
+		return nil, err
+	}
+	return imc.Hash(level), nil
+}
+
 func (c *Cell) hash(cache map[*Cell]*immutableCell) ([]byte, error) {
 	imc, err := newImmutableCell(c, cache)
 	if err != nil {
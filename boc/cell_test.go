@@ -74,3 +74,39 @@ func TestCell_MarshalJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestCell_HashAtLevel(t *testing.T) {
+	// a cell with a ref to 0xdeadbeef which is replaced by a pruned branch.
+	cells, err := DeserializeBocHex("b5ee9c7201010201002d002108123456780128480101270906fd171b9c43f37a353059a73fbc02e0568188ec30186af846caefd09b8c0000")
+	if err != nil {
+		t.Fatalf("DeserializeBocHex() failed: %v", err)
+	}
+	original := NewCell()
+	if err := original.WriteUint(0x12345678, 32); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	ref := NewCell()
+	if err := ref.WriteUint(0xdeadbeef, 32); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	if err := original.AddRef(ref); err != nil {
+		t.Fatalf("AddRef() failed: %v", err)
+	}
+	want, err := original.HashString()
+	if err != nil {
+		t.Fatalf("HashString() failed: %v", err)
+	}
+	hash, err := cells[0].HashAtLevel(0)
+	if err != nil {
+		t.Fatalf("HashAtLevel() failed: %v", err)
+	}
+	if got := fmt.Sprintf("%x", hash); got != want {
+		t.Fatalf("want hash %v, got %v", want, got)
+	}
+	if reprHash, _ := cells[0].HashString(); reprHash == want {
+		t.Fatalf("representation hash of a pruned cell must differ from the original hash")
+	}
+	if _, err := cells[0].HashAtLevel(4); err == nil {
+		t.Fatalf("HashAtLevel() had to fail but it didn't")
+	}
+}
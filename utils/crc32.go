@@ -9,3 +9,10 @@ import (
 func Crc32String(data string) uint32 {
 	return crc32.Checksum([]byte(data), crc32.IEEETable)
 }
+
+// OpcodeFromSchema returns an opcode of a message body described by the given TL-B declaration.
+// The opcode is a crc32 checksum of the declaration with the highest bit cleared,
+// so OpcodeFromSchema("transfer query_id:uint64 ... = InternalMsgBody") returns 0x0f8a7ea5, see TEP-74.
+func OpcodeFromSchema(schema string) uint32 {
+	return Crc32String(schema) & 0x7fffffff
+}
@@ -22,3 +22,26 @@ func TestCrc32String(t *testing.T) {
 		})
 	}
 }
+
+func TestOpcodeFromSchema(t *testing.T) {
+	tests := []struct {
+		schema string
+		want   uint32
+	}{
+		{
+			schema: "transfer query_id:uint64 amount:VarUInteger 16 destination:MsgAddress response_destination:MsgAddress custom_payload:Maybe ^Cell forward_ton_amount:VarUInteger 16 forward_payload:Either Cell ^Cell = InternalMsgBody",
+			want:   0x0f8a7ea5,
+		},
+		{
+			schema: "burn query_id:uint64 amount:VarUInteger 16 response_destination:MsgAddress custom_payload:Maybe ^Cell = InternalMsgBody",
+			want:   0x595f07bc,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.schema, func(t *testing.T) {
+			if got := OpcodeFromSchema(tt.schema); got != tt.want {
+				t.Errorf("OpcodeFromSchema() = %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package wallet
+
+import (
+	"github.com/tonkeeper/tongo/boc"
+)
+
+// BenchCase is an external message sent to a wallet, see NewBenchCorpus.
+type BenchCase struct {
+	Name    string
+	Version Version
+	// BOC is a base64-encoded BOC with the message.
+	BOC string
+}
+
+// Message deserializes the message of this case.
+func (c BenchCase) Message() (*boc.Cell, error) {
+	return boc.DeserializeSinglRootBase64(c.BOC)
+}
+
+// NewBenchCorpus returns external messages of every wallet version supported by DecodeMessage.
+// The package benchmarks decoding with them,
+// and forks of the package can run their own benchmarks against the same messages to compare results.
+func NewBenchCorpus() []BenchCase {
+	return []BenchCase{
+		{
+			// built by AssembleExternalMessage with a key derived from a zero seed.
+			Name:    "v3 transfer with comment",
+			Version: V3R2,
+			BOC:     "te6ccgEBBAEAtwABRYgApn5hvK5EKvcI4+qgdz+LABkbBy/PLofvLWI8wTW1zT4MAQGafsD9pnz44vkvTm8TWTMAEGRMKnTD5SnsL/97a6d1O1R+2DCD/O4RI5sU7HGov/YcLK0gHOZwFMBJPcrcj24hCSmpoxdlU/EAAAAADAMCAWZCACg+9T6wN5Fs9Cs8afdvHN3wmdQ0aVBx8D+qgWW4XFKJHMS0AAAAAAAAAAAAAAAAAAEDABIAAAAAaGVsbG8=",
+		},
+		{
+			Name:    "v4 transfer",
+			Version: V4R2,
+			BOC:     "te6ccgEBAgEAqgAB4YgA2ZpktQsYby0n9cV5VWOFINBjScIU2HdondFsK3lDpEAAQ+B903cV6YIMdtd4QtdyekehadSk+QjIgoIiRgjZD9v81PVGEXBKHPgPUknVvxvr/LGcKkLNhY+I1Wuwi/7ACU1NGLsi5dhQAAAA8AAcAQBoQgApn5hvK5EKvcI4+qgdz+LABkbBy/PLofvLWI8wTW1zT6WWgvAAAAAAAAAAAAAAAAAAAA==",
+		},
+		{
+			Name:    "v5 three transfers",
+			Version: V5R1,
+			BOC:     "te6ccgECCAEAAZ4AAfGIAehvqHPiQ2Ru+zkowjJx/7oJbqEYRnlCOuPe5+2gm24WA5tLO3f////oAAAAAAADMYd8kAAAAAEHzN670eqqNU3yWGkX1dOynyAbT7DN4cFDpE0r+nInTomGrifjPTaZvG3YxYzTHpLoNesGc9s5Q0tHlLNcFNQeAQIKDsPIbQMCAwIKDsPIbQMEBQCpaAHob6hz4kNkbvs5KMIycf+6CW6hGEZ5Qjrj3uftoJtuFwAbM0yWoWMN5aT+uK8qrHCkGgxpOEKbDu0Tui2Fbyh0iAy3GwAAAAAAAAAAAAAAAAAAQAIKDsPIbQMGBwCpaAHob6hz4kNkbvs5KMIycf+6CW6hGEZ5Qjrj3uftoJtuFwAbM0yWoWMN5aT+uK8qrHCkGgxpOEKbDu0Tui2Fbyh0iAx6EgAAAAAAAAAAAAAAAAAAQAAAAKloAehvqHPiQ2Ru+zkowjJx/7oJbqEYRnlCOuPe5+2gm24XABszTJahYw3lpP64ryqscKQaDGk4QpsO7RO6LYVvKHSIDD0JAAAAAAAAAAAAAAAAAABA",
+		},
+		{
+			Name:    "highload two transfers",
+			Version: HighLoadV2R2,
+			BOC:     "te6ccgECCQEAAUMAAUWIAbeTPaOhIeFpX00pVBankGP2F/kaObq5EAdGLvI+omE+DAEBmXzKceTPz+weyz8nYZbOkpsBYbvy6gN7h38ZVL6RTqln7XbUzHkQqxRp1B1ZYkBgMW1NtE7r8Jwg26HcS3qPiwYAAYiUZMJyTpfTrVXAAgIFngACAwQBAwDgBQEDAOAHAWJCADZmmS1CxhvLSf1xXlVY4Ug0GNJwhTYd2id0WwreUOkQCKAAAAAAAAAAAAAAAAABBgBQAAAAADcwMzBhYzQ2LWI5NWMtNDRjNy04ZDdiLTYxMjMyNmU2ZTUxMgFiQgA2ZpktQsYby0n9cV5VWOFINBjScIU2HdondFsK3lDpEAlAAAAAAAAAAAAAAAAAAQgAUAAAAAAzYjA2OTU1YS03YjRjLTQ1YWEtOTVlNy0wNTI4ZWZhYjAyM2E=",
+		},
+	}
+}
@@ -0,0 +1,107 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/tonkeeper/tongo/boc"
+)
+
+func benchMessage(b *testing.B, ver Version) *boc.Cell {
+	for _, c := range NewBenchCorpus() {
+		if c.Version != ver {
+			continue
+		}
+		msg, err := c.Message()
+		if err != nil {
+			b.Fatalf("Message() failed: %v", err)
+		}
+		return msg
+	}
+	b.Fatalf("no bench case for %v", ver.ToString())
+	return nil
+}
+
+func BenchmarkDecodeMessageV3(b *testing.B) {
+	msg := benchMessage(b, V3R2)
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msg.ResetCounters()
+		if _, err := DecodeMessageV3(msg); err != nil {
+			b.Fatalf("DecodeMessageV3() failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkDecodeMessageV4(b *testing.B) {
+	msg := benchMessage(b, V4R2)
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msg.ResetCounters()
+		if _, err := DecodeMessageV4(msg); err != nil {
+			b.Fatalf("DecodeMessageV4() failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkDecodeMessageV5(b *testing.B) {
+	msg := benchMessage(b, V5R1)
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msg.ResetCounters()
+		if _, err := DecodeMessageV5(msg); err != nil {
+			b.Fatalf("DecodeMessageV5() failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkDecodeHighloadV2Message(b *testing.B) {
+	msg := benchMessage(b, HighLoadV2R2)
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msg.ResetCounters()
+		if _, err := DecodeHighloadV2Message(msg); err != nil {
+			b.Fatalf("DecodeHighloadV2Message() failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkDecodeMessage(b *testing.B) {
+	for _, c := range NewBenchCorpus() {
+		b.Run(c.Name, func(b *testing.B) {
+			msg, err := c.Message()
+			if err != nil {
+				b.Fatalf("Message() failed: %v", err)
+			}
+			b.ResetTimer()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				msg.ResetCounters()
+				if _, err := DecodeMessage(c.Version, msg); err != nil {
+					b.Fatalf("DecodeMessage() failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewBenchCorpus(t *testing.T) {
+	for _, c := range NewBenchCorpus() {
+		t.Run(c.Name, func(t *testing.T) {
+			msg, err := c.Message()
+			if err != nil {
+				t.Fatalf("Message() failed: %v", err)
+			}
+			ver, err := DetectVersion(msg)
+			if err != nil {
+				t.Fatalf("DetectVersion() failed: %v", err)
+			}
+			if ver != c.Version {
+				t.Fatalf("want version %v, got %v", c.Version.ToString(), ver.ToString())
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package wallet
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+)
+
+// HashStable decodes the body of the given external message sent to a wallet of the given version,
+// encodes it back and compares the representation hashes of both bodies.
+//
+// A wallet verifies a signature against the body it receives,
+// so a body that changes after a round-trip through this package can't be re-sent or re-signed reliably.
+// HashStable is a diagnostic helper that shows whether the encoder of the given version diverges from its decoder.
+func HashStable(ver Version, msg *boc.Cell) (original, reencoded tlb.Bits256, equal bool, err error) {
+	var m tlb.Message
+	if err := tlb.Unmarshal(msg, &m); err != nil {
+		return tlb.Bits256{}, tlb.Bits256{}, false, err
+	}
+	body := boc.Cell(m.Body.Value)
+	originalHash, err := body.CopyRemaining().Hash256()
+	if err != nil {
+		return tlb.Bits256{}, tlb.Bits256{}, false, err
+	}
+	reencodedBody, err := reencodeBody(ver, &m)
+	if err != nil {
+		return tlb.Bits256{}, tlb.Bits256{}, false, err
+	}
+	reencodedHash, err := reencodedBody.Hash256()
+	if err != nil {
+		return tlb.Bits256{}, tlb.Bits256{}, false, err
+	}
+	return originalHash, reencodedHash, originalHash == reencodedHash, nil
+}
+
+// reencodeBody decodes the body of the given message as a message of the given wallet version and encodes it back.
+func reencodeBody(ver Version, m *tlb.Message) (*boc.Cell, error) {
+	if ver == V5R1 {
+		v5, err := messageV5FromTLB(m)
+		if err != nil {
+			return nil, err
+		}
+		cell := boc.NewCell()
+		if err := tlb.Marshal(cell, v5); err != nil {
+			return nil, err
+		}
+		return cell, nil
+	}
+	signedMsgBody, err := signedMsgBodyFromTLB(m)
+	if err != nil {
+		return nil, err
+	}
+	var decoded any
+	switch ver {
+	case V3R1, V3R2:
+		decoded, err = decodeMessageV3(signedMsgBody)
+	case V4R1, V4R2:
+		decoded, err = decodeMessageV4(signedMsgBody)
+	case HighLoadV2R2:
+		decoded, err = decodeHighloadV2Message(signedMsgBody)
+	case HighLoadV1R1, HighLoadV1R2:
+		decoded, err = decodeHighloadV1Message(signedMsgBody)
+	default:
+		return nil, fmt.Errorf("wallet version is not supported: %v", ver)
+	}
+	if err != nil {
+		return nil, err
+	}
+	inner := boc.NewCell()
+	if err := tlb.Marshal(inner, decoded); err != nil {
+		return nil, err
+	}
+	cell := boc.NewCell()
+	if err := tlb.Marshal(cell, SignedMsgBody{Sign: signedMsgBody.Sign, Message: tlb.Any(*inner)}); err != nil {
+		return nil, err
+	}
+	return cell, nil
+}
+
+// Fingerprint returns a hex-encoded hash of what the given external message asks a wallet of the given version to do:
+// the version, the subwallet id and the modes and contents of the messages to send.
+// The signature, seqno and valid until time are ignored, so a transfer re-signed with a new seqno or expiration time
+// has the same fingerprint. Messages are compared by content, so it doesn't matter
+// whether their bodies and state inits are stored inline or in refs.
+func Fingerprint(ver Version, msg *boc.Cell) (string, error) {
+	decoded, err := DecodeMessage(ver, msg)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	var buf [4]byte
+	h.Write([]byte(decoded.Version.ToString()))
+	binary.BigEndian.PutUint32(buf[:], decoded.SubWalletId)
+	h.Write(buf[:])
+	for i, rawMsg := range decoded.RawMessages {
+		hash, err := normalizedMessageHash(rawMsg)
+		if err != nil {
+			return "", fmt.Errorf("message %v: %w", i, err)
+		}
+		h.Write([]byte{rawMsg.Mode})
+		h.Write(hash[:])
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// normalizedMessageHash returns a hash of the given message encoded with its state init and body in refs.
+func normalizedMessageHash(rawMsg RawMessage) ([32]byte, error) {
+	m, err := rawMsg.ToTLBMessage()
+	if err != nil {
+		return [32]byte{}, err
+	}
+	m.Init.Value.IsRight = true
+	m.Body.IsRight = true
+	cell := boc.NewCell()
+	if err := tlb.Marshal(cell, m); err != nil {
+		return [32]byte{}, err
+	}
+	return cell.Hash256()
+}
+
+// ExternalMessagesEquivalent reports whether the given external messages deliver the same signed body to the same wallet,
+// so a wallet that accepted one of them treats the other one as a replay.
+// Bodies are compared by their representation hashes including signatures,
+// so the BOC framing, whether a body is stored inline or in a ref, a state init and an import fee don't matter.
+func ExternalMessagesEquivalent(a, b *boc.Cell) (bool, error) {
+	destA, bodyA, err := signedContent(a)
+	if err != nil {
+		return false, err
+	}
+	destB, bodyB, err := signedContent(b)
+	if err != nil {
+		return false, err
+	}
+	return destA == destB && bodyA == bodyB, nil
+}
+
+// signedContent returns a destination and a hash of the body of the given external message.
+func signedContent(msg *boc.Cell) (ton.AccountID, [32]byte, error) {
+	cell := *msg
+	cell.ResetCounters()
+	var m tlb.Message
+	if err := tlb.Unmarshal(&cell, &m); err != nil {
+		return ton.AccountID{}, [32]byte{}, err
+	}
+	if m.Info.SumType != "ExtInMsgInfo" {
+		return ton.AccountID{}, [32]byte{}, fmt.Errorf("want an external inbound message, got %v", m.Info.SumType)
+	}
+	dest, err := ton.AccountIDFromTlb(m.Info.ExtInMsgInfo.Dest)
+	if err != nil {
+		return ton.AccountID{}, [32]byte{}, err
+	}
+	if dest == nil {
+		return ton.AccountID{}, [32]byte{}, fmt.Errorf("external message has no destination")
+	}
+	body := boc.Cell(m.Body.Value)
+	hash, err := body.CopyRemaining().Hash256()
+	if err != nil {
+		return ton.AccountID{}, [32]byte{}, err
+	}
+	return *dest, hash, nil
+}
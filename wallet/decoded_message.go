@@ -0,0 +1,692 @@
+package wallet
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+)
+
+// DecodedMessage is a version-independent representation of an external message sent to a wallet contract.
+type DecodedMessage struct {
+	Version Version
+	// SubWalletId is a subwallet id of v3, v4 and highload wallets or a subwallet number of a v5 wallet id.
+	SubWalletId uint32
+	// ValidUntil is a unix time after which a wallet rejects the message.
+	// For highload wallets, it is taken from the bounded query id.
+	ValidUntil uint32
+	// Seqno is always zero for highload wallets because they don't have one.
+	Seqno       uint32
+	RawMessages []RawMessage
+}
+
+// DecodeErrors is returned when several messages are decoded at once and some of them fail.
+type DecodeErrors []error
+
+func (e DecodeErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// DecodeMessage decodes an external message sent to a wallet of the given version.
+func DecodeMessage(ver Version, msg *boc.Cell, opts ...DecodeOption) (*DecodedMessage, error) {
+	options := DecodeOptions{}
+	for _, o := range opts {
+		o(&options)
+	}
+	switch ver {
+	case V5R1:
+		v5, err := DecodeMessageV5(msg, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if v5.SumType == "Extn" {
+			return &DecodedMessage{
+				Version:     ver,
+				RawMessages: v5.RawMessages(),
+			}, nil
+		}
+		request := v5.signedRequest()
+		if request == nil {
+			return nil, fmt.Errorf("unknown v5 message type: %v", v5.SumType)
+		}
+		return checkDecodedMessage(&DecodedMessage{
+			Version:     ver,
+			SubWalletId: decodeWalletV5ID(request.SubWalletId).SubWalletID,
+			ValidUntil:  request.ValidUntil,
+			Seqno:       request.Seqno,
+			RawMessages: v5.RawMessages(),
+		}, options)
+	case V4R1, V4R2:
+		v4, err := DecodeMessageV4(msg, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return checkDecodedMessage(&DecodedMessage{
+			Version:     ver,
+			SubWalletId: v4.SubWalletId,
+			ValidUntil:  v4.ValidUntil,
+			Seqno:       v4.Seqno,
+			RawMessages: v4.RawMessages,
+		}, options)
+	case V3R1, V3R2:
+		v3, err := DecodeMessageV3(msg, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return checkDecodedMessage(&DecodedMessage{
+			Version:     ver,
+			SubWalletId: v3.SubWalletId,
+			ValidUntil:  v3.ValidUntil,
+			Seqno:       v3.Seqno,
+			RawMessages: v3.RawMessages,
+		}, options)
+	case HighLoadV2R2:
+		hl, err := DecodeHighloadV2Message(msg, opts...)
+		if err != nil {
+			return nil, err
+		}
+		validUntil, _ := UnpackHighloadQueryID(hl.BoundedQueryID)
+		return checkDecodedMessage(&DecodedMessage{
+			Version:     ver,
+			SubWalletId: hl.SubWalletId,
+			ValidUntil:  validUntil,
+			RawMessages: hl.RawMessages,
+		}, options)
+	case HighLoadV1R1, HighLoadV1R2:
+		hl, err := DecodeHighloadV1Message(msg, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return checkDecodedMessage(&DecodedMessage{
+			Version:     ver,
+			SubWalletId: hl.SubWalletId,
+			ValidUntil:  hl.ValidUntil,
+			Seqno:       hl.Seqno,
+			RawMessages: hl.RawMessages,
+		}, options)
+	default:
+		return nil, fmt.Errorf("wallet version is not supported: %v", ver)
+	}
+}
+
+// DecodeWithOptions decodes an external message sent to a wallet of the given version
+// according to the given policy, see DecodeOptions.
+// Requests of wallet v5 extensions carry no subwallet id and valid-until, so they are not checked against them.
+func DecodeWithOptions(ver Version, msg *boc.Cell, opts DecodeOptions) (*DecodedMessage, error) {
+	return DecodeMessage(ver, msg, func(o *DecodeOptions) {
+		*o = opts
+	})
+}
+
+// WalletID returns a wallet id of the given external message as a uint64, so it can be used as a key across versions.
+// For v3, v4 and highload wallets, it is the 32-bit subwallet id.
+// For wallet v5, it is the low 64 bits of the 80-bit wallet id, that is,
+// the last 16 bits of the network global id followed by the workchain, the wallet version and the subwallet number, see WalletV5ID.
+// The projections of different versions can collide, so the version should be a part of a key as well.
+// Requests of wallet v5 extensions carry no wallet id, and an error is returned for them.
+func WalletID(ver Version, msg *boc.Cell) (uint64, error) {
+	if ver != V5R1 {
+		m, err := DecodeMessage(ver, msg)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(m.SubWalletId), nil
+	}
+	v5, err := DecodeMessageV5(msg)
+	if err != nil {
+		return 0, err
+	}
+	request := v5.signedRequest()
+	if request == nil {
+		return 0, fmt.Errorf("v5 message of type %v has no wallet id", v5.SumType)
+	}
+	return binary.BigEndian.Uint64(request.SubWalletId[2:]), nil
+}
+
+// checkDecodedMessage checks the subwallet id and the valid-until of the given signed message
+// if the options require it.
+func checkDecodedMessage(m *DecodedMessage, options DecodeOptions) (*DecodedMessage, error) {
+	if options.ExpectedSubWalletId != nil && m.SubWalletId != *options.ExpectedSubWalletId {
+		return nil, fmt.Errorf("%w: want %v, got %v", ErrSubWalletMismatch, *options.ExpectedSubWalletId, m.SubWalletId)
+	}
+	if options.Now == 0 {
+		return m, nil
+	}
+	maxHorizon := options.MaxHorizon
+	if maxHorizon == 0 {
+		maxHorizon = math.MaxUint32
+	}
+	if err := ValidateValidUntil(m.ValidUntil, options.Now, maxHorizon); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ToOutActions returns the messages as the c5 out list of send_msg actions the wallet installs when it accepts the message.
+// The list is encoded in the canonical out_list layout by tlb.Marshal, so it can be compared with the output actions of an emulated compute phase.
+func (d *DecodedMessage) ToOutActions() (SendMessageList, error) {
+	if len(d.RawMessages) > maxV5Instructions {
+		return SendMessageList{}, fmt.Errorf("out list has more than %v actions", maxV5Instructions)
+	}
+	actions := make([]SendMessageAction, 0, len(d.RawMessages))
+	for i, msg := range d.RawMessages {
+		if msg.Message == nil {
+			return SendMessageList{}, fmt.Errorf("message %v has no cell", i)
+		}
+		actions = append(actions, SendMessageAction{Mode: msg.Mode, Msg: msg.Message})
+	}
+	return SendMessageList{Actions: actions}, nil
+}
+
+// DecodeMessagesFromBOC decodes every root of the given BOC as an external message sent to a wallet.
+// The version of each message is detected automatically, see DetectVersion.
+// The returned slice has an entry for every root, failed roots are left nil and their errors are returned as DecodeErrors.
+func DecodeMessagesFromBOC(data []byte) ([]*DecodedMessage, error) {
+	return DecodeMessagesFromBOCWithOptions(data)
+}
+
+// ErrNoCRC32C is returned by DecodeMessagesFromBOCWithOptions
+// if a BOC has no crc32c hashsum while WithCRCCheck(true) is set.
+var ErrNoCRC32C = errors.New("boc has no crc32c hashsum")
+
+// BOCOptions configures decoding of a BOC, see DecodeMessagesFromBOCWithOptions.
+type BOCOptions struct {
+	// CRCCheck requires a BOC to carry a crc32c hashsum.
+	CRCCheck bool
+}
+
+type BOCOption func(o *BOCOptions)
+
+// WithCRCCheck makes DecodeMessagesFromBOCWithOptions fail with ErrNoCRC32C if a BOC has no crc32c hashsum.
+// A hashsum present in a BOC is always verified, and boc.ErrCRC32CMismatch is returned on mismatch
+// before any message is decoded, so transport corruption can be told apart from decoding errors.
+func WithCRCCheck(check bool) BOCOption {
+	return func(o *BOCOptions) {
+		o.CRCCheck = check
+	}
+}
+
+// DecodeMessagesFromBOCWithOptions works like DecodeMessagesFromBOC and accepts options to check the BOC itself.
+func DecodeMessagesFromBOCWithOptions(data []byte, opts ...BOCOption) ([]*DecodedMessage, error) {
+	options := BOCOptions{}
+	for _, o := range opts {
+		o(&options)
+	}
+	if options.CRCCheck {
+		hasCRC, err := boc.HasCRC32C(data)
+		if err != nil {
+			return nil, err
+		}
+		if !hasCRC {
+			return nil, ErrNoCRC32C
+		}
+	}
+	roots, err := boc.DeserializeBoc(data)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*DecodedMessage, len(roots))
+	var errs DecodeErrors
+	for i, root := range roots {
+		ver, err := DetectVersion(root)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("root %v: %w", i, err))
+			continue
+		}
+		msg, err := DecodeMessage(ver, root)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("root %v: %w", i, err))
+			continue
+		}
+		messages[i] = msg
+	}
+	if len(errs) > 0 {
+		return messages, errs
+	}
+	return messages, nil
+}
+
+// IndexedRawMessage is a message sent by a wallet in the transaction with the given index,
+// see RawMessagesFromTransactionBOCs.
+type IndexedRawMessage struct {
+	// TransactionIndex is an index of a BOC of the transaction.
+	TransactionIndex int
+	// Version is a detected version of the wallet.
+	Version    Version
+	RawMessage RawMessage
+}
+
+// RawMessagesFromTransactionBOCs decodes each of the given BOCs as a transaction and
+// extracts messages from its inbound external message, the version of a wallet is detected by DetectVersion.
+// Transactions without an inbound external message are skipped.
+// Messages of all other transactions are returned, and errors of failed transactions are returned as DecodeErrors.
+func RawMessagesFromTransactionBOCs(blobs [][]byte) ([]IndexedRawMessage, error) {
+	var messages []IndexedRawMessage
+	var errs DecodeErrors
+	for i, blob := range blobs {
+		msgs, ver, err := rawMessagesFromTransactionBOC(blob)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("transaction %v: %w", i, err))
+			continue
+		}
+		for _, msg := range msgs {
+			messages = append(messages, IndexedRawMessage{TransactionIndex: i, Version: ver, RawMessage: msg})
+		}
+	}
+	if len(errs) > 0 {
+		return messages, errs
+	}
+	return messages, nil
+}
+
+func rawMessagesFromTransactionBOC(blob []byte) ([]RawMessage, Version, error) {
+	roots, err := boc.DeserializeBoc(blob)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(roots) != 1 {
+		return nil, 0, fmt.Errorf("invalid boc roots number %v", len(roots))
+	}
+	var tx tlb.Transaction
+	if err := tlb.Unmarshal(roots[0], &tx); err != nil {
+		return nil, 0, err
+	}
+	if !tx.Msgs.InMsg.Exists {
+		return nil, 0, nil
+	}
+	inMsg := tx.Msgs.InMsg.Value.Value
+	if inMsg.Info.SumType != "ExtInMsgInfo" {
+		return nil, 0, nil
+	}
+	ver, err := detectVersion(&inMsg)
+	if err != nil {
+		return nil, 0, err
+	}
+	msgs, err := ExtractRawMessagesFromTLBMessage(ver, &inMsg)
+	if err != nil {
+		return nil, 0, err
+	}
+	return msgs, ver, nil
+}
+
+// DetectVersion detects a version of a wallet the given external message is sent to.
+// If the message carries a StateInit with a known code, the version is taken from it, see VersionFromStateInit.
+// Otherwise, the version is guessed by the layout of the message body.
+// Revisions of the same wallet share a layout, so V3R2 and V4R2 are returned for v3 and v4 wallets.
+func DetectVersion(msg *boc.Cell) (Version, error) {
+	var m tlb.Message
+	if err := tlb.Unmarshal(msg, &m); err != nil {
+		return 0, err
+	}
+	return detectVersion(&m)
+}
+
+// detectVersion works like DetectVersion for an already decoded external message.
+func detectVersion(m *tlb.Message) (Version, error) {
+	if m.Init.Exists {
+		if ver, ok := VersionFromStateInit(m.Init.Value.Value); ok {
+			return ver, nil
+		}
+	}
+	body := boc.Cell(m.Body.Value)
+	if ver, ok := probeVersion(&body); ok {
+		return ver, nil
+	}
+	return 0, fmt.Errorf("unknown wallet message layout")
+}
+
+// DetectVersionMismatch reports whether the layout of the body of the given external message
+// doesn't match the declared wallet version, for example, a message signed for a wallet v4 sent to a wallet v5.
+// The actual version is guessed by the layout of the body, see ProbeVersion,
+// and it is the declared version itself if there is no mismatch.
+// A StateInit of the message is not taken into account because it doesn't affect how a wallet decodes the body.
+func DetectVersionMismatch(declaredVer Version, msg *boc.Cell) (actualVer Version, mismatch bool, err error) {
+	var m tlb.Message
+	if err := tlb.Unmarshal(msg, &m); err != nil {
+		return 0, false, err
+	}
+	body := boc.Cell(m.Body.Value)
+	versions := ProbeVersion(&body)
+	if len(versions) == 0 {
+		return 0, false, fmt.Errorf("unknown wallet message layout")
+	}
+	for _, ver := range versions {
+		if ver == declaredVer {
+			return declaredVer, false, nil
+		}
+	}
+	return versions[len(versions)-1], true, nil
+}
+
+// bestEffortVersions is an order in which DecodeBestEffort tries wallet versions after the detected one.
+// Layouts of v5 messages are the most specific and layouts of v3 messages the least.
+// Revisions of the same wallet decode messages the same way, so only the latest one is tried.
+// Highload wallets v1 aren't tried: a v1 message has the same layout as a v2 message,
+// its valid until and seqno take the place of the bounded query id, so it always decodes as HighLoadV2R2.
+// Only DetectVersion can tell them apart, by the code in a StateInit of the message.
+var bestEffortVersions = []Version{V5R1, V4R2, HighLoadV2R2, V3R2}
+
+// DecodeBestEffort decodes an external message sent to a wallet of an unknown version.
+// It tries the version detected by DetectVersion first and then the rest of supported versions,
+// and returns the result of the first version that decodes the message in strict mode, so that the whole body is read.
+// Unless the message carries a StateInit of a known wallet, the latest revision of the wallet is reported
+// because revisions share a message layout, and a highload wallet v1 is reported as HighLoadV2R2, see bestEffortVersions.
+// If no version decodes the message, errors of all attempts are returned as DecodeErrors.
+func DecodeBestEffort(msg *boc.Cell) (*DecodedMessage, error) {
+	versions := make([]Version, 0, len(bestEffortVersions)+1)
+	if ver, err := DetectVersion(msg); err == nil {
+		versions = append(versions, ver)
+	}
+	versions = append(versions, bestEffortVersions...)
+	tried := make(map[Version]bool, len(versions))
+	var errs DecodeErrors
+	for _, ver := range versions {
+		if tried[ver] {
+			continue
+		}
+		tried[ver] = true
+		decoded, err := DecodeMessage(ver, msg, strictDecoding)
+		if err == nil {
+			return decoded, nil
+		}
+		errs = append(errs, fmt.Errorf("%v: %w", ver.ToString(), err))
+	}
+	return nil, errs
+}
+
+const (
+	// v5SignedRequestBits is a number of bits of a wallet v5 message body with a basic action list:
+	// opcode, wallet id, valid until, seqno, action list tag and signature.
+	v5SignedRequestBits = 32 + 80 + 32 + 32 + 1 + 512
+	// v5ExtensionRequestBits is a number of bits of a wallet v5 request of an extension with a basic action list:
+	// opcode, query id and action list tag.
+	v5ExtensionRequestBits = 32 + 64 + 1
+	// v3HeaderBits is a number of bits of subwallet id, valid until and seqno.
+	v3HeaderBits = 32 + 32 + 32
+	// v4HeaderBits is v3HeaderBits followed by an 8-bit op.
+	v4HeaderBits = v3HeaderBits + 8
+	// v4PluginDeploymentBits is a number of bits of v4HeaderBits followed by a workchain and
+	// the length of the balance of a deployed plugin, the balance itself follows, see PluginDeployment.
+	v4PluginDeploymentBits = v4HeaderBits + 8 + 4
+	// highloadV2HeaderBits is a number of bits of subwallet id, bounded query id and a dictionary tag.
+	highloadV2HeaderBits = 32 + 64 + 1
+)
+
+// ProbeVersion returns wallet versions whose message layout matches the unread part of the given body.
+// Only the number of bits and refs, the v5 opcodes and the header of v4 plugin deployments are checked,
+// the body is not decoded,
+// so the body may still fail to decode as any of the returned versions.
+// Revisions of the same wallet share a message layout, so all of them are returned,
+// and so do highload wallets v1 and v2.
+// nil is returned if the body doesn't match any supported layout.
+// ProbeVersion doesn't change the read cursor of the body.
+func ProbeVersion(body *boc.Cell) []Version {
+	ver, ok := probeVersion(body)
+	if !ok {
+		return nil
+	}
+	switch ver {
+	case V3R2:
+		return []Version{V3R1, V3R2}
+	case V4R2:
+		return []Version{V4R1, V4R2}
+	case HighLoadV2R2:
+		// highload wallets v1 and v2 have headers of the same size.
+		return []Version{HighLoadV1R1, HighLoadV1R2, HighLoadV2R2}
+	default:
+		return []Version{ver}
+	}
+}
+
+// probeVersion guesses a wallet version by the number of bits and refs in the unread part of the given body.
+// It doesn't change the read cursor of the body.
+func probeVersion(body *boc.Cell) (Version, bool) {
+	bits := body.BitsAvailableForRead()
+	refs := body.RefsAvailableForRead()
+	// extended actions are stored inline, so an extended action list adds bits,
+	// and a basic action list without send actions can omit its ref.
+	if bits >= v5SignedRequestBits && refs <= 1 {
+		prefix, err := body.PickUint(32)
+		if err == nil && (prefix == 0x7369676e || prefix == 0x73696e74) {
+			return V5R1, true
+		}
+	}
+	if bits >= v5ExtensionRequestBits && refs <= 1 {
+		prefix, err := body.PickUint(32)
+		if err == nil && prefix == 0x6578746e {
+			return V5R1, true
+		}
+	}
+	if bits < 512 {
+		return 0, false
+	}
+	bits -= 512
+	switch {
+	case bits == v3HeaderBits+8*refs:
+		return V3R2, true
+	case bits == v4HeaderBits+8*refs:
+		return V4R2, true
+	case bits == highloadV2HeaderBits && refs == 1:
+		return HighLoadV2R2, true
+	case bits >= v4PluginDeploymentBits && refs == 2 && isV4PluginDeployment(body):
+		return V4R2, true
+	default:
+		return 0, false
+	}
+}
+
+// isV4PluginDeployment reports whether the unread part of the given body is a signed message of a wallet v4
+// that deploys and installs a plugin. It doesn't change the read cursor of the body.
+func isV4PluginDeployment(body *boc.Cell) bool {
+	c := body.CopyRemaining()
+	if _, err := c.ReadBits(512 + v3HeaderBits); err != nil {
+		return false
+	}
+	op, err := c.ReadUint(8)
+	if err != nil || op != opDeployAndInstallPlugin {
+		return false
+	}
+	if _, err := c.ReadInt(8); err != nil {
+		return false
+	}
+	var balance tlb.Grams
+	if err := tlb.Unmarshal(c, &balance); err != nil {
+		return false
+	}
+	return c.BitsAvailableForRead() == 0 && c.RefsAvailableForRead() == 2
+}
+
+type decodedMessageJSON struct {
+	Version     string              `json:"version"`
+	SubWalletId uint32              `json:"subwallet_id"`
+	ValidUntil  string              `json:"valid_until"`
+	Seqno       uint32              `json:"seqno"`
+	Messages    []decodedRawMsgJSON `json:"messages"`
+}
+
+type decodedRawMsgJSON struct {
+	Destination tlb.MsgAddress `json:"destination"`
+	Value       tlb.Grams      `json:"value"`
+	Mode        byte           `json:"mode"`
+	ModeFlags   []string       `json:"mode_flags"`
+	Comment     *string        `json:"comment,omitempty"`
+
+	// bounce is not a part of the JSON schema, it is used by DecodedMessage.Rows.
+	bounce *bool
+}
+
+var messageModeNames = []struct {
+	mode MessageMode
+	name string
+}{
+	{PayFeesSeparately, "pay_fees_separately"},
+	{IgnoreErrors, "ignore_errors"},
+	{BounceOnActionFail, "bounce_on_action_fail"},
+	{DestroyAccount, "destroy_account"},
+	{AttachAllRemainingBalanceOfInboundMessage, "attach_all_remaining_balance_of_inbound_message"},
+	{AttachAllRemainingBalance, "attach_all_remaining_balance"},
+}
+
+// MarshalJSON encodes the message with a stable schema:
+// the version is a string returned by Version.ToString, valid_until is formatted as RFC3339 in UTC,
+// and every raw message has destination, value, mode, mode_flags and an optional text comment.
+func (d DecodedMessage) MarshalJSON() ([]byte, error) {
+	res := decodedMessageJSON{
+		Version:     d.Version.ToString(),
+		SubWalletId: d.SubWalletId,
+		ValidUntil:  time.Unix(int64(d.ValidUntil), 0).UTC().Format(time.RFC3339),
+		Seqno:       d.Seqno,
+		Messages:    make([]decodedRawMsgJSON, 0, len(d.RawMessages)),
+	}
+	for i, rawMsg := range d.RawMessages {
+		msg, err := decodeRawMsgJSON(rawMsg)
+		if err != nil {
+			return nil, fmt.Errorf("message %v: %w", i, err)
+		}
+		res.Messages = append(res.Messages, msg)
+	}
+	return json.Marshal(res)
+}
+
+// decodeRawMsgJSON decodes the destination, value, mode flags and text comment of the given outgoing message.
+func decodeRawMsgJSON(rawMsg RawMessage) (decodedRawMsgJSON, error) {
+	m, err := rawMsg.ToTLBMessage()
+	if err != nil {
+		return decodedRawMsgJSON{}, fmt.Errorf("failed to decode message: %w", err)
+	}
+	msg := decodedRawMsgJSON{
+		Mode:      rawMsg.Mode,
+		ModeFlags: []string{},
+	}
+	switch m.Info.SumType {
+	case "IntMsgInfo":
+		msg.Destination = m.Info.IntMsgInfo.Dest
+		msg.Value = m.Info.IntMsgInfo.Value.Grams
+		msg.bounce = &m.Info.IntMsgInfo.Bounce
+	case "ExtOutMsgInfo":
+		msg.Destination = m.Info.ExtOutMsgInfo.Dest
+	default:
+		return decodedRawMsgJSON{}, fmt.Errorf("not an outgoing message")
+	}
+	for _, item := range messageModeNames {
+		if IsMessageModeSet(int(rawMsg.Mode), item.mode) {
+			msg.ModeFlags = append(msg.ModeFlags, item.name)
+		}
+	}
+	body := boc.Cell(m.Body.Value)
+	var comment TextComment
+	if err := tlb.Unmarshal(&body, &comment); err == nil {
+		text := string(comment)
+		msg.Comment = &text
+	}
+	return msg, nil
+}
+
+// RowColumns are names of columns of rows returned by DecodedMessage.Rows.
+var RowColumns = []string{"index", "destination", "amount", "mode", "comment", "bounce"}
+
+// Rows returns a row for each message of this wallet message, for example, to export messages to CSV, see RowColumns.
+// The index starts from 1, the amount is in TON, and the bounce flag is empty for external messages.
+// A message that can't be decoded gets a row with its index only.
+// The seqno, valid until and other fields of the wallet message itself are returned by MetadataRows.
+func (d *DecodedMessage) Rows() [][]string {
+	rows := make([][]string, 0, len(d.RawMessages))
+	for i, rawMsg := range d.RawMessages {
+		row := make([]string, len(RowColumns))
+		row[0] = strconv.Itoa(i + 1)
+		rows = append(rows, row)
+		m, err := decodeRawMsgJSON(rawMsg)
+		if err != nil {
+			continue
+		}
+		dest, err := m.Destination.MarshalJSON()
+		if err != nil {
+			continue
+		}
+		row[1] = strings.Trim(string(dest), `"`)
+		row[2] = formatTON(m.Value)
+		row[3] = strconv.Itoa(int(m.Mode))
+		if m.Comment != nil {
+			row[4] = *m.Comment
+		}
+		if m.bounce != nil {
+			row[5] = strconv.FormatBool(*m.bounce)
+		}
+	}
+	return rows
+}
+
+// MetadataRows returns name and value pairs of the fields of this wallet message, which are the same for all its Rows.
+func (d *DecodedMessage) MetadataRows() [][]string {
+	return [][]string{
+		{"version", d.Version.ToString()},
+		{"subwallet", strconv.FormatUint(uint64(d.SubWalletId), 10)},
+		{"seqno", strconv.FormatUint(uint64(d.Seqno), 10)},
+		{"valid until", time.Unix(int64(d.ValidUntil), 0).UTC().Format(time.RFC3339)},
+	}
+}
+
+// formatTON formats the given amount of nanotons as a decimal number of TON without trailing zeros.
+func formatTON(amount tlb.Grams) string {
+	const nanotons = 1_000_000_000
+	fraction := strings.TrimRight(fmt.Sprintf("%09d", uint64(amount)%nanotons), "0")
+	if fraction == "" {
+		return strconv.FormatUint(uint64(amount)/nanotons, 10)
+	}
+	return fmt.Sprintf("%d.%s", uint64(amount)/nanotons, fraction)
+}
+
+// DecodeReport detects a version of a wallet the given external message is sent to, decodes the message
+// and returns a human-readable multi-line report about it: the version, seqno, valid until time, subwallet id
+// and a numbered list of messages with their destinations, amounts, modes and text comments.
+// A message that can't be decoded is reported with its error instead of failing the whole report.
+// If the external message itself can't be decoded, the report contains only the version
+// and is returned along with the error.
+func DecodeReport(msg *boc.Cell) (string, error) {
+	ver, err := DetectVersion(msg)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "version: %v\n", ver.ToString())
+	decoded, err := DecodeMessage(ver, msg)
+	if err != nil {
+		fmt.Fprintf(&b, "failed to decode: %v\n", err)
+		return b.String(), err
+	}
+	fmt.Fprintf(&b, "seqno: %v\n", decoded.Seqno)
+	fmt.Fprintf(&b, "valid until: %v\n", time.Unix(int64(decoded.ValidUntil), 0).UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "subwallet: %v\n", decoded.SubWalletId)
+	fmt.Fprintf(&b, "messages: %v\n", len(decoded.RawMessages))
+	for i, rawMsg := range decoded.RawMessages {
+		m, err := decodeRawMsgJSON(rawMsg)
+		if err != nil {
+			fmt.Fprintf(&b, "  %v. %v\n", i+1, err)
+			continue
+		}
+		dest, err := m.Destination.MarshalJSON()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "  %v. to %s, amount %v, mode %v", i+1, strings.Trim(string(dest), `"`), uint64(m.Value), m.Mode)
+		if len(m.ModeFlags) > 0 {
+			fmt.Fprintf(&b, " (%v)", strings.Join(m.ModeFlags, ", "))
+		}
+		if m.Comment != nil {
+			fmt.Fprintf(&b, ", comment %q", *m.Comment)
+		}
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,614 @@
+package wallet
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+)
+
+func TestDecodeMessagesFromBOC(t *testing.T) {
+	tests := []struct {
+		name       string
+		boc        string
+		want       Version
+		wantSeqno  uint32
+		wantMsgQty int
+	}{
+		{
+			name:       "v4",
+			boc:        "te6ccgEBAgEAqgAB4YgA2ZpktQsYby0n9cV5VWOFINBjScIU2HdondFsK3lDpEAAQ+B903cV6YIMdtd4QtdyekehadSk+QjIgoIiRgjZD9v81PVGEXBKHPgPUknVvxvr/LGcKkLNhY+I1Wuwi/7ACU1NGLsi5dhQAAAA8AAcAQBoQgApn5hvK5EKvcI4+qgdz+LABkbBy/PLofvLWI8wTW1zT6WWgvAAAAAAAAAAAAAAAAAAAA==",
+			want:       V4R2,
+			wantSeqno:  30,
+			wantMsgQty: 1,
+		},
+		{
+			name:       "v5",
+			boc:        "te6ccgECCAEAAZ4AAfGIAehvqHPiQ2Ru+zkowjJx/7oJbqEYRnlCOuPe5+2gm24WA5tLO3f////oAAAAAAADMYd8kAAAAAEHzN670eqqNU3yWGkX1dOynyAbT7DN4cFDpE0r+nInTomGrifjPTaZvG3YxYzTHpLoNesGc9s5Q0tHlLNcFNQeAQIKDsPIbQMCAwIKDsPIbQMEBQCpaAHob6hz4kNkbvs5KMIycf+6CW6hGEZ5Qjrj3uftoJtuFwAbM0yWoWMN5aT+uK8qrHCkGgxpOEKbDu0Tui2Fbyh0iAy3GwAAAAAAAAAAAAAAAAAAQAIKDsPIbQMGBwCpaAHob6hz4kNkbvs5KMIycf+6CW6hGEZ5Qjrj3uftoJtuFwAbM0yWoWMN5aT+uK8qrHCkGgxpOEKbDu0Tui2Fbyh0iAx6EgAAAAAAAAAAAAAAAAAAQAAAAKloAehvqHPiQ2Ru+zkowjJx/7oJbqEYRnlCOuPe5+2gm24XABszTJahYw3lpP64ryqscKQaDGk4QpsO7RO6LYVvKHSIDD0JAAAAAAAAAAAAAAAAAABA",
+			want:       V5R1,
+			wantSeqno:  0,
+			wantMsgQty: 3,
+		},
+		{
+			name:       "highload",
+			boc:        "te6ccgECCQEAAUMAAUWIAbeTPaOhIeFpX00pVBankGP2F/kaObq5EAdGLvI+omE+DAEBmXzKceTPz+weyz8nYZbOkpsBYbvy6gN7h38ZVL6RTqln7XbUzHkQqxRp1B1ZYkBgMW1NtE7r8Jwg26HcS3qPiwYAAYiUZMJyTpfTrVXAAgIFngACAwQBAwDgBQEDAOAHAWJCADZmmS1CxhvLSf1xXlVY4Ug0GNJwhTYd2id0WwreUOkQCKAAAAAAAAAAAAAAAAABBgBQAAAAADcwMzBhYzQ2LWI5NWMtNDRjNy04ZDdiLTYxMjMyNmU2ZTUxMgFiQgA2ZpktQsYby0n9cV5VWOFINBjScIU2HdondFsK3lDpEAlAAAAAAAAAAAAAAAAAAQgAUAAAAAAzYjA2OTU1YS03YjRjLTQ1YWEtOTVlNy0wNTI4ZWZhYjAyM2E=",
+			want:       HighLoadV2R2,
+			wantSeqno:  0,
+			wantMsgQty: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := base64.StdEncoding.DecodeString(tt.boc)
+			if err != nil {
+				t.Fatalf("DecodeString() failed: %v", err)
+			}
+			msgs, err := DecodeMessagesFromBOC(data)
+			if err != nil {
+				t.Fatalf("DecodeMessagesFromBOC() failed: %v", err)
+			}
+			if len(msgs) != 1 {
+				t.Fatalf("want 1 message, got %v", len(msgs))
+			}
+			if msgs[0].Version != tt.want {
+				t.Fatalf("want version %v, got %v", tt.want.ToString(), msgs[0].Version.ToString())
+			}
+			if msgs[0].Seqno != tt.wantSeqno {
+				t.Fatalf("want seqno %v, got %v", tt.wantSeqno, msgs[0].Seqno)
+			}
+			if len(msgs[0].RawMessages) != tt.wantMsgQty {
+				t.Fatalf("want %v raw messages, got %v", tt.wantMsgQty, len(msgs[0].RawMessages))
+			}
+		})
+	}
+}
+
+func TestDecodeMessagesFromBOCWithOptions(t *testing.T) {
+	const v4 = "te6ccgEBAgEAqgAB4YgA2ZpktQsYby0n9cV5VWOFINBjScIU2HdondFsK3lDpEAAQ+B903cV6YIMdtd4QtdyekehadSk+QjIgoIiRgjZD9v81PVGEXBKHPgPUknVvxvr/LGcKkLNhY+I1Wuwi/7ACU1NGLsi5dhQAAAA8AAcAQBoQgApn5hvK5EKvcI4+qgdz+LABkbBy/PLofvLWI8wTW1zT6WWgvAAAAAAAAAAAAAAAAAAAA=="
+	root, err := boc.DeserializeSinglRootBase64(v4)
+	if err != nil {
+		t.Fatalf("DeserializeSinglRootBase64() failed: %v", err)
+	}
+	withCRC, err := boc.SerializeBoc(root, false, true, false, 0)
+	if err != nil {
+		t.Fatalf("SerializeBoc() failed: %v", err)
+	}
+	withoutCRC, err := boc.SerializeBoc(root, false, false, false, 0)
+	if err != nil {
+		t.Fatalf("SerializeBoc() failed: %v", err)
+	}
+	corrupted := append([]byte{}, withCRC...)
+	corrupted[len(corrupted)-1] ^= 0xff
+
+	tests := []struct {
+		name    string
+		data    []byte
+		opts    []BOCOption
+		wantErr error
+	}{
+		{name: "with crc", data: withCRC, opts: []BOCOption{WithCRCCheck(true)}},
+		{name: "without crc", data: withoutCRC},
+		{name: "crc required", data: withoutCRC, opts: []BOCOption{WithCRCCheck(true)}, wantErr: ErrNoCRC32C},
+		{name: "corrupted crc", data: corrupted, opts: []BOCOption{WithCRCCheck(true)}, wantErr: boc.ErrCRC32CMismatch},
+		{name: "corrupted crc without check", data: corrupted, wantErr: boc.ErrCRC32CMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs, err := DecodeMessagesFromBOCWithOptions(tt.data, tt.opts...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr == nil && (len(msgs) != 1 || msgs[0].Version != V4R2) {
+				t.Fatalf("unexpected messages: %v", msgs)
+			}
+		})
+	}
+}
+
+func TestDecodedMessage_MarshalJSON(t *testing.T) {
+	msg := DecodedMessage{
+		Version:     V4R2,
+		SubWalletId: DefaultSubWallet,
+		ValidUntil:  1700000000,
+		Seqno:       7,
+		RawMessages: []RawMessage{
+			{
+				Message: mustFromHex("te6ccgEBAQEANgAAaEIAKZ+YbyuRCr3COPqoHc/iwAZGwcvzy6H7y1iPME1tc0+lloLwAAAAAAAAAAAAAAAAAAA="),
+				Mode:    3,
+			},
+		},
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	want := `{"version":"v4R2","subwallet_id":698983191,"valid_until":"2023-11-14T22:13:20Z","seqno":7,"messages":[{"destination":"0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f","value":"3000000000","mode":3,"mode_flags":["pay_fees_separately","ignore_errors"]}]}`
+	if string(data) != want {
+		t.Fatalf("want: %s\n got: %s", want, data)
+	}
+	// the message cells are read from the beginning every time.
+	again, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("second Marshal() failed: %v", err)
+	}
+	if string(again) != want {
+		t.Fatalf("second Marshal(): want: %s\n got: %s", want, again)
+	}
+}
+
+func TestProbeVersion(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	msgs := []RawMessage{mustRawMessage(t, Message{Amount: 1_000, Mode: 3})}
+	hdr := MessageHeader{
+		SubWalletId:    DefaultSubWallet,
+		WalletID:       [10]byte{0xff, 0xff, 0xff, 0x11},
+		ValidUntil:     1_700_000_000,
+		Seqno:          5,
+		BoundedQueryID: 1_700_000_000 << 32,
+	}
+	tests := []struct {
+		ver  Version
+		want []Version
+	}{
+		{ver: V3R2, want: []Version{V3R1, V3R2}},
+		{ver: V4R2, want: []Version{V4R1, V4R2}},
+		{ver: HighLoadV2R2, want: []Version{HighLoadV1R1, HighLoadV1R2, HighLoadV2R2}},
+		{ver: HighLoadV1R2, want: []Version{HighLoadV1R1, HighLoadV1R2, HighLoadV2R2}},
+		{ver: V5R1, want: []Version{V5R1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ver.ToString(), func(t *testing.T) {
+			cell, err := AssembleExternalMessage(tt.ver, privateKey, hdr, msgs)
+			if err != nil {
+				t.Fatalf("AssembleExternalMessage() failed: %v", err)
+			}
+			var m tlb.Message
+			if err := tlb.Unmarshal(cell, &m); err != nil {
+				t.Fatalf("Unmarshal() failed: %v", err)
+			}
+			body := boc.Cell(m.Body.Value)
+			bits := body.BitsAvailableForRead()
+			if got := ProbeVersion(&body); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+			if body.BitsAvailableForRead() != bits {
+				t.Fatalf("ProbeVersion() must not move the cursor")
+			}
+		})
+	}
+	extension := boc.NewCell()
+	if err := extension.WriteUint(0x6578746e, 32); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	if err := extension.WriteUint(42, 64+1); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	if err := extension.AddRef(boc.NewCell()); err != nil {
+		t.Fatalf("AddRef() failed: %v", err)
+	}
+	if got := ProbeVersion(extension); !reflect.DeepEqual(got, []Version{V5R1}) {
+		t.Fatalf("want V5R1 for an extension request, got %v", got)
+	}
+
+	// a wallet v4 message deploying a plugin carries a plugin balance instead of send modes.
+	deployment := boc.NewCell()
+	if err := deployment.WriteBytes(make([]byte, 64)); err != nil {
+		t.Fatalf("WriteBytes() failed: %v", err)
+	}
+	for _, f := range []struct {
+		value uint64
+		bits  int
+	}{{698983191, 32}, {1_700_000_000, 32}, {3, 32}, {opDeployAndInstallPlugin, 8}, {0, 8}} {
+		if err := deployment.WriteUint(f.value, f.bits); err != nil {
+			t.Fatalf("WriteUint() failed: %v", err)
+		}
+	}
+	if err := tlb.Marshal(deployment, tlb.Grams(50_000_000)); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := deployment.AddRef(boc.NewCell()); err != nil {
+			t.Fatalf("AddRef() failed: %v", err)
+		}
+	}
+	if got := ProbeVersion(deployment); !reflect.DeepEqual(got, []Version{V4R1, V4R2}) {
+		t.Fatalf("want v4 for a plugin deployment, got %v", got)
+	}
+	if got := ProbeVersion(boc.NewCell()); got != nil {
+		t.Fatalf("want nil for an empty body, got %v", got)
+	}
+}
+
+func TestDecodeReport(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	recipient := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	broken := boc.NewCell()
+	if err := broken.WriteUint(0xff, 8); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	msgs := []RawMessage{
+		mustRawMessage(t, SimpleTransfer{Amount: 3_000_000_000, Address: recipient, Comment: "hello"}),
+		{Message: broken, Mode: 3},
+	}
+	hdr := MessageHeader{SubWalletId: DefaultSubWallet, ValidUntil: 1_700_000_000, Seqno: 7}
+	msg, err := AssembleExternalMessage(V4R2, privateKey, hdr, msgs)
+	if err != nil {
+		t.Fatalf("AssembleExternalMessage() failed: %v", err)
+	}
+	report, err := DecodeReport(msg)
+	if err != nil {
+		t.Fatalf("DecodeReport() failed: %v", err)
+	}
+	want := `version: v4R2
+seqno: 7
+valid until: 2023-11-14T22:13:20Z
+subwallet: 698983191
+messages: 2
+  1. to 0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f, amount 3000000000, mode 3 (pay_fees_separately, ignore_errors), comment "hello"
+  2. failed to decode message: `
+	if !strings.HasPrefix(report, want) {
+		t.Fatalf("want a report starting with:\n%v\ngot:\n%v", want, report)
+	}
+}
+
+func TestDecodedMessage_ToOutActions(t *testing.T) {
+	recipient := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	msgs := []RawMessage{
+		mustRawMessage(t, SimpleTransfer{Amount: 1_000, Address: recipient, Comment: "first"}),
+		mustRawMessage(t, SimpleTransfer{Amount: 2_000, Address: recipient, Comment: "second"}),
+	}
+	msgs[1].Mode = 1
+	decoded := DecodedMessage{Version: V4R2, RawMessages: msgs}
+	outList, err := decoded.ToOutActions()
+	if err != nil {
+		t.Fatalf("ToOutActions() failed: %v", err)
+	}
+	c5 := boc.NewCell()
+	if err := tlb.Marshal(c5, outList); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	instructions, err := DecodeV5Instructions(c5)
+	if err != nil {
+		t.Fatalf("DecodeV5Instructions() failed: %v", err)
+	}
+	if len(instructions) != len(msgs) {
+		t.Fatalf("want %v actions, got %v", len(msgs), len(instructions))
+	}
+	for i, instruction := range instructions {
+		if instruction.SumType != "SendMsg" || instruction.SendMsg.Mode != msgs[i].Mode {
+			t.Fatalf("unexpected action %v: %+v", i, instruction)
+		}
+		got, _ := instruction.SendMsg.Msg.Hash256()
+		want, _ := msgs[i].Message.Hash256()
+		if got != want {
+			t.Fatalf("action %v carries another message", i)
+		}
+	}
+	decoded.RawMessages = append(decoded.RawMessages, RawMessage{Mode: 3})
+	if _, err := decoded.ToOutActions(); err == nil {
+		t.Fatalf("ToOutActions() had to fail but it didn't")
+	}
+}
+
+func TestDecodeWithOptions(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	recipient := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	msgs := []RawMessage{mustRawMessage(t, SimpleTransfer{Amount: 1_000, Address: recipient})}
+	hdr := MessageHeader{SubWalletId: DefaultSubWallet, ValidUntil: 1_700_000_000, Seqno: 7}
+	msg, err := AssembleExternalMessage(V4R2, privateKey, hdr, msgs)
+	if err != nil {
+		t.Fatalf("AssembleExternalMessage() failed: %v", err)
+	}
+	subWallet := uint32(DefaultSubWallet)
+	otherSubWallet := subWallet + 1
+	tests := []struct {
+		name    string
+		opts    DecodeOptions
+		wantErr error
+	}{
+		{name: "no checks", opts: DecodeOptions{}},
+		{name: "all checks pass", opts: DecodeOptions{Strict: true, ExpectedSubWalletId: &subWallet, Now: 1_699_999_900, MaxHorizon: 600}},
+		{name: "subwallet mismatch", opts: DecodeOptions{ExpectedSubWalletId: &otherSubWallet}, wantErr: ErrSubWalletMismatch},
+		{name: "expired", opts: DecodeOptions{Now: 1_700_000_000}, wantErr: ErrMessageExpired},
+		{name: "too far", opts: DecodeOptions{Now: 1_699_999_000, MaxHorizon: 600}, wantErr: ErrValidUntilTooFar},
+		{name: "too large", opts: DecodeOptions{MaxTotalBytes: 1}, wantErr: ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg.ResetCounters()
+			decoded, err := DecodeWithOptions(V4R2, msg, tt.opts)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("want %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeWithOptions() failed: %v", err)
+			}
+			if decoded.Seqno != 7 || len(decoded.RawMessages) != 1 {
+				t.Fatalf("unexpected message: %+v", decoded)
+			}
+		})
+	}
+}
+
+func TestDetectVersionMismatch(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	// highload wallets have no ref to a dictionary without messages, so one message is sent.
+	msgs := []RawMessage{mustRawMessage(t, Message{Amount: 1_000, Mode: 3})}
+	hdr := MessageHeader{
+		SubWalletId: DefaultSubWallet,
+		WalletID:    [10]byte{0xff, 0xff, 0xff, 0x11},
+		ValidUntil:  1_700_000_000,
+		Seqno:       5,
+	}
+	tests := []struct {
+		name         string
+		signedFor    Version
+		declared     Version
+		wantVer      Version
+		wantMismatch bool
+	}{
+		{name: "same version", signedFor: V4R2, declared: V4R2, wantVer: V4R2},
+		{name: "same layout", signedFor: V4R2, declared: V4R1, wantVer: V4R1},
+		{name: "v4 sent to v5", signedFor: V4R2, declared: V5R1, wantVer: V4R2, wantMismatch: true},
+		{name: "v5 sent to v4", signedFor: V5R1, declared: V4R2, wantVer: V5R1, wantMismatch: true},
+		{name: "v3 sent to v4", signedFor: V3R2, declared: V4R1, wantVer: V3R2, wantMismatch: true},
+		{name: "highload v1", signedFor: HighLoadV1R2, declared: HighLoadV1R1, wantVer: HighLoadV1R1},
+		{name: "highload v1 sent to v4", signedFor: HighLoadV1R2, declared: V4R2, wantVer: HighLoadV2R2, wantMismatch: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := AssembleExternalMessage(tt.signedFor, privateKey, hdr, msgs)
+			if err != nil {
+				t.Fatalf("AssembleExternalMessage() failed: %v", err)
+			}
+			ver, mismatch, err := DetectVersionMismatch(tt.declared, msg)
+			if err != nil {
+				t.Fatalf("DetectVersionMismatch() failed: %v", err)
+			}
+			if ver != tt.wantVer || mismatch != tt.wantMismatch {
+				t.Fatalf("want (%v, %v), got (%v, %v)", tt.wantVer.ToString(), tt.wantMismatch, ver.ToString(), mismatch)
+			}
+		})
+	}
+}
+
+func TestDecodeBestEffort(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	hdr := MessageHeader{
+		SubWalletId:    DefaultSubWallet,
+		WalletID:       [10]byte{0xff, 0xff, 0xff, 0x11},
+		ValidUntil:     1_700_000_000,
+		Seqno:          5,
+		BoundedQueryID: 1_700_000_000 << 32,
+	}
+	recipient := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	internal, _, err := Message{Amount: 1_000, Address: recipient}.ToInternal()
+	if err != nil {
+		t.Fatalf("ToInternal() failed: %v", err)
+	}
+	cell := boc.NewCell()
+	if err := tlb.Marshal(cell, internal); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	msgs := []RawMessage{{Message: cell, Mode: 3}}
+	tests := []struct {
+		signedFor Version
+		want      Version
+	}{
+		{signedFor: V3R1, want: V3R2},
+		{signedFor: V4R2, want: V4R2},
+		{signedFor: V5R1, want: V5R1},
+		{signedFor: HighLoadV2R2, want: HighLoadV2R2},
+		{signedFor: HighLoadV1R2, want: HighLoadV2R2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.signedFor.ToString(), func(t *testing.T) {
+			msg, err := AssembleExternalMessage(tt.signedFor, privateKey, hdr, msgs)
+			if err != nil {
+				t.Fatalf("AssembleExternalMessage() failed: %v", err)
+			}
+			decoded, err := DecodeBestEffort(msg)
+			if err != nil {
+				t.Fatalf("DecodeBestEffort() failed: %v", err)
+			}
+			if decoded.Version != tt.want || len(decoded.RawMessages) != len(msgs) {
+				t.Fatalf("want %v with %v messages, got %v with %v", tt.want.ToString(), len(msgs), decoded.Version.ToString(), len(decoded.RawMessages))
+			}
+		})
+	}
+	extMsg, err := ton.CreateExternalMessage(recipient, boc.NewCell(), nil, 0)
+	if err != nil {
+		t.Fatalf("CreateExternalMessage() failed: %v", err)
+	}
+	empty := boc.NewCell()
+	if err := tlb.Marshal(empty, extMsg); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	var errs DecodeErrors
+	if _, err := DecodeBestEffort(empty); !errors.As(err, &errs) || len(errs) != len(bestEffortVersions) {
+		t.Fatalf("want an error for every version, got %v", err)
+	}
+}
+
+func TestWalletID(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	hdr := MessageHeader{
+		SubWalletId:    DefaultSubWallet,
+		WalletID:       [10]byte{0xff, 0xff, 0xff, 0x11, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07},
+		ValidUntil:     1_700_000_000,
+		Seqno:          5,
+		BoundedQueryID: 1_700_000_000 << 32,
+	}
+	tests := []struct {
+		ver  Version
+		want uint64
+	}{
+		{ver: V3R2, want: DefaultSubWallet},
+		{ver: V4R2, want: DefaultSubWallet},
+		{ver: HighLoadV2R2, want: DefaultSubWallet},
+		{ver: V5R1, want: 0xff11_00_00_00000007},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ver.ToString(), func(t *testing.T) {
+			msg, err := AssembleExternalMessage(tt.ver, privateKey, hdr, nil)
+			if err != nil {
+				t.Fatalf("AssembleExternalMessage() failed: %v", err)
+			}
+			got, err := WalletID(tt.ver, msg)
+			if err != nil {
+				t.Fatalf("WalletID() failed: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("want %#x, got %#x", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRawMessagesFromTransactionBOCs(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	recipient := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	msgs := []RawMessage{
+		mustRawMessage(t, Message{Amount: 1_000, Address: recipient, Mode: 3}),
+		mustRawMessage(t, Message{Amount: 2_000, Address: recipient, Mode: 1}),
+	}
+	hdr := MessageHeader{SubWalletId: DefaultSubWallet, ValidUntil: 1_700_000_000, Seqno: 7}
+	newTransaction := func(inMsg *boc.Cell) []byte {
+		// tlb.Transaction has unexported fields and can't be marshaled, so its layout is repeated here.
+		var tx struct {
+			Magic         tlb.Magic `tlb:"transaction$0111"`
+			AccountAddr   tlb.Bits256
+			Lt            uint64
+			PrevTransHash tlb.Bits256
+			PrevTransLt   uint64
+			Now           uint32
+			OutMsgCnt     tlb.Uint15
+			OrigStatus    tlb.AccountStatus
+			EndStatus     tlb.AccountStatus
+			Msgs          struct {
+				InMsg   tlb.Maybe[tlb.Ref[tlb.Message]]
+				OutMsgs tlb.HashmapE[tlb.Uint15, tlb.Ref[tlb.Message]]
+			} `tlb:"^"`
+			TotalFees   tlb.CurrencyCollection
+			StateUpdate tlb.HashUpdate       `tlb:"^"`
+			Description tlb.TransactionDescr `tlb:"^"`
+		}
+		tx.OrigStatus = tlb.AccountActive
+		tx.EndStatus = tlb.AccountActive
+		tx.Description.SumType = "TransStorage"
+		tx.Description.TransStorage.StoragePh.StatusChange = tlb.AccStatusChangeUnchanged
+		if inMsg != nil {
+			var m tlb.Message
+			if err := tlb.Unmarshal(inMsg, &m); err != nil {
+				t.Fatalf("Unmarshal() failed: %v", err)
+			}
+			tx.Msgs.InMsg = tlb.Maybe[tlb.Ref[tlb.Message]]{Exists: true, Value: tlb.Ref[tlb.Message]{Value: m}}
+		}
+		cell := boc.NewCell()
+		if err := tlb.Marshal(cell, tx); err != nil {
+			t.Fatalf("Marshal() failed: %v", err)
+		}
+		data, err := cell.ToBoc()
+		if err != nil {
+			t.Fatalf("ToBoc() failed: %v", err)
+		}
+		return data
+	}
+	v4, err := AssembleExternalMessage(V4R2, privateKey, hdr, msgs)
+	if err != nil {
+		t.Fatalf("AssembleExternalMessage() failed: %v", err)
+	}
+	v3, err := AssembleExternalMessage(V3R2, privateKey, hdr, msgs[:1])
+	if err != nil {
+		t.Fatalf("AssembleExternalMessage() failed: %v", err)
+	}
+	blobs := [][]byte{newTransaction(v4), newTransaction(nil), []byte("not a boc"), newTransaction(v3)}
+	indexed, err := RawMessagesFromTransactionBOCs(blobs)
+	var errs DecodeErrors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("want one decode error, got %v", err)
+	}
+	want := []struct {
+		index int
+		ver   Version
+		mode  byte
+	}{
+		{index: 0, ver: V4R2, mode: 3},
+		{index: 0, ver: V4R2, mode: 1},
+		{index: 3, ver: V3R2, mode: 3},
+	}
+	if len(indexed) != len(want) {
+		t.Fatalf("want %v messages, got %v", len(want), len(indexed))
+	}
+	for i, w := range want {
+		if got := indexed[i]; got.TransactionIndex != w.index || got.Version != w.ver || got.RawMessage.Mode != w.mode {
+			t.Fatalf("message %v: want %+v, got %+v", i, w, got)
+		}
+	}
+}
+
+func TestDecodedMessage_Rows(t *testing.T) {
+	recipient := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	broken := boc.NewCell()
+	if err := broken.WriteUint(0xff, 8); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	decoded := DecodedMessage{
+		Version:     V4R2,
+		SubWalletId: DefaultSubWallet,
+		ValidUntil:  1_700_000_000,
+		Seqno:       7,
+		RawMessages: []RawMessage{
+			mustRawMessage(t, SimpleTransfer{Amount: 1_500_000_000, Address: recipient, Comment: "hello", Bounceable: true}),
+			mustRawMessage(t, SimpleTransfer{Amount: 2_000_000_000, Address: recipient}),
+			{Message: broken, Mode: 3},
+		},
+	}
+	want := [][]string{
+		{"1", recipient.ToRaw(), "1.5", "3", "hello", "true"},
+		{"2", recipient.ToRaw(), "2", "3", "", "false"},
+		{"3", "", "", "", "", ""},
+	}
+	if rows := decoded.Rows(); !reflect.DeepEqual(rows, want) {
+		t.Fatalf("want rows %v, got %v", want, rows)
+	}
+	wantMetadata := [][]string{
+		{"version", "v4R2"},
+		{"subwallet", "698983191"},
+		{"seqno", "7"},
+		{"valid until", "2023-11-14T22:13:20Z"},
+	}
+	if metadata := decoded.MetadataRows(); !reflect.DeepEqual(metadata, wantMetadata) {
+		t.Fatalf("want metadata %v, got %v", wantMetadata, metadata)
+	}
+}
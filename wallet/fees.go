@@ -0,0 +1,100 @@
+package wallet
+
+import (
+	"fmt"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+)
+
+// PayloadCostBreakdown describes forward fees the network charges for sending a list of messages, see PayloadCost.
+type PayloadCostBreakdown struct {
+	Messages []MessageCost
+	// FwdFees is a sum of MessageCost.FwdFee of all messages.
+	FwdFees tlb.Grams
+	// ActionFees is a sum of MessageCost.ActionFee of all messages.
+	ActionFees tlb.Grams
+	// Total is a sum of FwdFees and ActionFees, it is how much the network charges for sending all messages.
+	Total tlb.Grams
+}
+
+// MessageCost describes forward fees of a single message.
+type MessageCost struct {
+	// Bits and Cells are the size of the message the fees are calculated for.
+	// The root cell of the message is not counted, and each distinct cell is counted once.
+	Bits  int
+	Cells int
+	// ActionFee is a part of the forward fee collected by validators when a wallet sends the message.
+	ActionFee tlb.Grams
+	// FwdFee is the remaining part of the forward fee, it is stored in the fwd_fee field of the sent message.
+	FwdFee tlb.Grams
+}
+
+// PayloadCost estimates forward fees of the given messages sent by a wallet.
+// prices are forward prices of the workchain of the wallet,
+// config param 24 for the masterchain and config param 25 for other workchains.
+//
+// The fees are calculated as
+//
+//	lump_price + ceil((bit_price * bits + cell_price * cells) / 2^16)
+//
+// and split into the action fee and the forward fee using first_frac.
+// Gas a wallet spends to process an external message is not included,
+// because it depends on the wallet code and not on the payload.
+func PayloadCost(msgs []RawMessage, prices tlb.MsgForwardPrices) (PayloadCostBreakdown, error) {
+	breakdown := PayloadCostBreakdown{
+		Messages: make([]MessageCost, 0, len(msgs)),
+	}
+	for i, msg := range msgs {
+		if msg.Message == nil {
+			return PayloadCostBreakdown{}, fmt.Errorf("message %v has no cell", i)
+		}
+		bits, cells, err := messageSize(msg.Message)
+		if err != nil {
+			return PayloadCostBreakdown{}, fmt.Errorf("message %v: %w", i, err)
+		}
+		total := prices.LumpPrice + (prices.BitPrice*uint64(bits)+prices.CellPrice*uint64(cells)+0xffff)>>16
+		actionFee := total * uint64(prices.FirstFrac) >> 16
+		cost := MessageCost{
+			Bits:      bits,
+			Cells:     cells,
+			ActionFee: tlb.Grams(actionFee),
+			FwdFee:    tlb.Grams(total - actionFee),
+		}
+		breakdown.Messages = append(breakdown.Messages, cost)
+		breakdown.FwdFees += cost.FwdFee
+		breakdown.ActionFees += cost.ActionFee
+	}
+	breakdown.Total = breakdown.FwdFees + breakdown.ActionFees
+	return breakdown, nil
+}
+
+// messageSize returns a number of bits and distinct cells of the given message without its root cell.
+func messageSize(msg *boc.Cell) (bits int, cells int, err error) {
+	seen := make(map[string]struct{})
+	var walk func(c *boc.Cell) error
+	walk = func(c *boc.Cell) error {
+		hash, err := c.HashString()
+		if err != nil {
+			return err
+		}
+		if _, ok := seen[hash]; ok {
+			return nil
+		}
+		seen[hash] = struct{}{}
+		bits += c.BitSize()
+		cells += 1
+		for _, ref := range c.Refs() {
+			if err := walk(ref); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, ref := range msg.Refs() {
+		if err := walk(ref); err != nil {
+			return 0, 0, err
+		}
+	}
+	return bits, cells, nil
+}
@@ -0,0 +1,50 @@
+package wallet
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+)
+
+func TestPayloadCost(t *testing.T) {
+	// forward prices of the basechain, config param 25
+	prices := tlb.MsgForwardPrices{
+		LumpPrice:      400_000,
+		BitPrice:       26_214_400,
+		CellPrice:      2_621_440_000,
+		IhrPriceFactor: 98_304,
+		FirstFrac:      21_845,
+		NextFrac:       21_845,
+	}
+	recipient := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	body := boc.NewCell()
+	if err := body.WriteUint(0x0f8a7ea5, 32); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	msgs := []RawMessage{
+		mustRawMessage(t, Message{Amount: 1_000, Address: recipient, Body: body, Mode: 3}),
+		mustRawMessage(t, Message{Amount: 1_000, Address: recipient, Mode: 3}),
+	}
+	got, err := PayloadCost(msgs, prices)
+	if err != nil {
+		t.Fatalf("PayloadCost() failed: %v", err)
+	}
+	want := PayloadCostBreakdown{
+		Messages: []MessageCost{
+			{Bits: 32, Cells: 1, ActionFee: 150_931, FwdFee: 301_869},
+			{Bits: 0, Cells: 0, ActionFee: 133_331, FwdFee: 266_669},
+		},
+		FwdFees:    568_538,
+		ActionFees: 284_262,
+		Total:      852_800,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %+v, got %+v", want, got)
+	}
+	if _, err := PayloadCost([]RawMessage{{Mode: 3}}, prices); err == nil {
+		t.Fatalf("PayloadCost() had to fail but it didn't")
+	}
+}
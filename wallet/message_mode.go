@@ -13,6 +13,12 @@ const (
 	AttachAllRemainingBalanceOfInboundMessage MessageMode = 64
 	// DestroyAccount means that current account must be destroyed if its resulting balance is zero (often used with Mode 128).
 	DestroyAccount MessageMode = 32
+	// BounceOnActionFail means that the transaction must be bounced if the message fails to be sent during the action phase.
+	BounceOnActionFail MessageMode = 16
+	// IgnoreErrors means that errors during the action phase must be ignored for this message.
+	IgnoreErrors MessageMode = 2
+	// PayFeesSeparately means that a wallet will pay transfer fees separately from the message value.
+	PayFeesSeparately MessageMode = 1
 )
 
 func IsMessageModeSet(modeValue int, mode MessageMode) bool {
@@ -2,14 +2,43 @@ package wallet
 
 import (
 	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"time"
 
 	"github.com/tonkeeper/tongo/boc"
 	"github.com/tonkeeper/tongo/tlb"
 )
 
-var ErrBadSignature = errors.New("failed to verify msg signature")
+var (
+	ErrBadSignature = errors.New("failed to verify msg signature")
+	// ErrTooLarge is returned when decoded messages exceed a limit set by WithMaxTotalBytes.
+	ErrTooLarge = errors.New("decoded messages are too large")
+	// ErrMessageExpired is returned by ValidateValidUntil when a wallet would reject a message as expired.
+	ErrMessageExpired = errors.New("message is expired")
+	// ErrValidUntilTooFar is returned by ValidateValidUntil when a message is valid for too long.
+	ErrValidUntilTooFar = errors.New("message valid-until is too far in the future")
+	// ErrEmptyBody is returned when an external message sent to a wallet has an empty body.
+	// Every wallet starts processing an external message by reading a signature or an opcode from its body,
+	// so such a message is rejected by the wallet and never changes its seqno.
+	// A message that only bumps the seqno is a signed message with no messages in its payload.
+	ErrEmptyBody = errors.New("message has an empty body")
+	// ErrSubWalletMismatch is returned when a message is sent to a wallet with another subwallet id.
+	ErrSubWalletMismatch = errors.New("unexpected subwallet id")
+	// ErrTrailingData is returned by strict decoding functions
+	// when bits or refs are left in a message body after its known fields are decoded.
+	ErrTrailingData = errors.New("message body has trailing data")
+	// ErrSignatureAuthDisabled is returned by VerifyV5WithAuthMode when a signed request is sent to a wallet v5
+	// with signature authentication disabled, such a wallet accepts requests of its extensions only.
+	ErrSignatureAuthDisabled = errors.New("signature authentication is disabled")
+	// ErrExtensionRequest is returned by VerifyV5WithAuthMode for a request of an extension of a wallet v5.
+	// Such a request has no signature, the wallet authorizes it by the address of the extension.
+	ErrExtensionRequest = errors.New("extension request has no signature")
+)
 
 type MessageV3 struct {
 	SubWalletId uint32
@@ -25,8 +54,131 @@ type MessageV4 struct {
 	Seqno       uint32
 	Op          int8
 	RawMessages PayloadV1toV4
+	// PluginDeployment is set instead of RawMessages if Op is 1.
+	PluginDeployment *PluginDeployment
+}
+
+// opDeployAndInstallPlugin is a value of MessageV4.Op that deploys a plugin and installs it to the wallet.
+const opDeployAndInstallPlugin = 1
+
+// PluginDeployment is a content of a wallet v4 message deploying and installing a plugin,
+// it follows the op in the body:
+//
+//	op:uint8 workchain:int8 balance:Grams state_init:^StateInit body:^Cell
+type PluginDeployment struct {
+	Workchain int8
+	Balance   tlb.Grams
+	StateInit *boc.Cell `tlb:"^"`
+	Body      *boc.Cell `tlb:"^"`
+}
+
+// UnmarshalTLB decodes the header of a wallet v4 message followed either by messages to send
+// or by a plugin deployment if Op is 1.
+func (m *MessageV4) UnmarshalTLB(c *boc.Cell, decoder *tlb.Decoder) error {
+	var header messageV4Header
+	if err := decoder.Unmarshal(c, &header); err != nil {
+		return err
+	}
+	msg := MessageV4{
+		SubWalletId: header.SubWalletId,
+		ValidUntil:  header.ValidUntil,
+		Seqno:       header.Seqno,
+		Op:          header.Op,
+	}
+	if header.Op == opDeployAndInstallPlugin {
+		var deployment PluginDeployment
+		if err := decoder.Unmarshal(c, &deployment); err != nil {
+			return fmt.Errorf("failed to decode plugin deployment: %w", err)
+		}
+		msg.PluginDeployment = &deployment
+	} else if err := decoder.Unmarshal(c, &msg.RawMessages); err != nil {
+		return err
+	}
+	*m = msg
+	return nil
+}
+
+// MarshalTLB encodes the header of a wallet v4 message followed by PluginDeployment if it is set
+// and by RawMessages otherwise.
+func (m MessageV4) MarshalTLB(c *boc.Cell, encoder *tlb.Encoder) error {
+	header := messageV4Header{
+		SubWalletId: m.SubWalletId,
+		ValidUntil:  m.ValidUntil,
+		Seqno:       m.Seqno,
+		Op:          m.Op,
+	}
+	if err := encoder.Marshal(c, header); err != nil {
+		return err
+	}
+	if m.PluginDeployment != nil {
+		return encoder.Marshal(c, *m.PluginDeployment)
+	}
+	return encoder.Marshal(c, m.RawMessages)
+}
+
+// messageV4Header is a part of a wallet v4 message before the payload.
+type messageV4Header struct {
+	SubWalletId uint32
+	ValidUntil  uint32
+	Seqno       uint32
+	Op          int8
+}
+
+// PluginStateInit decodes a state init of a plugin deployed by a message with Op equal to 1.
+func (m *MessageV4) PluginStateInit() (*tlb.StateInit, error) {
+	if m.Op != opDeployAndInstallPlugin {
+		return nil, fmt.Errorf("message doesn't deploy a plugin, op: %v", m.Op)
+	}
+	if m.PluginDeployment == nil || m.PluginDeployment.StateInit == nil {
+		return nil, fmt.Errorf("plugin deploy message must have a state init and a body")
+	}
+	cell := m.PluginDeployment.StateInit
+	cell.ResetCounters()
+	var stateInit tlb.StateInit
+	if err := tlb.Unmarshal(cell, &stateInit); err != nil {
+		return nil, fmt.Errorf("failed to decode plugin state init: %w", err)
+	}
+	return &stateInit, nil
+}
+
+// noExpiry is a value of ValidUntil which some tools use for messages that never expire.
+const noExpiry = math.MaxUint32
+
+// HasExpiry reports whether the message expires, ValidUntil equal to 0xFFFFFFFF means that it never expires.
+func (m *MessageV4) HasExpiry() bool {
+	return m.ValidUntil != noExpiry
 }
 
+// Expiry returns a time after which a wallet rejects the message.
+// The returned bool is false if the message never expires, see HasExpiry.
+func (m *MessageV4) Expiry() (time.Time, bool) {
+	if !m.HasExpiry() {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(m.ValidUntil), 0), true
+}
+
+// ValidateValidUntil checks that a message with the given valid-until is accepted by a wallet at now
+// and expires no later than maxHorizon seconds after now.
+// All values are in seconds, valid-until and now are unix times.
+func ValidateValidUntil(validUntil, now, maxHorizon uint32) error {
+	if validUntil <= now {
+		return ErrMessageExpired
+	}
+	if uint64(validUntil) > uint64(now)+uint64(maxHorizon) {
+		return ErrValidUntilTooFar
+	}
+	return nil
+}
+
+const (
+	// sendMsgActionOpcode is a tag of action_send_msg#0ec3c86d.
+	sendMsgActionOpcode = 0x0ec3c86d
+	// sendMsgActionBits is a number of bits stored in a send_msg action node:
+	// a 32-bit opcode followed by an 8-bit mode.
+	sendMsgActionBits = 40
+)
+
 type SendMessageAction struct {
 	Magic tlb.Magic `tlb:"#0ec3c86d"`
 	Mode  uint8
@@ -35,9 +187,29 @@ type SendMessageAction struct {
 
 type SendMessageList struct {
 	Actions []SendMessageAction
+	// Extended holds extended actions of the action list of a wallet v5 request with Op set.
+	// They are stored inline in the nodes of the list in front of its out list and executed in order before the send_msg actions.
+	Extended []V5ExtendedAction
 }
 
-// MessageV5 is a message format used by wallet v5.
+// MessageV5 is a message format used by wallet v5:
+//
+//	signed_request$_ wallet_id:uint80 valid_until:uint32 msg_seqno:uint32 inner:InnerRequest signature:bits512 = SignedRequest;
+//	actions$_ {m:#} {n:#} actions:(ActionList n m) = InnerRequest;
+//	action_list_basic$0 {n:#} actions:^(OutList n) = ActionList n 0;
+//	action_list_extended$1 {m:#} {n:#} prev:^(ActionList n m) action:ExtendedAction = ActionList n (m+1);
+//
+// Signature is stored in the last 512 bits of the body of a signed request.
+//
+// Op is the tag of the action list, the bit that follows the seqno in signed requests
+// and the query id in requests of extensions. It has the same meaning in all three variants:
+// false means that the only ref of the body holds a c5 out list of send_msg actions and the signature follows the bit,
+// true means that an extended action is stored inline after the bit and the ref holds the rest of the action list,
+// so the signature follows the action. Extended actions are decoded into Actions.Extended.
+//
+// Unlike the final wallet v5 layout with actions:(Maybe ^OutList), the bit after the seqno is not a maybe bit,
+// but the ref to the out list is optional: a request without it has no send actions.
+// Requests built by this package always refer to the out list, which is an empty cell if there are no send actions.
 type MessageV5 struct {
 	tlb.SumType
 	// Sint is an internal message authenticated by a signature.
@@ -46,8 +218,8 @@ type MessageV5 struct {
 		ValidUntil  uint32
 		Seqno       uint32
 		Op          bool
+		Actions     SendMessageList
 		Signature   tlb.Bits512
-		Actions     SendMessageList `tlb:"^"`
 	} `tlbSumType:"#73696e74"`
 	// Sign is an external message authenticated by a signature.
 	Sign struct {
@@ -55,9 +227,123 @@ type MessageV5 struct {
 		ValidUntil  uint32
 		Seqno       uint32
 		Op          bool
+		Actions     SendMessageList
 		Signature   tlb.Bits512
-		Actions     SendMessageList `tlb:"^"`
 	} `tlbSumType:"#7369676e"`
+	// Extn is an internal message sent by an installed extension.
+	// It has no signature because the wallet authorizes it by the address of the extension.
+	Extn struct {
+		QueryID uint64
+		Op      bool
+		Actions SendMessageList
+	} `tlbSumType:"#6578746e"`
+}
+
+// signedRequestV5 is a content of both MessageV5.Sint and MessageV5.Sign.
+type signedRequestV5 = struct {
+	SubWalletId tlb.Bits80
+	ValidUntil  uint32
+	Seqno       uint32
+	Op          bool
+	Actions     SendMessageList
+	Signature   tlb.Bits512
+}
+
+// UnmarshalTLB decodes a request of a wallet v5:
+//
+//	signed_request$_ wallet_id:uint80 valid_until:uint32 msg_seqno:uint32 inner:InnerRequest signature:bits512 = SignedRequest;
+//	actions$_ {m:#} {n:#} actions:(ActionList n m) = InnerRequest;
+//	internal_signed#73696e74 signed:SignedRequest = InternalMsgBody;
+//	internal_extension#6578746e query_id:(## 64) inner:InnerRequest = InternalMsgBody;
+//	external_signed#7369676e signed:SignedRequest = ExternalMsgBody;
+//
+// The action list is decoded by decodeV5ActionList.
+func (m *MessageV5) UnmarshalTLB(c *boc.Cell, decoder *tlb.Decoder) error {
+	prefix, err := c.ReadUint(32)
+	if err != nil {
+		return err
+	}
+	switch prefix {
+	case 0x7369676e, 0x73696e74:
+		var request signedRequestV5
+		if err := decoder.Unmarshal(c, &request.SubWalletId); err != nil {
+			return err
+		}
+		validUntil, err := c.ReadUint(32)
+		if err != nil {
+			return err
+		}
+		seqno, err := c.ReadUint(32)
+		if err != nil {
+			return err
+		}
+		request.ValidUntil, request.Seqno = uint32(validUntil), uint32(seqno)
+		if request.Op, request.Actions, err = decodeV5ActionList(c, decoder); err != nil {
+			return err
+		}
+		if err := decoder.Unmarshal(c, &request.Signature); err != nil {
+			return err
+		}
+		if prefix == 0x7369676e {
+			m.SumType, m.Sign = "Sign", request
+		} else {
+			m.SumType, m.Sint = "Sint", request
+		}
+		return nil
+	case 0x6578746e:
+		queryID, err := c.ReadUint(64)
+		if err != nil {
+			return err
+		}
+		m.SumType = "Extn"
+		m.Extn.QueryID = queryID
+		m.Extn.Op, m.Extn.Actions, err = decodeV5ActionList(c, decoder)
+		return err
+	default:
+		return fmt.Errorf("unknown wallet v5 request prefix %#x", prefix)
+	}
+}
+
+func (m MessageV5) MarshalTLB(c *boc.Cell, encoder *tlb.Encoder) error {
+	switch m.SumType {
+	case "Sign":
+		if err := encodeSignedRequestV5(c, 0x7369676e, m.Sign, encoder); err != nil {
+			return err
+		}
+		return encoder.Marshal(c, m.Sign.Signature)
+	case "Sint":
+		if err := encodeSignedRequestV5(c, 0x73696e74, m.Sint, encoder); err != nil {
+			return err
+		}
+		return encoder.Marshal(c, m.Sint.Signature)
+	case "Extn":
+		if err := c.WriteUint(0x6578746e, 32); err != nil {
+			return err
+		}
+		if err := c.WriteUint(m.Extn.QueryID, 64); err != nil {
+			return err
+		}
+		return encodeV5ActionList(c, m.Extn.Op, m.Extn.Actions, encoder)
+	default:
+		return fmt.Errorf("unknown v5 message type: %v", m.SumType)
+	}
+}
+
+// encodeSignedRequestV5 encodes the given signed request of a wallet v5 without its signature.
+func encodeSignedRequestV5(c *boc.Cell, prefix uint64, request signedRequestV5, encoder *tlb.Encoder) error {
+	if err := c.WriteUint(prefix, 32); err != nil {
+		return err
+	}
+	if err := encoder.Marshal(c, request.SubWalletId); err != nil {
+		return err
+	}
+	if err := c.WriteUint(uint64(request.ValidUntil), 32); err != nil {
+		return err
+	}
+	if err := c.WriteUint(uint64(request.Seqno), 32); err != nil {
+		return err
+	}
+	return encodeV5ActionList(c, request.Op, request.Actions, encoder)
 }
 
 type HighloadV2Message struct {
@@ -66,6 +352,44 @@ type HighloadV2Message struct {
 	RawMessages    PayloadHighload
 }
 
+// HighloadV1Message is a message format used by highload wallet v1.
+// Unlike highload wallet v2, it protects against replays with a seqno the same way as wallet v3 does,
+// and its messages are stored in the same dictionary as messages of highload wallet v2.
+type HighloadV1Message struct {
+	SubWalletId uint32
+	ValidUntil  uint32
+	Seqno       uint32
+	RawMessages PayloadHighload
+}
+
+// PackHighloadQueryID builds a bounded query id of a highload wallet v2:
+// a unix time after which the query expires is stored in the upper 32 bits, and an id is stored in the lower 32 bits.
+func PackHighloadQueryID(timeout uint32, id uint32) uint64 {
+	return uint64(timeout)<<32 | uint64(id)
+}
+
+// UnpackHighloadQueryID splits a bounded query id of a highload wallet v2 into its parts, see PackHighloadQueryID.
+func UnpackHighloadQueryID(q uint64) (timeout uint32, id uint32) {
+	return uint32(q >> 32), uint32(q)
+}
+
+// ExpiresWithin reports whether the wallet starts rejecting this message within d after now, a unix time.
+// A highload wallet v2 accepts a message until now passes the timeout stored in the bounded query id,
+// so already expired messages are reported as well.
+func (m *HighloadV2Message) ExpiresWithin(d time.Duration, now uint32) bool {
+	timeout, _ := UnpackHighloadQueryID(m.BoundedQueryID)
+	return int64(timeout) < int64(now)+int64(d/time.Second)
+}
+
+// HighloadV2MessagePreserved is the same as HighloadV2Message,
+// but it is encoded back to exactly the same cell it was decoded from as long as its messages are not modified.
+// See PreservedPayloadHighload for details.
+type HighloadV2MessagePreserved struct {
+	SubWalletId    uint32
+	BoundedQueryID uint64
+	RawMessages    PreservedPayloadHighload
+}
+
 // SignedMsgBody represents an external message's body sent by an offchain application to a wallet contract.
 // The signature is created using the wallet's private key.
 // So the wallet will verify that it is the recipient of the payload and accept the payload.
@@ -81,121 +405,574 @@ type RawMessage struct {
 }
 
 type PayloadV1toV4 []RawMessage
+
+// PayloadHighload is a list of messages of a highload wallet v2 stored in a HashmapE 16.
+// The highload v2 contract iterates the dictionary with idict_get_next?(16, i),
+// so keys are signed 16-bit integers, and messages are stored under keys 0, 1, 2 and so on,
+// which are encoded the same way as unsigned tlb.Uint16 keys.
+// Edge labels shorter than 8 bits are encoded as hml_short and longer ones as hml_long.
+// The contract accepts any valid label encoding, but a dictionary built by another encoder,
+// for example, with hml_same labels, has a different hash, see PreservedPayloadHighload.
+// Each value is a message mode in 8 bits and a ref to the message.
 type PayloadHighload []RawMessage
 
+// PreservedPayloadHighload is a PayloadHighload that keeps the dictionary cell it was decoded from.
+//
+// PayloadHighload.MarshalTLB builds a new dictionary, and its cells may differ from the original ones:
+// another encoder could choose different label encodings or put extra data into value cells.
+// PreservedPayloadHighload.MarshalTLB writes the original dictionary instead,
+// so the re-encoded message has the same hash as the decoded one.
+//
+// The original dictionary is reused only if Messages have the same length, modes and message cells (compared by pointer)
+// as right after decoding. Otherwise, the dictionary is rebuilt the same way PayloadHighload does it
+// and the original layout is lost. Changes made to the message cells in place are not detected.
+type PreservedPayloadHighload struct {
+	Messages PayloadHighload
+
+	decoded []RawMessage
+	dict    *boc.Cell
+}
+
 func (body *SignedMsgBody) Verify(publicKey ed25519.PublicKey) error {
 	msg := boc.Cell(body.Message)
-	hash, err := msg.Hash()
+	return VerifyDetached(&msg, body.Sign, publicKey)
+}
+
+// VerifyDetached checks whether the hash of the given body cell was signed by the given public key.
+// Unlike SignedMsgBody.Verify, the signature is passed separately from the body.
+// The body can contain pruned branches, for example, when it is taken from a merkle proof,
+// then the hash of the original body is verified.
+func VerifyDetached(body *boc.Cell, sig tlb.Bits512, key ed25519.PublicKey) error {
+	hash, err := body.HashAtLevel(0)
 	if err != nil {
 		return err
 	}
-	if ed25519.Verify(publicKey, hash, body.Sign[:]) {
+	if ed25519.Verify(key, hash, sig[:]) {
 		return nil
 	}
 	return ErrBadSignature
 }
 
-func extractSignedMsgBody(msg *boc.Cell) (*SignedMsgBody, error) {
+// verifyDomainSeparated checks whether the given prefix followed by the hash of the given body cell
+// was signed by the given public key.
+func verifyDomainSeparated(body *boc.Cell, sig tlb.Bits512, key ed25519.PublicKey, prefix []byte) error {
+	hash, err := body.HashAtLevel(0)
+	if err != nil {
+		return err
+	}
+	preimage := make([]byte, 0, len(prefix)+len(hash))
+	preimage = append(append(preimage, prefix...), hash...)
+	if ed25519.Verify(key, preimage, sig[:]) {
+		return nil
+	}
+	return ErrBadSignature
+}
+
+func extractSignedMsgBody(msg *boc.Cell, opts ...DecodeOption) (*SignedMsgBody, error) {
 	var m tlb.Message
 	if err := tlb.Unmarshal(msg, &m); err != nil {
 		return nil, err
 	}
+	return signedMsgBodyFromTLB(&m, opts...)
+}
+
+// ImportFee returns the import fee declared in the ext_in_msg_info header of the given external message.
+// Wallets don't use it, so it is usually zero, but emulators and fee estimators may set it.
+// Like other decoding functions, it decodes the message from its root regardless of the read cursors of msg.
+func ImportFee(msg *boc.Cell) (tlb.Grams, error) {
+	var m tlb.Message
+	if err := tlb.Unmarshal(msg, &m); err != nil {
+		return 0, err
+	}
+	if m.Info.SumType != "ExtInMsgInfo" {
+		return 0, fmt.Errorf("want an external inbound message, got %v", m.Info.SumType)
+	}
+	return m.Info.ExtInMsgInfo.ImportFee, nil
+}
+
+func signedMsgBodyFromTLB(m *tlb.Message, opts ...DecodeOption) (*SignedMsgBody, error) {
+	options := DecodeOptions{}
+	for _, o := range opts {
+		o(&options)
+	}
 	msgBody := SignedMsgBody{}
-	bodyCell := boc.Cell(m.Body.Value)
-	if err := tlb.Unmarshal(&bodyCell, &msgBody); err != nil {
+	bodyCell, err := messageBody(m, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := tlb.Unmarshal(bodyCell, &msgBody); err != nil {
 		return nil, err
 	}
 	return &msgBody, nil
 }
 
-func DecodeMessageV5(msg *boc.Cell) (*MessageV5, error) {
+// messageBody returns the body of the given external message a wallet decodes,
+// after it is processed by options.BodyPreprocessor if there is one.
+func messageBody(m *tlb.Message, options DecodeOptions) (*boc.Cell, error) {
+	bodyCell := boc.Cell(m.Body.Value)
+	body := &bodyCell
+	if options.BodyPreprocessor != nil {
+		var err error
+		if body, err = options.BodyPreprocessor(body); err != nil {
+			return nil, fmt.Errorf("failed to preprocess body: %w", err)
+		}
+	}
+	if isEmptyCell(body) {
+		return nil, ErrEmptyBody
+	}
+	return body, nil
+}
+
+func DecodeMessageV5(msg *boc.Cell, opts ...DecodeOption) (_ *MessageV5, err error) {
+	defer observeDecode(V5R1, time.Now(), &err)
+	var m tlb.Message
+	if err := tlb.Unmarshal(msg, &m); err != nil {
+		return nil, err
+	}
+	return messageV5FromTLB(&m, opts...)
+}
+
+// DecodeMessageV5Strict works like DecodeMessageV5
+// but fails with ErrTrailingData if the message body has bits or refs left after the request is decoded.
+func DecodeMessageV5Strict(msg *boc.Cell) (_ *MessageV5, err error) {
+	defer observeDecode(V5R1, time.Now(), &err)
 	var m tlb.Message
 	if err := tlb.Unmarshal(msg, &m); err != nil {
 		return nil, err
 	}
+	return messageV5FromTLB(&m, strictDecoding)
+}
+
+func messageV5FromTLB(m *tlb.Message, opts ...DecodeOption) (*MessageV5, error) {
+	msgv5, _, err := decodeMessageV5Body(m, opts...)
+	return msgv5, err
+}
+
+// decodeMessageV5Body decodes a request of a wallet v5 from the body of the given message
+// and returns the body with its read cursors right after the request.
+func decodeMessageV5Body(m *tlb.Message, opts ...DecodeOption) (*MessageV5, *boc.Cell, error) {
+	options := DecodeOptions{}
+	for _, o := range opts {
+		o(&options)
+	}
 	var msgv5 MessageV5
-	bodyCell := boc.Cell(m.Body.Value)
-	if err := tlb.Unmarshal(&bodyCell, &msgv5); err != nil {
+	bodyCell, err := messageBody(m, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := tlb.Unmarshal(bodyCell, &msgv5); err != nil {
+		return nil, nil, err
+	}
+	if err := checkTrailingData(bodyCell, options); err != nil {
+		return nil, nil, err
+	}
+	return &msgv5, bodyCell, nil
+}
+
+// peekWalletV5ID reads a wallet id of a signed request of a wallet v5 from the given body
+// without decoding the rest of the request, so it works for requests that fail to decode.
+// The read cursor of the body is not changed.
+func peekWalletV5ID(body *boc.Cell) (WalletV5ID, bool) {
+	c := *body
+	prefix, err := c.ReadUint(32)
+	if err != nil || (prefix != 0x7369676e && prefix != 0x73696e74) {
+		return WalletV5ID{}, false
+	}
+	var id tlb.Bits80
+	if err := tlb.Unmarshal(&c, &id); err != nil {
+		return WalletV5ID{}, false
+	}
+	return decodeWalletV5ID(id), true
+}
+
+// withWalletV5ID adds the wallet id of a signed request of a wallet v5 carried by the given message
+// to the given decoding error, if the id can be read.
+func withWalletV5ID(m *tlb.Message, err error) error {
+	body := boc.Cell(m.Body.Value)
+	if id, ok := peekWalletV5ID(&body); ok {
+		return fmt.Errorf("%w (wallet id: network %v, workchain %v, version %v, subwallet %v)",
+			err, int32(id.NetworkGlobalID), int8(id.Workchain), id.WalletVersion, id.SubWalletID)
+	}
+	return err
+}
+
+// isEmptyCell reports whether the unread part of the given cell has no bits and no refs.
+func isEmptyCell(c *boc.Cell) bool {
+	return c.BitsAvailableForRead() == 0 && c.RefsAvailableForRead() == 0
+}
+
+// ParseW5SignedRequest decodes a signed request of a wallet v5 from a base64-encoded BOC, as wallet frontends send it to backends.
+// The BOC can contain either a whole message or only its body starting with the sign or sint prefix.
+// Both standard and URL-safe base64 encodings are accepted.
+// The signature is not verified, see MessageV5VerifySignature.
+func ParseW5SignedRequest(b64 string) (*MessageV5, error) {
+	data, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		if data, err = base64.URLEncoding.DecodeString(b64); err != nil {
+			return nil, err
+		}
+	}
+	roots, err := boc.DeserializeBoc(data)
+	if err != nil {
 		return nil, err
 	}
-	return &msgv5, nil
+	if len(roots) != 1 {
+		return nil, fmt.Errorf("invalid boc roots number %v", len(roots))
+	}
+	cell := roots[0]
+	prefix, err := cell.PickUint(32)
+	if err == nil && (prefix == 0x7369676e || prefix == 0x73696e74) {
+		var msgv5 MessageV5
+		if err := tlb.Unmarshal(cell, &msgv5); err == nil {
+			return &msgv5, nil
+		}
+		cell.ResetCounters()
+	}
+	return DecodeMessageV5(cell)
 }
 
-func DecodeMessageV4(msg *boc.Cell) (*MessageV4, error) {
-	signedMsgBody, err := extractSignedMsgBody(msg)
+// DecodeMessageV4 decodes an external message sent to a wallet v4.
+// V4R1 and V4R2 differ in get methods and plugin handling only,
+// external messages of both revisions have the same layout and are decoded the same way.
+// A wallet derived from v4 with a different payload layout can be decoded with WithPayloadDecoder.
+func DecodeMessageV4(msg *boc.Cell, opts ...DecodeOption) (_ *MessageV4, err error) {
+	defer observeDecode(V4R2, time.Now(), &err)
+	signedMsgBody, err := extractSignedMsgBody(msg, opts...)
 	if err != nil {
 		return nil, err
 	}
-	return decodeMessageV4(signedMsgBody)
+	return decodeMessageV4(signedMsgBody, opts...)
+}
+
+// DecodeMessageV4Strict works like DecodeMessageV4
+// but fails with ErrTrailingData if the message body has bits or refs left after the messages are decoded.
+func DecodeMessageV4Strict(msg *boc.Cell, opts ...DecodeOption) (*MessageV4, error) {
+	return DecodeMessageV4(msg, append(opts[:len(opts):len(opts)], strictDecoding)...)
 }
 
-func decodeMessageV4(body *SignedMsgBody) (*MessageV4, error) {
+func decodeMessageV4(body *SignedMsgBody, opts ...DecodeOption) (*MessageV4, error) {
+	options := DecodeOptions{}
+	for _, o := range opts {
+		o(&options)
+	}
 	msgv4 := MessageV4{}
 	cell := boc.Cell(body.Message)
-	if err := tlb.Unmarshal(&cell, &msgv4); err != nil {
+	if options.PayloadDecoder == nil {
+		if err := tlb.Unmarshal(&cell, &msgv4); err != nil {
+			return nil, err
+		}
+	} else {
+		var header messageV4Header
+		if err := tlb.Unmarshal(&cell, &header); err != nil {
+			return nil, err
+		}
+		msgv4 = MessageV4{
+			SubWalletId: header.SubWalletId,
+			ValidUntil:  header.ValidUntil,
+			Seqno:       header.Seqno,
+			Op:          header.Op,
+		}
+		if header.Op == opDeployAndInstallPlugin {
+			var deployment PluginDeployment
+			if err := tlb.Unmarshal(&cell, &deployment); err != nil {
+				return nil, fmt.Errorf("failed to decode plugin deployment: %w", err)
+			}
+			msgv4.PluginDeployment = &deployment
+		} else {
+			msgs, err := options.PayloadDecoder.DecodePayload(&cell)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode payload: %w", err)
+			}
+			msgv4.RawMessages = msgs
+		}
+	}
+	if err := checkTrailingData(&cell, options); err != nil {
+		return nil, err
+	}
+	if err := checkMaxTotalBytes(msgv4.RawMessages, options.MaxTotalBytes); err != nil {
 		return nil, err
 	}
 	return &msgv4, nil
 }
 
-func DecodeMessageV3(msg *boc.Cell) (*MessageV3, error) {
+func DecodeMessageV3(msg *boc.Cell, opts ...DecodeOption) (_ *MessageV3, err error) {
+	defer observeDecode(V3R2, time.Now(), &err)
+	signedMsgBody, err := extractSignedMsgBody(msg, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMessageV3(signedMsgBody, opts...)
+}
+
+// DecodeMessageV3Strict works like DecodeMessageV3
+// but fails with ErrTrailingData if the message body has bits or refs left after the messages are decoded.
+func DecodeMessageV3Strict(msg *boc.Cell) (_ *MessageV3, err error) {
+	defer observeDecode(V3R2, time.Now(), &err)
 	signedMsgBody, err := extractSignedMsgBody(msg)
 	if err != nil {
 		return nil, err
 	}
-	return decodeMessageV3(signedMsgBody)
+	return decodeMessageV3(signedMsgBody, strictDecoding)
 }
 
-func decodeMessageV3(body *SignedMsgBody) (*MessageV3, error) {
+func decodeMessageV3(body *SignedMsgBody, opts ...DecodeOption) (*MessageV3, error) {
+	options := DecodeOptions{}
+	for _, o := range opts {
+		o(&options)
+	}
 	msgv3 := MessageV3{}
 	payloadCell := boc.Cell(body.Message)
 	if err := tlb.Unmarshal(&payloadCell, &msgv3); err != nil {
 		return nil, err
 	}
+	if err := checkTrailingData(&payloadCell, options); err != nil {
+		return nil, err
+	}
 	return &msgv3, nil
 }
 
-func DecodeHighloadV2Message(msg *boc.Cell) (*HighloadV2Message, error) {
-	signedMsgBody, err := extractSignedMsgBody(msg)
+// DecodeOptions configures decoding of wallet messages.
+type DecodeOptions struct {
+	// MaxTotalBytes limits a total size of data of all decoded message cells, zero means no limit.
+	MaxTotalBytes int
+	// PayloadDecoder decodes messages of a wallet v4 message instead of PayloadV1toV4.
+	PayloadDecoder PayloadDecoder
+	// BodyPreprocessor processes a body of an external message before it is decoded.
+	BodyPreprocessor BodyPreprocessor
+	// Strict makes decoding fail with ErrTrailingData if a message body has bits or refs left after it is decoded.
+	Strict bool
+	// ExpectedSubWalletId makes DecodeMessage fail with ErrSubWalletMismatch
+	// if a message is sent to a wallet with another subwallet id, nil means any subwallet id.
+	// For wallet v5, it is compared with the subwallet number of the wallet id.
+	ExpectedSubWalletId *uint32
+	// Now is a unix time DecodeMessage checks the valid-until of a message against with ValidateValidUntil,
+	// zero disables the check.
+	Now uint32
+	// MaxHorizon is a number of seconds after Now a message is allowed to expire in, zero means no limit.
+	MaxHorizon uint32
+}
+
+// PayloadDecoder decodes a list of messages of a wallet message.
+// It allows decoding messages of wallets derived from v4 which use a different payload layout,
+// while the signature and the header are decoded the same way as for v4.
+type PayloadDecoder interface {
+	// DecodePayload decodes messages from the given cell positioned right after the op field of the header.
+	DecodePayload(c *boc.Cell) ([]RawMessage, error)
+}
+
+// BodyPreprocessor returns a body a wallet decodes from the given body of an external message.
+// It allows decoding messages whose signed body is wrapped with extra data,
+// for example, with a fee and a deadline of a relayer, by stripping the wrapper.
+// The returned cell is decoded from its current read position.
+type BodyPreprocessor func(body *boc.Cell) (*boc.Cell, error)
+
+type DecodeOption func(o *DecodeOptions)
+
+// WithMaxTotalBytes makes decoding fail with ErrTooLarge
+// once data of all decoded message cells takes more than n bytes.
+// A cell referenced several times is counted every time it is referenced.
+func WithMaxTotalBytes(n int) DecodeOption {
+	return func(o *DecodeOptions) {
+		o.MaxTotalBytes = n
+	}
+}
+
+// strictDecoding is used by strict decoding functions such as DecodeMessageV4Strict.
+func strictDecoding(o *DecodeOptions) {
+	o.Strict = true
+}
+
+// checkTrailingData returns ErrTrailingData in strict mode if the given body cell is not fully read.
+// Only the body cell itself is checked, cells of decoded messages are returned as is.
+func checkTrailingData(body *boc.Cell, options DecodeOptions) error {
+	if !options.Strict || isEmptyCell(body) {
+		return nil
+	}
+	return fmt.Errorf("%w: %v bits and %v refs", ErrTrailingData, body.BitsAvailableForRead(), body.RefsAvailableForRead())
+}
+
+// WithBodyPreprocessor makes decoding functions pass a body of an external message through the given preprocessor
+// before decoding it.
+func WithBodyPreprocessor(p BodyPreprocessor) DecodeOption {
+	return func(o *DecodeOptions) {
+		o.BodyPreprocessor = p
+	}
+}
+
+// WithPayloadDecoder makes DecodeMessageV4 decode messages of a wallet message with the given decoder.
+// By default, the messages are decoded as PayloadV1toV4.
+func WithPayloadDecoder(d PayloadDecoder) DecodeOption {
+	return func(o *DecodeOptions) {
+		o.PayloadDecoder = d
+	}
+}
+
+func DecodeHighloadV2Message(msg *boc.Cell, opts ...DecodeOption) (_ *HighloadV2Message, err error) {
+	defer observeDecode(HighLoadV2R2, time.Now(), &err)
+	signedMsgBody, err := extractSignedMsgBody(msg, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return decodeHighloadV2Message(signedMsgBody, opts...)
+}
+
+// DecodeHighloadV2MessageStrict works like DecodeHighloadV2Message
+// but fails with ErrTrailingData if the message body has bits or refs left after the messages are decoded.
+func DecodeHighloadV2MessageStrict(msg *boc.Cell, opts ...DecodeOption) (*HighloadV2Message, error) {
+	return DecodeHighloadV2Message(msg, append(opts[:len(opts):len(opts)], strictDecoding)...)
+}
+
+// DecodeHighloadV2MessageExpectSubwallet works like DecodeHighloadV2Message
+// but fails with ErrSubWalletMismatch if the message is sent to a wallet with a subwallet id other than expected.
+// Highload wallets with the same key and different subwallet ids are different wallets,
+// and a message signed for one of them is rejected by the others.
+func DecodeHighloadV2MessageExpectSubwallet(msg *boc.Cell, expected uint32, opts ...DecodeOption) (*HighloadV2Message, error) {
+	m, err := DecodeHighloadV2Message(msg, opts...)
 	if err != nil {
 		return nil, err
 	}
-	return decodeHighloadV2Message(signedMsgBody)
+	if m.SubWalletId != expected {
+		return nil, fmt.Errorf("%w: want %v, got %v", ErrSubWalletMismatch, expected, m.SubWalletId)
+	}
+	return m, nil
 }
 
-func decodeHighloadV2Message(body *SignedMsgBody) (*HighloadV2Message, error) {
+func decodeHighloadV2Message(body *SignedMsgBody, opts ...DecodeOption) (*HighloadV2Message, error) {
+	options := DecodeOptions{}
+	for _, o := range opts {
+		o(&options)
+	}
 	msg := HighloadV2Message{}
 	payloadCell := boc.Cell(body.Message)
 	if err := tlb.Unmarshal(&payloadCell, &msg); err != nil {
 		return nil, err
 	}
+	if err := checkTrailingData(&payloadCell, options); err != nil {
+		return nil, err
+	}
+	if err := checkMaxTotalBytes(msg.RawMessages, options.MaxTotalBytes); err != nil {
+		return nil, err
+	}
 	return &msg, nil
 }
 
+// DecodeHighloadV1Message decodes an external message sent to a highload wallet v1.
+// HighLoadV1R1 and HighLoadV1R2 differ in get methods only, external messages of both revisions have the same layout.
+func DecodeHighloadV1Message(msg *boc.Cell, opts ...DecodeOption) (_ *HighloadV1Message, err error) {
+	defer observeDecode(HighLoadV1R2, time.Now(), &err)
+	signedMsgBody, err := extractSignedMsgBody(msg, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return decodeHighloadV1Message(signedMsgBody, opts...)
+}
+
+func decodeHighloadV1Message(body *SignedMsgBody, opts ...DecodeOption) (*HighloadV1Message, error) {
+	options := DecodeOptions{}
+	for _, o := range opts {
+		o(&options)
+	}
+	msg := HighloadV1Message{}
+	payloadCell := boc.Cell(body.Message)
+	if err := tlb.Unmarshal(&payloadCell, &msg); err != nil {
+		return nil, err
+	}
+	if err := checkTrailingData(&payloadCell, options); err != nil {
+		return nil, err
+	}
+	if err := checkMaxTotalBytes(msg.RawMessages, options.MaxTotalBytes); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// checkMaxTotalBytes returns ErrTooLarge if data of cells of the given messages takes more than maxTotalBytes.
+// Zero maxTotalBytes means no limit.
+func checkMaxTotalBytes(msgs []RawMessage, maxTotalBytes int) error {
+	if maxTotalBytes <= 0 {
+		return nil
+	}
+	remaining := maxTotalBytes
+	for _, rawMsg := range msgs {
+		if !consumeCellBytes(rawMsg.Message, &remaining) {
+			return ErrTooLarge
+		}
+	}
+	return nil
+}
+
+// consumeCellBytes subtracts a size of data of the given cell tree from remaining.
+// It stops walking the tree and returns false as soon as remaining becomes negative.
+func consumeCellBytes(c *boc.Cell, remaining *int) bool {
+	*remaining -= (c.BitSize() + 7) / 8
+	if *remaining < 0 {
+		return false
+	}
+	for _, ref := range c.Refs() {
+		if !consumeCellBytes(ref, remaining) {
+			return false
+		}
+	}
+	return true
+}
+
 // ExtractRawMessages extracts a list of RawMessages from an external message.
-func ExtractRawMessages(ver Version, msg *boc.Cell) ([]RawMessage, error) {
+func ExtractRawMessages(ver Version, msg *boc.Cell, opts ...DecodeOption) ([]RawMessage, error) {
+	var m tlb.Message
+	if err := tlb.Unmarshal(msg, &m); err != nil {
+		return nil, err
+	}
+	return ExtractRawMessagesFromTLBMessage(ver, &m, opts...)
+}
+
+// ExtractRawMessagesFromTLBMessage extracts a list of RawMessages from an already decoded external message.
+func ExtractRawMessagesFromTLBMessage(ver Version, m *tlb.Message, opts ...DecodeOption) (_ []RawMessage, err error) {
+	defer observeDecode(ver, time.Now(), &err)
 	switch ver {
 	case V5R1:
-		v5, err := DecodeMessageV5(msg)
+		v5, err := messageV5FromTLB(m, opts...)
 		if err != nil {
-			return nil, err
+			return nil, withWalletV5ID(m, err)
 		}
 		return v5.RawMessages(), nil
 	case V4R1, V4R2:
-		v4, err := DecodeMessageV4(msg)
+		// both revisions share the message layout, see DecodeMessageV4.
+		signedMsgBody, err := signedMsgBodyFromTLB(m, opts...)
+		if err != nil {
+			return nil, err
+		}
+		v4, err := decodeMessageV4(signedMsgBody, opts...)
 		if err != nil {
 			return nil, err
 		}
 		// TODO: check opcode
 		return v4.RawMessages, nil
 	case V3R1, V3R2:
-		v3, err := DecodeMessageV3(msg)
+		signedMsgBody, err := signedMsgBodyFromTLB(m, opts...)
+		if err != nil {
+			return nil, err
+		}
+		v3, err := decodeMessageV3(signedMsgBody, opts...)
 		if err != nil {
 			return nil, err
 		}
 		return v3.RawMessages, nil
 	case HighLoadV2R2:
-		hl, err := DecodeHighloadV2Message(msg)
+		signedMsgBody, err := signedMsgBodyFromTLB(m, opts...)
+		if err != nil {
+			return nil, err
+		}
+		hl, err := decodeHighloadV2Message(signedMsgBody, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return hl.RawMessages, nil
+	case HighLoadV1R1, HighLoadV1R2:
+		signedMsgBody, err := signedMsgBodyFromTLB(m, opts...)
+		if err != nil {
+			return nil, err
+		}
+		hl, err := decodeHighloadV1Message(signedMsgBody, opts...)
 		if err != nil {
 			return nil, err
 		}
@@ -205,23 +982,153 @@ func ExtractRawMessages(ver Version, msg *boc.Cell) ([]RawMessage, error) {
 	}
 }
 
+// ExtendedAction is an extended action of a wallet v5 request reported by ExtractRawMessagesDetailed.
+type ExtendedAction = V5ExtendedAction
+
+// ExtractRawMessagesDetailed works like ExtractRawMessages
+// but also returns extended actions of a wallet v5 request, which RawMessages doesn't report,
+// in the order they are executed, see SendMessageList.Extended.
+// Refs of the body that follow the request aren't part of any action the wallet executes,
+// so ErrTrailingData is returned if there are any instead of dropping them silently.
+// Messages of other wallets have no extended actions.
+func ExtractRawMessagesDetailed(ver Version, msg *boc.Cell, opts ...DecodeOption) ([]RawMessage, []ExtendedAction, error) {
+	var m tlb.Message
+	if err := tlb.Unmarshal(msg, &m); err != nil {
+		return nil, nil, err
+	}
+	if ver != V5R1 {
+		msgs, err := ExtractRawMessagesFromTLBMessage(ver, &m, opts...)
+		return msgs, nil, err
+	}
+	return extractV5MessagesDetailed(&m, opts...)
+}
+
+func extractV5MessagesDetailed(m *tlb.Message, opts ...DecodeOption) (_ []RawMessage, _ []ExtendedAction, err error) {
+	defer observeDecode(V5R1, time.Now(), &err)
+	v5, body, err := decodeMessageV5Body(m, opts...)
+	if err != nil {
+		return nil, nil, withWalletV5ID(m, err)
+	}
+	if refs := body.RefsAvailableForRead(); refs > 0 {
+		return nil, nil, fmt.Errorf("%w: %v unknown refs after the request", ErrTrailingData, refs)
+	}
+	actions, _ := v5.actions()
+	return v5.RawMessages(), actions.Extended, nil
+}
+
+// VerifyOptions configures signature verification, see VerifySignature.
+type VerifyOptions struct {
+	// DomainPrefix is prepended to the hash of the signed body before the signature is verified.
+	// Empty by default, then the signature is expected over the hash alone as standard wallets sign it.
+	DomainPrefix []byte
+}
+
+type VerifyOption func(o *VerifyOptions)
+
+// WithDomainSeparation makes VerifySignature expect a signature over the given prefix followed by the hash of the signed body,
+// as produced by wallets that separate signing domains to prevent replay of signatures across protocols.
+func WithDomainSeparation(prefix []byte) VerifyOption {
+	return func(o *VerifyOptions) {
+		o.DomainPrefix = prefix
+	}
+}
+
 // VerifySignature checks whether the given message (tlb.Message) represented as a cell
 // was signed by the given public key of a wallet contract.
 // On success, it returns nil.
 // Otherwise, it returns an error.
-func VerifySignature(ver Version, msg *boc.Cell, publicKey ed25519.PublicKey) error {
+func VerifySignature(ver Version, msg *boc.Cell, publicKey ed25519.PublicKey, opts ...VerifyOption) error {
+	var options VerifyOptions
+	for _, o := range opts {
+		o(&options)
+	}
 	switch ver {
-	case V3R1, V3R2, V4R1, V4R2, HighLoadV2R2:
+	case V3R1, V3R2, V4R1, V4R2, HighLoadV1R1, HighLoadV1R2, HighLoadV2R2:
 		signedMsgBody, err := extractSignedMsgBody(msg)
 		if err != nil {
 			return err
 		}
+		if len(options.DomainPrefix) > 0 {
+			body := boc.Cell(signedMsgBody.Message)
+			return verifyDomainSeparated(&body, signedMsgBody.Sign, publicKey, options.DomainPrefix)
+		}
 		return signedMsgBody.Verify(publicKey)
 	default:
 		return fmt.Errorf("wallet version is not supported: %v", ver)
 	}
 }
 
+// KeyResolver returns a public key a message is supposed to be signed with.
+// It is called with the decoded message, so the key can be derived from its content, for example, from the subwallet id.
+type KeyResolver func(msg *DecodedMessage) (ed25519.PublicKey, error)
+
+// VerifySignatureWithKeyResolver works like VerifySignature
+// but takes the public key from the given resolver instead of a fixed one.
+func VerifySignatureWithKeyResolver(ver Version, msg *boc.Cell, resolve KeyResolver) error {
+	decoded, err := DecodeMessage(ver, msg)
+	if err != nil {
+		return err
+	}
+	publicKey, err := resolve(decoded)
+	if err != nil {
+		return fmt.Errorf("failed to resolve public key: %w", err)
+	}
+	return VerifySignature(ver, msg, publicKey)
+}
+
+// VerifySignatureStr works like VerifySignature but takes an external message as a base64-encoded BOC
+// and a public key as a string, either 64 hex characters or 32 bytes encoded in base64.
+// Messages sent to a wallet v5 are supported as well.
+func VerifySignatureStr(ver Version, msgB64 string, pubKeyHex string) error {
+	msg, err := boc.DeserializeSinglRootBase64(msgB64)
+	if err != nil {
+		return fmt.Errorf("failed to decode message: %w", err)
+	}
+	publicKey, err := parsePublicKey(pubKeyHex)
+	if err != nil {
+		return err
+	}
+	return verifyExternalMessage(ver, msg, publicKey)
+}
+
+// parsePublicKey decodes an ed25519 public key encoded in hex or in standard or URL-safe base64.
+func parsePublicKey(s string) (ed25519.PublicKey, error) {
+	if len(s) == 2*ed25519.PublicKeySize {
+		if key, err := hex.DecodeString(s); err == nil {
+			return key, nil
+		}
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding} {
+		if key, err := encoding.DecodeString(s); err == nil && len(key) == ed25519.PublicKeySize {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid public key %q: want %v bytes in hex or base64", s, ed25519.PublicKeySize)
+}
+
+// VerifyV4WithData checks whether the given external message sent to a wallet v4
+// was signed by the public key stored in the given data cell of the wallet.
+func VerifyV4WithData(msg *boc.Cell, walletData *boc.Cell) error {
+	cell := *walletData
+	cell.ResetCounters()
+	var data DataV4
+	if err := tlb.Unmarshal(&cell, &data); err != nil {
+		return fmt.Errorf("failed to decode wallet v4 data: %w", err)
+	}
+	return VerifySignature(V4R2, msg, ed25519.PublicKey(data.PublicKey[:]))
+}
+
+// VerifyV4AgainstContract checks whether the given external message sent to a deployed wallet v4
+// was signed by the public key returned by getPubKey,
+// which is supposed to return the result of the get_public_key method of the wallet.
+func VerifyV4AgainstContract(msg *boc.Cell, getPubKey func() (ed25519.PublicKey, error)) error {
+	publicKey, err := getPubKey()
+	if err != nil {
+		return fmt.Errorf("failed to get public key: %w", err)
+	}
+	return VerifySignature(V4R2, msg, publicKey)
+}
+
 func (p PayloadV1toV4) MarshalTLB(c *boc.Cell, encoder *tlb.Encoder) error {
 	if len(p) > 4 {
 		return fmt.Errorf("WalletPayloadV1toV4 supports only up to 4 messages")
@@ -240,11 +1147,15 @@ func (p PayloadV1toV4) MarshalTLB(c *boc.Cell, encoder *tlb.Encoder) error {
 }
 
 func (p *PayloadV1toV4) UnmarshalTLB(c *boc.Cell, decoder *tlb.Decoder) error {
+	msgs := PayloadV1toV4{}
 	for {
 		ref, err := c.NextRef()
 		if err != nil {
 			break
 		}
+		if len(msgs) == 4 {
+			return fmt.Errorf("WalletPayloadV1toV4 supports only up to 4 messages")
+		}
 		mode, err := c.ReadUint(8)
 		if err != nil {
 			return err
@@ -253,15 +1164,65 @@ func (p *PayloadV1toV4) UnmarshalTLB(c *boc.Cell, decoder *tlb.Decoder) error {
 			Message: ref,
 			Mode:    byte(mode),
 		}
-		*p = append(*p, msg)
+		msgs = append(msgs, msg)
 	}
+	*p = msgs
 	return nil
 }
 
+// MergePayloadsV1toV4 concatenates messages of the given payloads and splits them into payloads of up to 4 messages,
+// each of the returned payloads is supposed to be sent in a separate external message.
+// The order of messages is preserved, and no payloads are returned if there are no messages.
+func MergePayloadsV1toV4(payloads ...PayloadV1toV4) ([]PayloadV1toV4, error) {
+	var msgs []RawMessage
+	for i, p := range payloads {
+		for j, msg := range p {
+			if msg.Message == nil {
+				return nil, fmt.Errorf("payload %v: message %v has no cell", i, j)
+			}
+			msgs = append(msgs, msg)
+		}
+	}
+	merged := make([]PayloadV1toV4, 0, (len(msgs)+3)/4)
+	for len(msgs) > 0 {
+		n := len(msgs)
+		if n > 4 {
+			n = 4
+		}
+		merged = append(merged, PayloadV1toV4(msgs[:n:n]))
+		msgs = msgs[n:]
+	}
+	return merged, nil
+}
+
+// BuildHighloadOrdered returns a PayloadHighload with the given messages sorted by less,
+// where less reports whether msgs[i] must be sent before msgs[j].
+// A highload wallet executes messages in the order of their keys, and PayloadHighload stores them under
+// sequential keys, so the first message after sorting is sent first.
+// The sort is stable, and msgs are not modified.
+func BuildHighloadOrdered(msgs []RawMessage, less func(i, j int) bool) PayloadHighload {
+	order := make([]int, len(msgs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return less(order[a], order[b])
+	})
+	payload := make(PayloadHighload, 0, len(msgs))
+	for _, i := range order {
+		payload = append(payload, msgs[i])
+	}
+	return payload
+}
+
 func (p PayloadHighload) MarshalTLB(c *boc.Cell, encoder *tlb.Encoder) error {
 	if len(p) > 254 {
 		return fmt.Errorf("PayloadHighload supports only up to 254 messages")
 	}
+	if len(p) == 0 {
+		// an empty dictionary is stored as hme_empty.
+		return c.WriteBit(false)
+	}
 	var keys []tlb.Uint16
 	var values []tlb.Any
 	for i, msg := range p {
@@ -315,40 +1276,206 @@ func (p *PayloadHighload) UnmarshalTLB(c *boc.Cell, decoder *tlb.Decoder) error
 	return nil
 }
 
+func (p PreservedPayloadHighload) MarshalTLB(c *boc.Cell, encoder *tlb.Encoder) error {
+	if !p.isModified() {
+		if p.dict == nil {
+			return c.WriteBit(false)
+		}
+		if err := c.WriteBit(true); err != nil {
+			return err
+		}
+		return c.AddRef(p.dict)
+	}
+	return p.Messages.MarshalTLB(c, encoder)
+}
+
+func (p *PreservedPayloadHighload) UnmarshalTLB(c *boc.Cell, decoder *tlb.Decoder) error {
+	rest := c.CopyRemaining()
+	var messages PayloadHighload
+	if err := messages.UnmarshalTLB(c, decoder); err != nil {
+		return err
+	}
+	exists, err := rest.ReadBit()
+	if err != nil {
+		return err
+	}
+	var dict *boc.Cell
+	if exists {
+		if dict, err = rest.NextRef(); err != nil {
+			return err
+		}
+	}
+	p.Messages = messages
+	p.decoded = append([]RawMessage{}, messages...)
+	p.dict = dict
+	return nil
+}
+
+func (p PreservedPayloadHighload) isModified() bool {
+	if p.decoded == nil || len(p.decoded) != len(p.Messages) {
+		return true
+	}
+	for i, msg := range p.Messages {
+		if msg.Mode != p.decoded[i].Mode || msg.Message != p.decoded[i].Message {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalTLB decodes a list of send_msg actions.
+//
+// The canonical layout is the TVM c5 out-list (out_list$_ prev:^(OutList n) action:OutAction),
+// where the root node holds the last action and the first ref points to the previous node.
+// Some SDKs build the list the other way around: the message goes first and the next node second,
+// so walking from the root yields actions in sending order.
+// The layout is detected by probing the root node, and Actions are always returned in sending order.
+//
+// Extended actions are not part of an out list, they are decoded by MessageV5, see decodeV5ActionList.
 func (l *SendMessageList) UnmarshalTLB(c *boc.Cell, decoder *tlb.Decoder) error {
+	outList := isOutListLayout(c)
+	tracer := actionListTracer.Load()
 	var actions []SendMessageAction
-	for {
-		switch c.BitsAvailableForRead() {
-		case 0:
+	for index := 0; ; index++ {
+		if tracer != nil {
+			tracer.tracer.TraceActionListNode(index, c.BitsAvailableForRead(), c.RefsAvailableForRead())
+		}
+		if c.BitsAvailableForRead() == 0 {
+			if outList {
+				for i, j := 0, len(actions)-1; i < j; i, j = i+1, j-1 {
+					actions[i], actions[j] = actions[j], actions[i]
+				}
+			}
 			l.Actions = actions
 			return nil
-		case 40:
-			next, err := c.NextRef()
-			if err != nil {
+		}
+		if err := checkSendMsgAction(c); err != nil {
+			return err
+		}
+		var next *boc.Cell
+		var err error
+		if outList {
+			if next, err = c.NextRef(); err != nil {
 				return err
 			}
-			var action SendMessageAction
-			if err := decoder.Unmarshal(c, &action); err != nil {
+		}
+		var action SendMessageAction
+		if err := decoder.Unmarshal(c, &action); err != nil {
+			return err
+		}
+		if !outList {
+			if next, err = c.NextRef(); err != nil {
 				return err
 			}
-			actions = append(actions, action)
-			c = next
-		default:
-			return fmt.Errorf("unexpected bits available: %v", c.BitsAvailableForRead())
 		}
+		actions = append(actions, action)
+		c = next
+	}
+}
+
+// MarshalTLB encodes the list in the c5 out list layout a wallet v5 expects:
+// the root node holds the last action and its first ref points to the node of the previous action.
+// Extended actions can't be stored in an out list, they are encoded by MessageV5, see encodeV5ActionList.
+func (l SendMessageList) MarshalTLB(c *boc.Cell, encoder *tlb.Encoder) error {
+	if len(l.Extended) > 0 {
+		return fmt.Errorf("out list can't hold %v extended actions", len(l.Extended))
+	}
+	if len(l.Actions) == 0 {
+		return nil
+	}
+	prev := boc.NewCell()
+	for _, action := range l.Actions[:len(l.Actions)-1] {
+		node := boc.NewCell()
+		if err := node.AddRef(prev); err != nil {
+			return err
+		}
+		if err := encoder.Marshal(node, action); err != nil {
+			return err
+		}
+		prev = node
+	}
+	if err := c.AddRef(prev); err != nil {
+		return err
+	}
+	return encoder.Marshal(c, l.Actions[len(l.Actions)-1])
+}
+
+// checkSendMsgAction returns an error if the unread part of c is not exactly a send_msg action prefix.
+func checkSendMsgAction(c *boc.Cell) error {
+	if c.BitsAvailableForRead() >= 32 {
+		op, err := c.PickUint(32)
+		if err != nil {
+			return err
+		}
+		if op != sendMsgActionOpcode {
+			return fmt.Errorf("unexpected action opcode %#x, want send_msg", op)
+		}
+	}
+	if c.BitsAvailableForRead() != sendMsgActionBits {
+		return fmt.Errorf("unexpected bits available: %v", c.BitsAvailableForRead())
+	}
+	return nil
+}
+
+// isOutListLayout reports whether the first ref of the given action list node points to another node.
+// An empty list and a list with a malformed root are treated as the canonical c5 layout.
+func isOutListLayout(c *boc.Cell) bool {
+	refs := c.Refs()
+	if len(refs) < 2 {
+		return true
+	}
+	if isActionListNode(refs[0]) {
+		return true
 	}
+	return !isActionListNode(refs[1])
 }
 
+// isActionListNode reports whether c is either the empty terminator of an action list or
+// a send_msg action node.
+func isActionListNode(c *boc.Cell) bool {
+	switch c.BitSize() {
+	case 0:
+		return c.RefsSize() == 0
+	case sendMsgActionBits:
+		bits := c.RawBitString()
+		bits.ResetCounter()
+		op, err := bits.ReadUint(32)
+		return err == nil && op == sendMsgActionOpcode && c.RefsSize() == 2
+	default:
+		return false
+	}
+}
+
+// MessageV5VerifySignature checks whether the given body of a wallet v5 message was signed by the given public key.
+// The unread part of msgBody is verified, and the read cursors of msgBody are left untouched,
+// so the same cell can be decoded after verification.
+// The unread part must start with the sint or sign prefix, because the prefix is signed too.
+// This is the preimage the contract checks: the whole request without the signature,
+// including the prefix, the wallet id, valid until, seqno, the op bit and the ref to the actions.
+//
+// Refs of the body can be pruned branches, the hash of the original body is verified then.
+//
+// The signature is taken from the last 512 bits of the cell.
+// This is the same place MessageV5 decodes Signature from:
+// Signature is the last field of a signed request, it follows the action list, whose extended actions are stored inline.
 func MessageV5VerifySignature(msgBody boc.Cell, publicKey ed25519.PublicKey) error {
-	totalBits := msgBody.BitsAvailableForRead()
+	body := msgBody.CopyRemaining()
+	prefix, err := body.PickUint(32)
+	if err != nil {
+		return err
+	}
+	if prefix != 0x7369676e && prefix != 0x73696e74 {
+		return fmt.Errorf("not a wallet v5 signed request: unexpected prefix %#x", prefix)
+	}
+	totalBits := body.BitsAvailableForRead()
 	if totalBits < 512 {
 		return fmt.Errorf("not enough bits in the cell")
 	}
-	bits, err := msgBody.ReadBits(totalBits - 512)
+	bits, err := body.ReadBits(totalBits - 512)
 	if err != nil {
 		return err
 	}
-	signature, err := msgBody.ReadBytes(64)
+	signature, err := body.ReadBytes(64)
 	if err != nil {
 		return err
 	}
@@ -356,16 +1483,12 @@ func MessageV5VerifySignature(msgBody boc.Cell, publicKey ed25519.PublicKey) err
 	if err := msgCopy.WriteBitString(bits); err != nil {
 		return err
 	}
-	for i := 0; i < msgBody.RefsSize(); i++ {
-		ref, err := msgBody.NextRef()
-		if err != nil {
-			return err
-		}
+	for _, ref := range body.Refs() {
 		if err := msgCopy.AddRef(ref); err != nil {
 			return err
 		}
 	}
-	hash, err := msgCopy.Hash()
+	hash, err := msgCopy.HashAtLevel(0)
 	if err != nil {
 		return err
 	}
@@ -375,27 +1498,117 @@ func MessageV5VerifySignature(msgBody boc.Cell, publicKey ed25519.PublicKey) err
 	return ErrBadSignature
 }
 
-func (m *MessageV5) RawMessages() []RawMessage {
+// BuildV5SigningCell builds a body of an external message to a wallet v5 without a signature.
+// The hash of the returned cell is what has to be signed,
+// and the signed body is the returned cell with the 512-bit signature written at the end of its bits.
+// op is the tag of the action list, it must be true if and only if actions has extended actions.
+func BuildV5SigningCell(walletID tlb.Bits80, validUntil, seqno uint32, op bool, actions SendMessageList) (*boc.Cell, error) {
+	request := signedRequestV5{
+		SubWalletId: walletID,
+		ValidUntil:  validUntil,
+		Seqno:       seqno,
+		Op:          op,
+		Actions:     actions,
+	}
+	cell := boc.NewCell()
+	if err := encodeSignedRequestV5(cell, 0x7369676e, request, &tlb.Encoder{}); err != nil {
+		return nil, err
+	}
+	return cell, nil
+}
+
+// VerifyV5Internal checks whether an internal message carrying a Sint request of a wallet v5
+// was signed by the given public key.
+// The signature covers the body of the internal message only, so the message wrapper added by a relayer doesn't matter.
+func VerifyV5Internal(internalMsg *boc.Cell, key ed25519.PublicKey) error {
+	var m tlb.Message
+	if err := tlb.Unmarshal(internalMsg, &m); err != nil {
+		return err
+	}
+	if m.Info.SumType != "IntMsgInfo" {
+		return ErrNotInternalMessage
+	}
+	body := boc.Cell(m.Body.Value)
+	prefix, err := body.PickUint(32)
+	if err != nil {
+		return err
+	}
+	if prefix != 0x73696e74 {
+		return fmt.Errorf("not a wallet v5 internal signed request")
+	}
+	return MessageV5VerifySignature(body, key)
+}
+
+// VerifyV5WithAuthMode checks whether the given message carrying a signed request of a wallet v5
+// was signed by the given public key and can be accepted by a wallet whose signature authentication mode is signatureAllowed.
+// ErrSignatureAuthDisabled is returned for a signed request if signature authentication is disabled,
+// the signature is not verified then.
+// ErrExtensionRequest is returned for a request of an extension regardless of the mode, as there is nothing to verify.
+func VerifyV5WithAuthMode(msg *boc.Cell, key ed25519.PublicKey, signatureAllowed bool) error {
+	var m tlb.Message
+	if err := tlb.Unmarshal(msg, &m); err != nil {
+		return err
+	}
+	body := boc.Cell(m.Body.Value)
+	if prefix, err := body.PickUint(32); err == nil && prefix == 0x6578746e {
+		return ErrExtensionRequest
+	}
+	if !signatureAllowed {
+		if prefix, err := body.PickUint(32); err == nil && (prefix == 0x7369676e || prefix == 0x73696e74) {
+			return ErrSignatureAuthDisabled
+		}
+	}
+	return MessageV5VerifySignature(body, key)
+}
+
+// signedRequest returns a signed request of this message regardless of how it was delivered to a wallet.
+// nil is returned for requests of extensions because they aren't signed.
+func (m *MessageV5) signedRequest() *signedRequestV5 {
 	switch m.SumType {
 	case "Sint":
-		msgs := make([]RawMessage, 0, len(m.Sint.Actions.Actions))
-		for _, action := range m.Sint.Actions.Actions {
-			msgs = append(msgs, RawMessage{
-				Message: action.Msg,
-				Mode:    action.Mode,
-			})
-		}
-		return msgs
+		return &m.Sint
 	case "Sign":
-		msgs := make([]RawMessage, 0, len(m.Sign.Actions.Actions))
-		for _, action := range m.Sign.Actions.Actions {
-			msgs = append(msgs, RawMessage{
-				Message: action.Msg,
-				Mode:    action.Mode,
-			})
-		}
-		return msgs
+		return &m.Sign
 	default:
 		return nil
 	}
 }
+
+// Workchain returns a workchain encoded in the wallet id of the message.
+// Messages of v3, v4 and highload wallets don't contain a workchain at all,
+// so it can be learned only from an address of such a wallet.
+// Zero is returned if the message type is unknown.
+func (m *MessageV5) Workchain() int8 {
+	request := m.signedRequest()
+	if request == nil {
+		return 0
+	}
+	return int8(decodeWalletV5ID(request.SubWalletId).Workchain)
+}
+
+// actions returns the action list of this message.
+func (m *MessageV5) actions() (SendMessageList, bool) {
+	if m.SumType == "Extn" {
+		return m.Extn.Actions, true
+	}
+	request := m.signedRequest()
+	if request == nil {
+		return SendMessageList{}, false
+	}
+	return request.Actions, true
+}
+
+func (m *MessageV5) RawMessages() []RawMessage {
+	actions, ok := m.actions()
+	if !ok {
+		return nil
+	}
+	msgs := make([]RawMessage, 0, len(actions.Actions))
+	for _, action := range actions.Actions {
+		msgs = append(msgs, RawMessage{
+			Message: action.Msg,
+			Mode:    action.Mode,
+		})
+	}
+	return msgs
+}
@@ -1,14 +1,20 @@
 package wallet
 
 import (
+	"bytes"
 	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/tonkeeper/tongo/boc"
 	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
 )
 
 func mustFromHex(msg string) *boc.Cell {
@@ -74,7 +80,7 @@ func TestExtractRawMessages(t *testing.T) {
 			ver:  V5R1,
 			want: []RawMessage{
 				{
-					Message: mustFromHex("te6ccgEBAQEAVwAAqWgB6G+oc+JDZG77OSjCMnH/ugluoRhGeUI6497n7aCbbhcAGzNMlqFjDeWk/rivKqxwpBoMaThCmw7tE7othW8odIgMtxsAAAAAAAAAAAAAAAAAAEA="),
+					Message: mustFromHex("te6ccgEBAQEAVwAAqWgB6G+oc+JDZG77OSjCMnH/ugluoRhGeUI6497n7aCbbhcAGzNMlqFjDeWk/rivKqxwpBoMaThCmw7tE7othW8odIgMPQkAAAAAAAAAAAAAAAAAAEA="),
 					Mode:    3,
 				},
 				{
@@ -82,7 +88,7 @@ func TestExtractRawMessages(t *testing.T) {
 					Mode:    3,
 				},
 				{
-					Message: mustFromHex("te6ccgEBAQEAVwAAqWgB6G+oc+JDZG77OSjCMnH/ugluoRhGeUI6497n7aCbbhcAGzNMlqFjDeWk/rivKqxwpBoMaThCmw7tE7othW8odIgMPQkAAAAAAAAAAAAAAAAAAEA="),
+					Message: mustFromHex("te6ccgEBAQEAVwAAqWgB6G+oc+JDZG77OSjCMnH/ugluoRhGeUI6497n7aCbbhcAGzNMlqFjDeWk/rivKqxwpBoMaThCmw7tE7othW8odIgMtxsAAAAAAAAAAAAAAAAAAEA="),
 					Mode:    3,
 				},
 			},
@@ -130,6 +136,18 @@ func TestExtractRawMessages(t *testing.T) {
 				}
 				t.Fatalf("wrong raw messages")
 			}
+			c[0].ResetCounters()
+			var m tlb.Message
+			if err := tlb.Unmarshal(c[0], &m); err != nil {
+				t.Fatal(err)
+			}
+			fromTLB, err := ExtractRawMessagesFromTLBMessage(tt.ver, &m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(fromTLB, tt.want) {
+				t.Fatalf("ExtractRawMessagesFromTLBMessage() returned wrong raw messages")
+			}
 		})
 	}
 }
@@ -245,6 +263,1922 @@ func TestMessageV5VerifySignature(t *testing.T) {
 					t.Fatalf("MessageV5VerifySignature() had to fail but it didn't")
 				}
 			}
+			tail := msgBody.CopyRemaining()
+			if err := tail.Skip(tail.BitsAvailableForRead() - 512); err != nil {
+				t.Fatalf("Skip() failed: %v", err)
+			}
+			lastBits, err := tail.ReadBytes(64)
+			if err != nil {
+				t.Fatalf("ReadBytes() failed: %v", err)
+			}
+			var msgv5 MessageV5
+			if err := tlb.Unmarshal(&msgBody, &msgv5); err != nil {
+				t.Fatalf("Unmarshal() after MessageV5VerifySignature() failed: %v", err)
+			}
+			if signature := msgv5.signedRequest().Signature; !reflect.DeepEqual(signature[:], lastBits) {
+				t.Fatalf("MessageV5.Signature must be the last 512 bits of the body")
+			}
+		})
+	}
+}
+
+func TestMessageV5VerifySignature_Prefix(t *testing.T) {
+	// a real wallet v5 external message from mainnet
+	cell := mustFromHex("te6ccgECCAEAAZ4AAfGIAehvqHPiQ2Ru+zkowjJx/7oJbqEYRnlCOuPe5+2gm24WA5tLO3f////oAAAAAAADMY8YiAAAADPkc94coPiaMQo1EI1uuJWlVQGxiffff96PyOTGiQhUjkr733UkT8rfdXxuYcb9SMykg8Tlo7LNBB187eI+ymw2AQIKDsPIbQMCAwIKDsPIbQMEBQCpaAHob6hz4kNkbvs5KMIycf+6CW6hGEZ5Qjrj3uftoJtuFwAbM0yWoWMN5aT+uK8qrHCkGgxpOEKbDu0Tui2Fbyh0iAy3GwAAAAAAAAAAAAAAAAAAQAIKDsPIbQMGBwCpaAHob6hz4kNkbvs5KMIycf+6CW6hGEZ5Qjrj3uftoJtuFwAbM0yWoWMN5aT+uK8qrHCkGgxpOEKbDu0Tui2Fbyh0iAx6EgAAAAAAAAAAAAAAAAAAQAAAAKloAehvqHPiQ2Ru+zkowjJx/7oJbqEYRnlCOuPe5+2gm24XABszTJahYw3lpP64ryqscKQaDGk4QpsO7RO6LYVvKHSIDD0JAAAAAAAAAAAAAAAAAABA")
+	publicKey := mustPubkeyFromHex("406b63856ff6913fe2170a5c128113c6bd8256438a43340ea3bf6e0bbc56f9ca")
+	var m tlb.Message
+	if err := tlb.Unmarshal(cell, &m); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	// the prefix is a part of the signed preimage, so a body read past it can't be verified.
+	msgBody := boc.Cell(m.Body.Value)
+	if err := msgBody.Skip(32); err != nil {
+		t.Fatalf("Skip() failed: %v", err)
+	}
+	err := MessageV5VerifySignature(msgBody, publicKey)
+	if err == nil || errors.Is(err, ErrBadSignature) {
+		t.Fatalf("want an error about a missing prefix, got %v", err)
+	}
+}
+
+func TestSendMessageList_UnmarshalTLB(t *testing.T) {
+	newMsg := func(i int) *boc.Cell {
+		c := boc.NewCell()
+		if err := c.WriteUint(uint64(i), 64); err != nil {
+			t.Fatalf("WriteUint() failed: %v", err)
+		}
+		return c
+	}
+	newNode := func(first, second *boc.Cell) *boc.Cell {
+		c := boc.NewCell()
+		if err := c.WriteUint(0x0ec3c86d, 32); err != nil {
+			t.Fatalf("WriteUint() failed: %v", err)
+		}
+		if err := c.WriteUint(3, 8); err != nil {
+			t.Fatalf("WriteUint() failed: %v", err)
+		}
+		if err := c.AddRef(first); err != nil {
+			t.Fatalf("AddRef() failed: %v", err)
+		}
+		if err := c.AddRef(second); err != nil {
+			t.Fatalf("AddRef() failed: %v", err)
+		}
+		return c
+	}
+	msgs := []*boc.Cell{newMsg(1), newMsg(2), newMsg(3)}
+
+	outList := boc.NewCell()
+	for _, msg := range msgs {
+		outList = newNode(outList, msg)
+	}
+	forward := boc.NewCell()
+	for i := len(msgs) - 1; i >= 0; i-- {
+		forward = newNode(msgs[i], forward)
+	}
+	tests := []struct {
+		name string
+		cell *boc.Cell
+	}{
+		{name: "c5 out list", cell: outList},
+		{name: "forward list", cell: forward},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var list SendMessageList
+			if err := tlb.Unmarshal(tt.cell, &list); err != nil {
+				t.Fatalf("Unmarshal() failed: %v", err)
+			}
+			if len(list.Actions) != len(msgs) {
+				t.Fatalf("want %v actions, got %v", len(msgs), len(list.Actions))
+			}
+			for i, action := range list.Actions {
+				got, err := action.Msg.HashString()
+				if err != nil {
+					t.Fatalf("HashString() failed: %v", err)
+				}
+				want, err := msgs[i].HashString()
+				if err != nil {
+					t.Fatalf("HashString() failed: %v", err)
+				}
+				if got != want {
+					t.Fatalf("action %v: wrong message order", i)
+				}
+			}
+		})
+	}
+}
+
+func TestSendMessageList_UnmarshalTLB_NotSendMsg(t *testing.T) {
+	c := boc.NewCell()
+	if err := c.WriteUint(0x0ec3c86e, 32); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	if err := c.WriteUint(3, 8); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	if err := c.AddRef(boc.NewCell()); err != nil {
+		t.Fatalf("AddRef() failed: %v", err)
+	}
+	if err := c.AddRef(boc.NewCell()); err != nil {
+		t.Fatalf("AddRef() failed: %v", err)
+	}
+	var list SendMessageList
+	err := tlb.Unmarshal(c, &list)
+	if err == nil {
+		t.Fatalf("Unmarshal() had to fail but it didn't")
+	}
+	if !strings.Contains(err.Error(), "unexpected action opcode 0xec3c86e, want send_msg") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestHighloadV2MessagePreserved(t *testing.T) {
+	cell := mustFromHex("te6ccgECCQEAAUMAAUWIAbeTPaOhIeFpX00pVBankGP2F/kaObq5EAdGLvI+omE+DAEBmXzKceTPz+weyz8nYZbOkpsBYbvy6gN7h38ZVL6RTqln7XbUzHkQqxRp1B1ZYkBgMW1NtE7r8Jwg26HcS3qPiwYAAYiUZMJyTpfTrVXAAgIFngACAwQBAwDgBQEDAOAHAWJCADZmmS1CxhvLSf1xXlVY4Ug0GNJwhTYd2id0WwreUOkQCKAAAAAAAAAAAAAAAAABBgBQAAAAADcwMzBhYzQ2LWI5NWMtNDRjNy04ZDdiLTYxMjMyNmU2ZTUxMgFiQgA2ZpktQsYby0n9cV5VWOFINBjScIU2HdondFsK3lDpEAlAAAAAAAAAAAAAAAAAAQgAUAAAAAAzYjA2OTU1YS03YjRjLTQ1YWEtOTVlNy0wNTI4ZWZhYjAyM2E=")
+	body, err := extractSignedMsgBody(cell)
+	if err != nil {
+		t.Fatalf("extractSignedMsgBody() failed: %v", err)
+	}
+	payload := boc.Cell(body.Message)
+	wantHash, err := payload.HashString()
+	if err != nil {
+		t.Fatalf("HashString() failed: %v", err)
+	}
+	var msg HighloadV2MessagePreserved
+	if err := tlb.Unmarshal(&payload, &msg); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	if len(msg.RawMessages.Messages) != 2 {
+		t.Fatalf("want 2 messages, got %v", len(msg.RawMessages.Messages))
+	}
+	encoded := boc.NewCell()
+	if err := tlb.Marshal(encoded, msg); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	gotHash, err := encoded.HashString()
+	if err != nil {
+		t.Fatalf("HashString() failed: %v", err)
+	}
+	if gotHash != wantHash {
+		t.Fatalf("want hash %v, got %v", wantHash, gotHash)
+	}
+
+	msg.RawMessages.Messages = msg.RawMessages.Messages[:1]
+	modified := boc.NewCell()
+	if err := tlb.Marshal(modified, msg); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	var decoded HighloadV2Message
+	if err := tlb.Unmarshal(modified, &decoded); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	if len(decoded.RawMessages) != 1 {
+		t.Fatalf("want 1 message, got %v", len(decoded.RawMessages))
+	}
+}
+
+func TestVerifyV5Internal(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	newBody := func(prefix uint64) *boc.Cell {
+		body := boc.NewCell()
+		for _, f := range []struct {
+			value uint64
+			bits  int
+		}{{prefix, 32}, {0xffffff11, 32}, {0, 48}, {1_700_000_000, 32}, {7, 32}} {
+			if err := body.WriteUint(f.value, f.bits); err != nil {
+				t.Fatalf("WriteUint() failed: %v", err)
+			}
+		}
+		if err := body.WriteBit(false); err != nil {
+			t.Fatalf("WriteBit() failed: %v", err)
+		}
+		if err := body.AddRef(boc.NewCell()); err != nil {
+			t.Fatalf("AddRef() failed: %v", err)
+		}
+		hash, err := body.Hash()
+		if err != nil {
+			t.Fatalf("Hash() failed: %v", err)
+		}
+		if err := body.WriteBytes(ed25519.Sign(privateKey, hash)); err != nil {
+			t.Fatalf("WriteBytes() failed: %v", err)
+		}
+		return body
+	}
+	newInternal := func(body *boc.Cell) *boc.Cell {
+		return mustRawMessage(t, Message{Amount: 1_000_000, Body: body, Mode: 3}).Message
+	}
+	if err := VerifyV5Internal(newInternal(newBody(0x73696e74)), publicKey); err != nil {
+		t.Fatalf("VerifyV5Internal() failed: %v", err)
+	}
+	otherKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	if err := VerifyV5Internal(newInternal(newBody(0x73696e74)), otherKey.Public().(ed25519.PublicKey)); err != ErrBadSignature {
+		t.Fatalf("want ErrBadSignature, got %v", err)
+	}
+	if err := VerifyV5Internal(newInternal(newBody(0x7369676e)), publicKey); err == nil {
+		t.Fatalf("external signed request must be rejected")
+	}
+}
+
+func TestVerifyV5WithAuthMode(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	otherKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	hdr := MessageHeader{WalletID: [10]byte{0xff, 0xff, 0xff, 0x11}, ValidUntil: 1_700_000_000, Seqno: 5}
+	msg, err := AssembleExternalMessage(V5R1, privateKey, hdr, nil)
+	if err != nil {
+		t.Fatalf("AssembleExternalMessage() failed: %v", err)
+	}
+	tests := []struct {
+		name             string
+		key              ed25519.PublicKey
+		signatureAllowed bool
+		wantErr          error
+	}{
+		{name: "signature allowed", key: privateKey.Public().(ed25519.PublicKey), signatureAllowed: true},
+		{name: "signature disabled", key: privateKey.Public().(ed25519.PublicKey), wantErr: ErrSignatureAuthDisabled},
+		{name: "another key", key: otherKey.Public().(ed25519.PublicKey), signatureAllowed: true, wantErr: ErrBadSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg.ResetCounters()
+			if err := VerifyV5WithAuthMode(msg, tt.key, tt.signatureAllowed); err != tt.wantErr {
+				t.Fatalf("want %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	var extn MessageV5
+	extn.SumType = "Extn"
+	extn.Extn.QueryID = 7
+	body := boc.NewCell()
+	if err := tlb.Marshal(body, extn); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	address := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	internal, _, err := Message{Amount: 1_000, Address: address, Body: body}.ToInternal()
+	if err != nil {
+		t.Fatalf("ToInternal() failed: %v", err)
+	}
+	extnMsg := boc.NewCell()
+	if err := tlb.Marshal(extnMsg, internal); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	for _, signatureAllowed := range []bool{false, true} {
+		extnMsg.ResetCounters()
+		if err := VerifyV5WithAuthMode(extnMsg, privateKey.Public().(ed25519.PublicKey), signatureAllowed); err != ErrExtensionRequest {
+			t.Fatalf("want ErrExtensionRequest, got %v", err)
+		}
+	}
+}
+
+func TestDecodeHighloadV2Message_WithMaxTotalBytes(t *testing.T) {
+	const hl = "te6ccgECCQEAAUMAAUWIAbeTPaOhIeFpX00pVBankGP2F/kaObq5EAdGLvI+omE+DAEBmXzKceTPz+weyz8nYZbOkpsBYbvy6gN7h38ZVL6RTqln7XbUzHkQqxRp1B1ZYkBgMW1NtE7r8Jwg26HcS3qPiwYAAYiUZMJyTpfTrVXAAgIFngACAwQBAwDgBQEDAOAHAWJCADZmmS1CxhvLSf1xXlVY4Ug0GNJwhTYd2id0WwreUOkQCKAAAAAAAAAAAAAAAAABBgBQAAAAADcwMzBhYzQ2LWI5NWMtNDRjNy04ZDdiLTYxMjMyNmU2ZTUxMgFiQgA2ZpktQsYby0n9cV5VWOFINBjScIU2HdondFsK3lDpEAlAAAAAAAAAAAAAAAAAAQgAUAAAAAAzYjA2OTU1YS03YjRjLTQ1YWEtOTVlNy0wNTI4ZWZhYjAyM2E="
+	msg, err := DecodeHighloadV2Message(mustFromHex(hl), WithMaxTotalBytes(1024))
+	if err != nil {
+		t.Fatalf("DecodeHighloadV2Message() failed: %v", err)
+	}
+	if len(msg.RawMessages) != 2 {
+		t.Fatalf("want 2 messages, got %v", len(msg.RawMessages))
+	}
+	if _, err := DecodeHighloadV2Message(mustFromHex(hl), WithMaxTotalBytes(100)); err != ErrTooLarge {
+		t.Fatalf("want ErrTooLarge, got %v", err)
+	}
+}
+
+func TestDecodeHighloadV2MessageExpectSubwallet(t *testing.T) {
+	const hl = "te6ccgECCQEAAUMAAUWIAbeTPaOhIeFpX00pVBankGP2F/kaObq5EAdGLvI+omE+DAEBmXzKceTPz+weyz8nYZbOkpsBYbvy6gN7h38ZVL6RTqln7XbUzHkQqxRp1B1ZYkBgMW1NtE7r8Jwg26HcS3qPiwYAAYiUZMJyTpfTrVXAAgIFngACAwQBAwDgBQEDAOAHAWJCADZmmS1CxhvLSf1xXlVY4Ug0GNJwhTYd2id0WwreUOkQCKAAAAAAAAAAAAAAAAABBgBQAAAAADcwMzBhYzQ2LWI5NWMtNDRjNy04ZDdiLTYxMjMyNmU2ZTUxMgFiQgA2ZpktQsYby0n9cV5VWOFINBjScIU2HdondFsK3lDpEAlAAAAAAAAAAAAAAAAAAQgAUAAAAAAzYjA2OTU1YS03YjRjLTQ1YWEtOTVlNy0wNTI4ZWZhYjAyM2E="
+	msg, err := DecodeHighloadV2Message(mustFromHex(hl))
+	if err != nil {
+		t.Fatalf("DecodeHighloadV2Message() failed: %v", err)
+	}
+	if _, err := DecodeHighloadV2MessageExpectSubwallet(mustFromHex(hl), msg.SubWalletId); err != nil {
+		t.Fatalf("DecodeHighloadV2MessageExpectSubwallet() failed: %v", err)
+	}
+	if _, err := DecodeHighloadV2MessageExpectSubwallet(mustFromHex(hl), msg.SubWalletId+1); !errors.Is(err, ErrSubWalletMismatch) {
+		t.Fatalf("want ErrSubWalletMismatch, got %v", err)
+	}
+}
+
+func TestDecodeHighloadV1Message(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	recipient := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	msgs := PayloadHighload{
+		mustRawMessage(t, Message{Amount: 1_000, Address: recipient, Mode: 3}),
+		mustRawMessage(t, Message{Amount: 2_000, Address: recipient, Mode: 1}),
+	}
+	body, err := signMsgBody(privateKey, HighloadV1Message{
+		SubWalletId: DefaultSubWallet,
+		ValidUntil:  1_700_000_000,
+		Seqno:       9,
+		RawMessages: msgs,
+	})
+	if err != nil {
+		t.Fatalf("signMsgBody() failed: %v", err)
+	}
+	address := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	extMsg, err := ton.CreateExternalMessage(address, body, nil, 0)
+	if err != nil {
+		t.Fatalf("CreateExternalMessage() failed: %v", err)
+	}
+	cell := boc.NewCell()
+	if err := tlb.Marshal(cell, extMsg); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	msg, err := DecodeHighloadV1Message(cell)
+	if err != nil {
+		t.Fatalf("DecodeHighloadV1Message() failed: %v", err)
+	}
+	if msg.SubWalletId != DefaultSubWallet || msg.ValidUntil != 1_700_000_000 || msg.Seqno != 9 || len(msg.RawMessages) != len(msgs) {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+	for _, ver := range []Version{HighLoadV1R1, HighLoadV1R2} {
+		cell.ResetCounters()
+		rawMessages, err := ExtractRawMessages(ver, cell)
+		if err != nil {
+			t.Fatalf("ExtractRawMessages() failed: %v", err)
+		}
+		for i, rawMsg := range rawMessages {
+			if rawMsg.Mode != msgs[i].Mode {
+				t.Fatalf("message %v: want mode %v, got %v", i, msgs[i].Mode, rawMsg.Mode)
+			}
+		}
+		cell.ResetCounters()
+		if err := VerifySignature(ver, cell, privateKey.Public().(ed25519.PublicKey)); err != nil {
+			t.Fatalf("VerifySignature() failed: %v", err)
+		}
+	}
+}
+
+func TestVerifySignature_DomainSeparation(t *testing.T) {
+	privateKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	prefix := []byte("hardened-wallet")
+	bodyCell := boc.NewCell()
+	if err := tlb.Marshal(bodyCell, MessageV4{SubWalletId: DefaultSubWallet, ValidUntil: 1_700_000_000, Seqno: 3}); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	hash, err := bodyCell.Hash()
+	if err != nil {
+		t.Fatalf("Hash() failed: %v", err)
+	}
+	signedBody := SignedMsgBody{Message: tlb.Any(*bodyCell)}
+	copy(signedBody.Sign[:], ed25519.Sign(privateKey, append(append([]byte{}, prefix...), hash...)))
+	body := boc.NewCell()
+	if err := tlb.Marshal(body, signedBody); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	address := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	extMsg, err := ton.CreateExternalMessage(address, body, nil, 0)
+	if err != nil {
+		t.Fatalf("CreateExternalMessage() failed: %v", err)
+	}
+	cell := boc.NewCell()
+	if err := tlb.Marshal(cell, extMsg); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	if err := VerifySignature(V4R2, cell, publicKey, WithDomainSeparation(prefix)); err != nil {
+		t.Fatalf("VerifySignature() failed: %v", err)
+	}
+	cell.ResetCounters()
+	if err := VerifySignature(V4R2, cell, publicKey); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("want ErrBadSignature without the prefix, got %v", err)
+	}
+	cell.ResetCounters()
+	if err := VerifySignature(V4R2, cell, publicKey, WithDomainSeparation([]byte("other-wallet"))); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("want ErrBadSignature with another prefix, got %v", err)
+	}
+}
+
+func TestBuildV5SigningCell(t *testing.T) {
+	cell := mustFromHex("te6ccgECCAEAAZ4AAfGIAehvqHPiQ2Ru+zkowjJx/7oJbqEYRnlCOuPe5+2gm24WA5tLO3f////oAAAAAAADMY8YiAAAADPkc94coPiaMQo1EI1uuJWlVQGxiffff96PyOTGiQhUjkr733UkT8rfdXxuYcb9SMykg8Tlo7LNBB187eI+ymw2AQIKDsPIbQMCAwIKDsPIbQMEBQCpaAHob6hz4kNkbvs5KMIycf+6CW6hGEZ5Qjrj3uftoJtuFwAbM0yWoWMN5aT+uK8qrHCkGgxpOEKbDu0Tui2Fbyh0iAy3GwAAAAAAAAAAAAAAAAAAQAIKDsPIbQMGBwCpaAHob6hz4kNkbvs5KMIycf+6CW6hGEZ5Qjrj3uftoJtuFwAbM0yWoWMN5aT+uK8qrHCkGgxpOEKbDu0Tui2Fbyh0iAx6EgAAAAAAAAAAAAAAAAAAQAAAAKloAehvqHPiQ2Ru+zkowjJx/7oJbqEYRnlCOuPe5+2gm24XABszTJahYw3lpP64ryqscKQaDGk4QpsO7RO6LYVvKHSIDD0JAAAAAAAAAAAAAAAAAABA")
+	msg, err := DecodeMessageV5(cell)
+	if err != nil {
+		t.Fatalf("DecodeMessageV5() failed: %v", err)
+	}
+	request := msg.signedRequest()
+	signingCell, err := BuildV5SigningCell(request.SubWalletId, request.ValidUntil, request.Seqno, request.Op, request.Actions)
+	if err != nil {
+		t.Fatalf("BuildV5SigningCell() failed: %v", err)
+	}
+	hash, err := signingCell.Hash()
+	if err != nil {
+		t.Fatalf("Hash() failed: %v", err)
+	}
+	publicKey := mustPubkeyFromHex("406b63856ff6913fe2170a5c128113c6bd8256438a43340ea3bf6e0bbc56f9ca")
+	if !ed25519.Verify(publicKey, hash, request.Signature[:]) {
+		t.Fatalf("signature of the original message doesn't match the signing cell")
+	}
+}
+
+func TestParseW5SignedRequest(t *testing.T) {
+	cell := mustFromHex("te6ccgECCAEAAZ4AAfGIAehvqHPiQ2Ru+zkowjJx/7oJbqEYRnlCOuPe5+2gm24WA5tLO3f////oAAAAAAADMY8YiAAAADPkc94coPiaMQo1EI1uuJWlVQGxiffff96PyOTGiQhUjkr733UkT8rfdXxuYcb9SMykg8Tlo7LNBB187eI+ymw2AQIKDsPIbQMCAwIKDsPIbQMEBQCpaAHob6hz4kNkbvs5KMIycf+6CW6hGEZ5Qjrj3uftoJtuFwAbM0yWoWMN5aT+uK8qrHCkGgxpOEKbDu0Tui2Fbyh0iAy3GwAAAAAAAAAAAAAAAAAAQAIKDsPIbQMGBwCpaAHob6hz4kNkbvs5KMIycf+6CW6hGEZ5Qjrj3uftoJtuFwAbM0yWoWMN5aT+uK8qrHCkGgxpOEKbDu0Tui2Fbyh0iAx6EgAAAAAAAAAAAAAAAAAAQAAAAKloAehvqHPiQ2Ru+zkowjJx/7oJbqEYRnlCOuPe5+2gm24XABszTJahYw3lpP64ryqscKQaDGk4QpsO7RO6LYVvKHSIDD0JAAAAAAAAAAAAAAAAAABA")
+	msgB64, err := cell.ToBocBase64()
+	if err != nil {
+		t.Fatalf("ToBocBase64() failed: %v", err)
+	}
+	var m tlb.Message
+	if err := tlb.Unmarshal(cell, &m); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	body := boc.Cell(m.Body.Value)
+	bodyB64, err := body.CopyRemaining().ToBocBase64()
+	if err != nil {
+		t.Fatalf("ToBocBase64() failed: %v", err)
+	}
+	for _, b64 := range []string{msgB64, bodyB64} {
+		msg, err := ParseW5SignedRequest(b64)
+		if err != nil {
+			t.Fatalf("ParseW5SignedRequest() failed: %v", err)
+		}
+		if msg.SumType != "Sign" || len(msg.Sign.Actions.Actions) != 3 {
+			t.Fatalf("unexpected message: %v, %v actions", msg.SumType, len(msg.Sign.Actions.Actions))
+		}
+	}
+}
+
+func TestMessageV4_Expiry(t *testing.T) {
+	msg := MessageV4{ValidUntil: 1_700_000_000}
+	expiry, ok := msg.Expiry()
+	if !msg.HasExpiry() || !ok || expiry.Unix() != 1_700_000_000 {
+		t.Fatalf("want expiry at 1700000000, got %v, %v", expiry, ok)
+	}
+	msg.ValidUntil = 0xFFFFFFFF
+	if _, ok := msg.Expiry(); msg.HasExpiry() || ok {
+		t.Fatalf("max valid until must mean no expiry")
+	}
+}
+
+func TestVerifyDetached(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	body := boc.NewCell()
+	if err := body.WriteUint(0xdeadbeef, 32); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	signature, err := body.Sign(privateKey)
+	if err != nil {
+		t.Fatalf("Sign() failed: %v", err)
+	}
+	var sig tlb.Bits512
+	copy(sig[:], signature)
+	if err := VerifyDetached(body, sig, privateKey.Public().(ed25519.PublicKey)); err != nil {
+		t.Fatalf("VerifyDetached() failed: %v", err)
+	}
+	sig[0] ^= 1
+	if err := VerifyDetached(body, sig, privateKey.Public().(ed25519.PublicKey)); err != ErrBadSignature {
+		t.Fatalf("want ErrBadSignature, got %v", err)
+	}
+}
+
+func TestMessageV5_Workchain(t *testing.T) {
+	var msg MessageV5
+	msg.SumType = "Sign"
+	msg.Sign.SubWalletId = tlb.Bits80{0xff, 0xff, 0xff, 0x11, 0xff, 0, 0, 0, 0, 0}
+	if wc := msg.Workchain(); wc != -1 {
+		t.Fatalf("want workchain -1, got %v", wc)
+	}
+	msg.Sign.SubWalletId[4] = 0
+	if wc := msg.Workchain(); wc != 0 {
+		t.Fatalf("want workchain 0, got %v", wc)
+	}
+}
+
+func TestPackHighloadQueryID(t *testing.T) {
+	q := PackHighloadQueryID(1_700_000_000, 42)
+	if q != 0x6553f10000000000+42 {
+		t.Fatalf("unexpected query id: %#x", q)
+	}
+	timeout, id := UnpackHighloadQueryID(q)
+	if timeout != 1_700_000_000 || id != 42 {
+		t.Fatalf("want (1700000000, 42), got (%v, %v)", timeout, id)
+	}
+}
+
+func TestHighloadV2Message_ExpiresWithin(t *testing.T) {
+	msg := HighloadV2Message{BoundedQueryID: PackHighloadQueryID(1_000, 42)}
+	tests := []struct {
+		now  uint32
+		d    time.Duration
+		want bool
+	}{
+		{now: 950, d: 30 * time.Second},
+		{now: 950, d: 50 * time.Second},
+		{now: 950, d: 51 * time.Second, want: true},
+		{now: 1_000},
+		{now: 1_001, want: true},
+	}
+	for _, tt := range tests {
+		if got := msg.ExpiresWithin(tt.d, tt.now); got != tt.want {
+			t.Fatalf("ExpiresWithin(%v, %v): want %v, got %v", tt.d, tt.now, tt.want, got)
+		}
+	}
+}
+
+func TestDecodeMessageV4_Revisions(t *testing.T) {
+	const v4 = "te6ccgEBAgEAqgAB4YgA2ZpktQsYby0n9cV5VWOFINBjScIU2HdondFsK3lDpEAAQ+B903cV6YIMdtd4QtdyekehadSk+QjIgoIiRgjZD9v81PVGEXBKHPgPUknVvxvr/LGcKkLNhY+I1Wuwi/7ACU1NGLsi5dhQAAAA8AAcAQBoQgApn5hvK5EKvcI4+qgdz+LABkbBy/PLofvLWI8wTW1zT6WWgvAAAAAAAAAAAAAAAAAAAA=="
+	r1, err := DecodeMessage(V4R1, mustFromHex(v4))
+	if err != nil {
+		t.Fatalf("DecodeMessage(V4R1) failed: %v", err)
+	}
+	r2, err := DecodeMessage(V4R2, mustFromHex(v4))
+	if err != nil {
+		t.Fatalf("DecodeMessage(V4R2) failed: %v", err)
+	}
+	r1.Version = r2.Version
+	if !reflect.DeepEqual(r1, r2) {
+		t.Fatalf("V4R1 and V4R2 messages must be decoded the same way")
+	}
+}
+
+func TestPayloadV1toV4_UnmarshalTLB(t *testing.T) {
+	c := boc.NewCell()
+	for i := 0; i < 4; i++ {
+		if err := c.WriteUint(3, 8); err != nil {
+			t.Fatalf("WriteUint() failed: %v", err)
+		}
+		if err := c.AddRef(boc.NewCell()); err != nil {
+			t.Fatalf("AddRef() failed: %v", err)
+		}
+	}
+	var payload PayloadV1toV4
+	if err := tlb.Unmarshal(c, &payload); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	if len(payload) != 4 {
+		t.Fatalf("want 4 messages, got %v", len(payload))
+	}
+	c.ResetCounters()
+	if err := tlb.Unmarshal(c, &payload); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	if len(payload) != 4 {
+		t.Fatalf("decoding into a used payload must replace it, got %v messages", len(payload))
+	}
+
+	highload := mustFromHex("te6ccgECCQEAAUMAAUWIAbeTPaOhIeFpX00pVBankGP2F/kaObq5EAdGLvI+omE+DAEBmXzKceTPz+weyz8nYZbOkpsBYbvy6gN7h38ZVL6RTqln7XbUzHkQqxRp1B1ZYkBgMW1NtE7r8Jwg26HcS3qPiwYAAYiUZMJyTpfTrVXAAgIFngACAwQBAwDgBQEDAOAHAWJCADZmmS1CxhvLSf1xXlVY4Ug0GNJwhTYd2id0WwreUOkQCKAAAAAAAAAAAAAAAAABBgBQAAAAADcwMzBhYzQ2LWI5NWMtNDRjNy04ZDdiLTYxMjMyNmU2ZTUxMgFiQgA2ZpktQsYby0n9cV5VWOFINBjScIU2HdondFsK3lDpEAlAAAAAAAAAAAAAAAAAAQgAUAAAAAAzYjA2OTU1YS03YjRjLTQ1YWEtOTVlNy0wNTI4ZWZhYjAyM2E=")
+	if _, err := DecodeMessageV3(highload); err == nil {
+		t.Fatalf("highload message must not be decoded as v3")
+	}
+}
+
+func TestExtractRawMessages_Empty(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	hdr := MessageHeader{Seqno: 3, ValidUntil: 1_700_000_000}
+	for _, ver := range []Version{V3R2, V4R2, HighLoadV2R2, V5R1} {
+		t.Run(ver.ToString(), func(t *testing.T) {
+			cell, err := AssembleExternalMessage(ver, privateKey, hdr, nil)
+			if err != nil {
+				t.Fatalf("AssembleExternalMessage() failed: %v", err)
+			}
+			msgs, err := ExtractRawMessages(ver, cell)
+			if err != nil {
+				t.Fatalf("ExtractRawMessages() failed: %v", err)
+			}
+			if msgs == nil || len(msgs) != 0 {
+				t.Fatalf("want an empty list of messages, got %#v", msgs)
+			}
+		})
+	}
+}
+
+func TestVerifyDetached_PrunedBranch(t *testing.T) {
+	// a cell with a ref replaced by a pruned branch, signed before pruning by a key with a zero seed.
+	cells, err := boc.DeserializeBocHex("b5ee9c7201010201002d002108123456780128480101270906fd171b9c43f37a353059a73fbc02e0568188ec30186af846caefd09b8c0000")
+	if err != nil {
+		t.Fatalf("DeserializeBocHex() failed: %v", err)
+	}
+	body := cells[0]
+	var sig tlb.Bits512
+	copy(sig[:], mustHex(t, "706c4cffb3980f237e31173237b370fc19e02977fc436aa2602641906fb963ff723a394a9360296085eedbd5fb3658d69416a08fa09596030c407d20b89d6c0f"))
+	publicKey := mustPubkeyFromHex("3b6a27bcceb6a42d62a3a8d02a6f0d73653215771de243a63ac048a18b59da29")
+	if err := VerifyDetached(body, sig, publicKey); err != nil {
+		t.Fatalf("VerifyDetached() failed: %v", err)
+	}
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("DecodeString() failed: %v", err)
+	}
+	return b
+}
+
+func TestPayloadHighload_MarshalTLB_Keys(t *testing.T) {
+	// a payload of a real highload wallet v2 message.
+	cell := mustFromHex("te6ccgECCQEAAUMAAUWIAbeTPaOhIeFpX00pVBankGP2F/kaObq5EAdGLvI+omE+DAEBmXzKceTPz+weyz8nYZbOkpsBYbvy6gN7h38ZVL6RTqln7XbUzHkQqxRp1B1ZYkBgMW1NtE7r8Jwg26HcS3qPiwYAAYiUZMJyTpfTrVXAAgIFngACAwQBAwDgBQEDAOAHAWJCADZmmS1CxhvLSf1xXlVY4Ug0GNJwhTYd2id0WwreUOkQCKAAAAAAAAAAAAAAAAABBgBQAAAAADcwMzBhYzQ2LWI5NWMtNDRjNy04ZDdiLTYxMjMyNmU2ZTUxMgFiQgA2ZpktQsYby0n9cV5VWOFINBjScIU2HdondFsK3lDpEAlAAAAAAAAAAAAAAAAAAQgAUAAAAAAzYjA2OTU1YS03YjRjLTQ1YWEtOTVlNy0wNTI4ZWZhYjAyM2E=")
+	body, err := extractSignedMsgBody(cell)
+	if err != nil {
+		t.Fatalf("extractSignedMsgBody() failed: %v", err)
+	}
+	payload := boc.Cell(body.Message)
+	wantHash, err := payload.HashString()
+	if err != nil {
+		t.Fatalf("HashString() failed: %v", err)
+	}
+	var msg HighloadV2Message
+	if err := tlb.Unmarshal(&payload, &msg); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	encoded := boc.NewCell()
+	if err := tlb.Marshal(encoded, msg); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	gotHash, err := encoded.HashString()
+	if err != nil {
+		t.Fatalf("HashString() failed: %v", err)
+	}
+	if gotHash != wantHash {
+		t.Fatalf("re-encoded payload differs from the reference one: want %v, got %v", wantHash, gotHash)
+	}
+
+	// the contract reads keys as signed 16-bit integers.
+	encoded.ResetCounters()
+	var header struct {
+		SubWalletId    uint32
+		BoundedQueryID uint64
+		Dict           tlb.HashmapE[tlb.Int16, tlb.Any]
+	}
+	if err := tlb.Unmarshal(encoded, &header); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	keys := header.Dict.Keys()
+	if len(keys) != 2 || keys[0] != 0 || keys[1] != 1 {
+		t.Fatalf("want keys [0 1], got %v", keys)
+	}
+}
+
+func TestMergePayloadsV1toV4(t *testing.T) {
+	newPayload := func(n int) PayloadV1toV4 {
+		p := make(PayloadV1toV4, 0, n)
+		for i := 0; i < n; i++ {
+			p = append(p, RawMessage{Message: boc.NewCell(), Mode: 3})
+		}
+		return p
+	}
+	tests := []struct {
+		name     string
+		payloads []PayloadV1toV4
+		want     []int
+	}{
+		{name: "no payloads", want: []int{}},
+		{name: "empty payloads", payloads: []PayloadV1toV4{{}, {}}, want: []int{}},
+		{name: "exactly 8 messages", payloads: []PayloadV1toV4{newPayload(3), newPayload(4), newPayload(1)}, want: []int{4, 4}},
+		{name: "9 messages", payloads: []PayloadV1toV4{newPayload(2), newPayload(4), newPayload(3)}, want: []int{4, 4, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := MergePayloadsV1toV4(tt.payloads...)
+			if err != nil {
+				t.Fatalf("MergePayloadsV1toV4() failed: %v", err)
+			}
+			sizes := make([]int, 0, len(merged))
+			for _, p := range merged {
+				sizes = append(sizes, len(p))
+			}
+			if !reflect.DeepEqual(sizes, tt.want) {
+				t.Fatalf("want payload sizes %v, got %v", tt.want, sizes)
+			}
+		})
+	}
+	if _, err := MergePayloadsV1toV4(PayloadV1toV4{{Mode: 3}}); err == nil {
+		t.Fatalf("MergePayloadsV1toV4() had to fail but it didn't")
+	}
+}
+
+func TestDecodeMessageV5_Extension(t *testing.T) {
+	transfer := mustRawMessage(t, Message{Amount: 1_000_000, Mode: 3})
+	actions := boc.NewCell()
+	list := SendMessageList{Actions: []SendMessageAction{{Mode: transfer.Mode, Msg: transfer.Message}}}
+	if err := tlb.Marshal(actions, list); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	body := boc.NewCell()
+	if err := body.WriteUint(0x6578746e, 32); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	if err := body.WriteUint(42, 64); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	if err := body.WriteBit(false); err != nil {
+		t.Fatalf("WriteBit() failed: %v", err)
+	}
+	if err := body.AddRef(actions); err != nil {
+		t.Fatalf("AddRef() failed: %v", err)
+	}
+	internal := mustRawMessage(t, Message{Amount: 50_000_000, Body: body, Mode: 3}).Message
+
+	msg, err := DecodeMessageV5(internal)
+	if err != nil {
+		t.Fatalf("DecodeMessageV5() failed: %v", err)
+	}
+	if msg.SumType != "Extn" {
+		t.Fatalf("want Extn, got %v", msg.SumType)
+	}
+	if msg.Extn.QueryID != 42 {
+		t.Fatalf("want query id 42, got %v", msg.Extn.QueryID)
+	}
+	if _, ok := msg.MessageSeqno(); ok {
+		t.Fatalf("extension request must have no seqno")
+	}
+	msgs := msg.RawMessages()
+	if len(msgs) != 1 {
+		t.Fatalf("want 1 message, got %v", len(msgs))
+	}
+	got, err := msgs[0].Message.HashString()
+	if err != nil {
+		t.Fatalf("HashString() failed: %v", err)
+	}
+	want, err := transfer.Message.HashString()
+	if err != nil {
+		t.Fatalf("HashString() failed: %v", err)
+	}
+	if got != want || msgs[0].Mode != 3 {
+		t.Fatalf("unexpected message: mode %v, hash %v", msgs[0].Mode, got)
+	}
+}
+
+func TestMessageV4_PluginStateInit(t *testing.T) {
+	code := boc.NewCell()
+	if err := code.WriteUint(0xdeadbeef, 32); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	stateInit := tlb.StateInit{
+		Code: tlb.Maybe[tlb.Ref[boc.Cell]]{Exists: true, Value: tlb.Ref[boc.Cell]{Value: *code}},
+	}
+	stateInitCell := boc.NewCell()
+	if err := tlb.Marshal(stateInitCell, stateInit); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	body := boc.NewCell()
+	for _, f := range []struct {
+		value uint64
+		bits  int
+	}{{698983191, 32}, {1_700_000_000, 32}, {3, 32}, {1, 8}, {0xff, 8}} {
+		if err := body.WriteUint(f.value, f.bits); err != nil {
+			t.Fatalf("WriteUint() failed: %v", err)
+		}
+	}
+	// the plugin is deployed to the masterchain with a zero balance.
+	if err := tlb.Marshal(body, tlb.Grams(0)); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	if err := body.AddRef(stateInitCell); err != nil {
+		t.Fatalf("AddRef() failed: %v", err)
+	}
+	if err := body.AddRef(boc.NewCell()); err != nil {
+		t.Fatalf("AddRef() failed: %v", err)
+	}
+	var msg MessageV4
+	if err := tlb.Unmarshal(body, &msg); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	if body.BitsAvailableForRead() != 0 || body.RefsAvailableForRead() != 0 {
+		t.Fatalf("body must be read completely")
+	}
+	deployment := msg.PluginDeployment
+	if deployment == nil || deployment.Workchain != -1 || deployment.Balance != 0 || len(msg.RawMessages) != 0 {
+		t.Fatalf("unexpected plugin deployment: %+v, messages: %v", deployment, msg.RawMessages)
+	}
+	reencoded := boc.NewCell()
+	if err := tlb.Marshal(reencoded, msg); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	reencodedHash, err := reencoded.HashString()
+	if err != nil {
+		t.Fatalf("HashString() failed: %v", err)
+	}
+	bodyHash, err := body.HashString()
+	if err != nil {
+		t.Fatalf("HashString() failed: %v", err)
+	}
+	if reencodedHash != bodyHash {
+		t.Fatalf("want re-encoded hash %v, got %v", bodyHash, reencodedHash)
+	}
+	got, err := msg.PluginStateInit()
+	if err != nil {
+		t.Fatalf("PluginStateInit() failed: %v", err)
+	}
+	if !got.Code.Exists {
+		t.Fatalf("plugin state init must have code")
+	}
+	gotCode := got.Code.Value.Value
+	gotHash, err := gotCode.HashString()
+	if err != nil {
+		t.Fatalf("HashString() failed: %v", err)
+	}
+	wantHash, err := code.HashString()
+	if err != nil {
+		t.Fatalf("HashString() failed: %v", err)
+	}
+	if gotHash != wantHash {
+		t.Fatalf("want code hash %v, got %v", wantHash, gotHash)
+	}
+	msg.Op = 0
+	if _, err := msg.PluginStateInit(); err == nil {
+		t.Fatalf("PluginStateInit() must fail for a simple send")
+	}
+}
+
+func TestValidateValidUntil(t *testing.T) {
+	const now = 1_700_000_000
+	tests := []struct {
+		name       string
+		validUntil uint32
+		maxHorizon uint32
+		want       error
+	}{
+		{name: "valid", validUntil: now + 60, maxHorizon: 600},
+		{name: "exactly at horizon", validUntil: now + 600, maxHorizon: 600},
+		{name: "expired", validUntil: now, maxHorizon: 600, want: ErrMessageExpired},
+		{name: "too far", validUntil: now + 601, maxHorizon: 600, want: ErrValidUntilTooFar},
+		{name: "year 2100", validUntil: 4_102_444_800, maxHorizon: 600, want: ErrValidUntilTooFar},
+		{name: "no expiry", validUntil: noExpiry, maxHorizon: 600, want: ErrValidUntilTooFar},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateValidUntil(tt.validUntil, now, tt.maxHorizon); err != tt.want {
+				t.Fatalf("want %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+// linkedPayloadDecoder decodes a payload of a wallet which stores messages in a linked list:
+// node$_ mode:uint8 message:^Cell next:(Maybe ^node) = Node.
+type linkedPayloadDecoder struct{}
+
+func (linkedPayloadDecoder) DecodePayload(c *boc.Cell) ([]RawMessage, error) {
+	var msgs []RawMessage
+	node, err := c.NextRef()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		mode, err := node.ReadUint(8)
+		if err != nil {
+			return nil, err
+		}
+		msg, err := node.NextRef()
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, RawMessage{Message: msg, Mode: byte(mode)})
+		hasNext, err := node.ReadBit()
+		if err != nil {
+			return nil, err
+		}
+		if !hasNext {
+			return msgs, nil
+		}
+		if node, err = node.NextRef(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func TestDecodeMessageV4_WithPayloadDecoder(t *testing.T) {
+	var next *boc.Cell
+	for i := 5; i >= 1; i-- {
+		node := boc.NewCell()
+		if err := node.WriteUint(uint64(i), 8); err != nil {
+			t.Fatalf("WriteUint() failed: %v", err)
+		}
+		if err := node.AddRef(mustRawMessage(t, Message{Amount: tlb.Grams(i), Mode: 3}).Message); err != nil {
+			t.Fatalf("AddRef() failed: %v", err)
+		}
+		if err := node.WriteBit(next != nil); err != nil {
+			t.Fatalf("WriteBit() failed: %v", err)
+		}
+		if next != nil {
+			if err := node.AddRef(next); err != nil {
+				t.Fatalf("AddRef() failed: %v", err)
+			}
+		}
+		next = node
+	}
+	body := boc.NewCell()
+	for _, f := range []struct {
+		value uint64
+		bits  int
+	}{{DefaultSubWallet, 32}, {1_700_000_000, 32}, {9, 32}, {0, 8}} {
+		if err := body.WriteUint(f.value, f.bits); err != nil {
+			t.Fatalf("WriteUint() failed: %v", err)
+		}
+	}
+	if err := body.AddRef(next); err != nil {
+		t.Fatalf("AddRef() failed: %v", err)
+	}
+	signedBody := boc.NewCell()
+	if err := tlb.Marshal(signedBody, SignedMsgBody{Message: tlb.Any(*body)}); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	extMsg, err := ton.CreateExternalMessage(ton.AccountID{}, signedBody, nil, 0)
+	if err != nil {
+		t.Fatalf("CreateExternalMessage() failed: %v", err)
+	}
+	extMsgCell := boc.NewCell()
+	if err := tlb.Marshal(extMsgCell, extMsg); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	msg, err := DecodeMessageV4(extMsgCell, WithPayloadDecoder(linkedPayloadDecoder{}))
+	if err != nil {
+		t.Fatalf("DecodeMessageV4() failed: %v", err)
+	}
+	if msg.SubWalletId != DefaultSubWallet || msg.ValidUntil != 1_700_000_000 || msg.Seqno != 9 || msg.Op != 0 {
+		t.Fatalf("unexpected header: %+v", msg)
+	}
+	if len(msg.RawMessages) != 5 {
+		t.Fatalf("want 5 messages, got %v", len(msg.RawMessages))
+	}
+	for i, m := range msg.RawMessages {
+		if m.Mode != byte(i+1) {
+			t.Fatalf("message %v: want mode %v, got %v", i, i+1, m.Mode)
+		}
+	}
+	extMsgCell.ResetCounters()
+	if _, err := DecodeMessageV4(extMsgCell, WithPayloadDecoder(linkedPayloadDecoder{}), WithMaxTotalBytes(10)); err != ErrTooLarge {
+		t.Fatalf("want ErrTooLarge, got %v", err)
+	}
+}
+
+func TestHashStable(t *testing.T) {
+	tests := []struct {
+		name string
+		ver  Version
+		boc  string
+	}{
+		{
+			name: "v4",
+			ver:  V4R2,
+			boc:  "te6ccgEBAgEAqgAB4YgA2ZpktQsYby0n9cV5VWOFINBjScIU2HdondFsK3lDpEAAQ+B903cV6YIMdtd4QtdyekehadSk+QjIgoIiRgjZD9v81PVGEXBKHPgPUknVvxvr/LGcKkLNhY+I1Wuwi/7ACU1NGLsi5dhQAAAA8AAcAQBoQgApn5hvK5EKvcI4+qgdz+LABkbBy/PLofvLWI8wTW1zT6WWgvAAAAAAAAAAAAAAAAAAAA==",
+		},
+		{
+			name: "v5",
+			ver:  V5R1,
+			boc:  "te6ccgECCAEAAZ4AAfGIAehvqHPiQ2Ru+zkowjJx/7oJbqEYRnlCOuPe5+2gm24WA5tLO3f////oAAAAAAADMYd8kAAAAAEHzN670eqqNU3yWGkX1dOynyAbT7DN4cFDpE0r+nInTomGrifjPTaZvG3YxYzTHpLoNesGc9s5Q0tHlLNcFNQeAQIKDsPIbQMCAwIKDsPIbQMEBQCpaAHob6hz4kNkbvs5KMIycf+6CW6hGEZ5Qjrj3uftoJtuFwAbM0yWoWMN5aT+uK8qrHCkGgxpOEKbDu0Tui2Fbyh0iAy3GwAAAAAAAAAAAAAAAAAAQAIKDsPIbQMGBwCpaAHob6hz4kNkbvs5KMIycf+6CW6hGEZ5Qjrj3uftoJtuFwAbM0yWoWMN5aT+uK8qrHCkGgxpOEKbDu0Tui2Fbyh0iAx6EgAAAAAAAAAAAAAAAAAAQAAAAKloAehvqHPiQ2Ru+zkowjJx/7oJbqEYRnlCOuPe5+2gm24XABszTJahYw3lpP64ryqscKQaDGk4QpsO7RO6LYVvKHSIDD0JAAAAAAAAAAAAAAAAAABA",
+		},
+		{
+			name: "highload",
+			ver:  HighLoadV2R2,
+			boc:  "te6ccgECCQEAAUMAAUWIAbeTPaOhIeFpX00pVBankGP2F/kaObq5EAdGLvI+omE+DAEBmXzKceTPz+weyz8nYZbOkpsBYbvy6gN7h38ZVL6RTqln7XbUzHkQqxRp1B1ZYkBgMW1NtE7r8Jwg26HcS3qPiwYAAYiUZMJyTpfTrVXAAgIFngACAwQBAwDgBQEDAOAHAWJCADZmmS1CxhvLSf1xXlVY4Ug0GNJwhTYd2id0WwreUOkQCKAAAAAAAAAAAAAAAAABBgBQAAAAADcwMzBhYzQ2LWI5NWMtNDRjNy04ZDdiLTYxMjMyNmU2ZTUxMgFiQgA2ZpktQsYby0n9cV5VWOFINBjScIU2HdondFsK3lDpEAlAAAAAAAAAAAAAAAAAAQgAUAAAAAAzYjA2OTU1YS03YjRjLTQ1YWEtOTVlNy0wNTI4ZWZhYjAyM2E=",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original, reencoded, equal, err := HashStable(tt.ver, mustFromHex(tt.boc))
+			if err != nil {
+				t.Fatalf("HashStable() failed: %v", err)
+			}
+			if !equal || original != reencoded {
+				t.Fatalf("hash changed after re-encoding: %x != %x", original, reencoded)
+			}
+		})
+	}
+	if _, _, _, err := HashStable(V1R1, mustFromHex(tests[0].boc)); err == nil {
+		t.Fatalf("HashStable() must fail for an unsupported version")
+	}
+}
+
+func TestDecodeMessage_EmptyBody(t *testing.T) {
+	extMsg, err := ton.CreateExternalMessage(ton.AccountID{}, boc.NewCell(), nil, 0)
+	if err != nil {
+		t.Fatalf("CreateExternalMessage() failed: %v", err)
+	}
+	cell := boc.NewCell()
+	if err := tlb.Marshal(cell, extMsg); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	for _, ver := range []Version{V3R2, V4R2, HighLoadV2R2, V5R1} {
+		t.Run(ver.ToString(), func(t *testing.T) {
+			cell.ResetCounters()
+			if _, err := DecodeMessage(ver, cell); err != ErrEmptyBody {
+				t.Fatalf("want ErrEmptyBody, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDecodeV5Instructions(t *testing.T) {
+	transfer := mustRawMessage(t, Message{Amount: 1_000_000, Mode: 3})
+	var send V5Instruction
+	send.SumType = "SendMsg"
+	send.SendMsg.Mode = transfer.Mode
+	send.SendMsg.Msg = transfer.Message
+	var reserve V5Instruction
+	reserve.SumType = "ReserveCurrency"
+	reserve.ReserveCurrency.Mode = 2
+	reserve.ReserveCurrency.Currency.Grams = 500
+	// the root of an out list holds the last action.
+	list := boc.NewCell()
+	for _, instruction := range []V5Instruction{send, reserve} {
+		node := boc.NewCell()
+		if err := node.AddRef(list); err != nil {
+			t.Fatalf("AddRef() failed: %v", err)
+		}
+		if err := tlb.Marshal(node, instruction); err != nil {
+			t.Fatalf("Marshal() failed: %v", err)
+		}
+		list = node
+	}
+	list.ResetCounters()
+	instructions, err := DecodeV5Instructions(list)
+	if err != nil {
+		t.Fatalf("DecodeV5Instructions() failed: %v", err)
+	}
+	if len(instructions) != 2 || instructions[0].SumType != "SendMsg" || instructions[1].SumType != "ReserveCurrency" {
+		t.Fatalf("unexpected instructions: %v", instructions)
+	}
+	if instructions[0].SendMsg.Mode != 3 || instructions[1].ReserveCurrency.Currency.Grams != 500 {
+		t.Fatalf("unexpected instructions: %v", instructions)
+	}
+
+	var msg MessageV5
+	msg.SumType = "Sign"
+	msg.Sign.Actions = SendMessageList{Actions: []SendMessageAction{{Mode: transfer.Mode, Msg: transfer.Message}}}
+	instructions, err = msg.Instructions()
+	if err != nil {
+		t.Fatalf("Instructions() failed: %v", err)
+	}
+	if len(instructions) != 1 || instructions[0].SumType != "SendMsg" || instructions[0].SendMsg.Msg != transfer.Message {
+		t.Fatalf("unexpected instructions: %v", instructions)
+	}
+
+	// extended actions are executed before the send_msg actions.
+	var addExtension, disableSignature V5ExtendedAction
+	addExtension.SumType = "AddExtension"
+	extension := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	addExtension.AddExtension.Addr = extension.ToMsgAddress()
+	disableSignature.SumType = "SetSignatureAuthAllowed"
+	msg.Sign.Op = true
+	msg.Sign.Actions.Extended = []V5ExtendedAction{addExtension, disableSignature}
+	instructions, err = msg.Instructions()
+	if err != nil {
+		t.Fatalf("Instructions() failed: %v", err)
+	}
+	var kinds []string
+	for _, instruction := range instructions {
+		kinds = append(kinds, string(instruction.SumType))
+	}
+	if !reflect.DeepEqual(kinds, []string{"AddExtension", "SetSignatureAuthAllowed", "SendMsg"}) {
+		t.Fatalf("unexpected instructions: %v", kinds)
+	}
+	if instructions[0].AddExtension.Addr != addExtension.AddExtension.Addr || instructions[1].SetSignatureAuthAllowed.Allowed {
+		t.Fatalf("unexpected instructions: %+v", instructions)
+	}
+
+	// extended actions are never stored in c5.
+	extended := boc.NewCell()
+	if err := extended.AddRef(boc.NewCell()); err != nil {
+		t.Fatalf("AddRef() failed: %v", err)
+	}
+	if err := tlb.Marshal(extended, disableSignature); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	if _, err := DecodeV5Instructions(extended); err == nil {
+		t.Fatalf("DecodeV5Instructions() had to fail for an extended action")
+	}
+}
+
+func TestMessageV5_SignatureAuthChanges(t *testing.T) {
+	extension := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	transfer := mustRawMessage(t, Message{Amount: 1_000, Mode: 3})
+	var addExtension, disableSignature V5ExtendedAction
+	addExtension.SumType = "AddExtension"
+	addExtension.AddExtension.Addr = extension.ToMsgAddress()
+	disableSignature.SumType = "SetSignatureAuthAllowed"
+	actions := SendMessageList{
+		Actions:  []SendMessageAction{{Mode: transfer.Mode, Msg: transfer.Message}},
+		Extended: []V5ExtendedAction{addExtension, disableSignature},
+	}
+	request, err := BuildV5SigningCell(tlb.Bits80{}, 1_700_000_000, 5, true, actions)
+	if err != nil {
+		t.Fatalf("BuildV5SigningCell() failed: %v", err)
+	}
+	if err := request.WriteBytes(make([]byte, 64)); err != nil {
+		t.Fatalf("WriteBytes() failed: %v", err)
+	}
+	var msg MessageV5
+	if err := tlb.Unmarshal(request, &msg); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	if len(msg.Sign.Actions.Extended) != 2 || len(msg.RawMessages()) != 1 || msg.RawMessages()[0].Mode != transfer.Mode {
+		t.Fatalf("unexpected actions: %+v", msg.Sign.Actions)
+	}
+	change, ok := msg.SignatureAuthChanges()
+	if !ok || change.Allowed || change.QueryID != 0 {
+		t.Fatalf("want signature auth disabled, got %+v", change)
+	}
+
+	var extn MessageV5
+	extn.SumType = "Extn"
+	extn.Extn.QueryID = 77
+	extn.Extn.Op = true
+	extn.Extn.Actions = SendMessageList{Extended: []V5ExtendedAction{disableSignature, disableSignature}}
+	extn.Extn.Actions.Extended[1].SetSignatureAuthAllowed.Allowed = true
+	cell := boc.NewCell()
+	if err := tlb.Marshal(cell, extn); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	var decoded MessageV5
+	if err := tlb.Unmarshal(cell, &decoded); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	change, ok = decoded.SignatureAuthChanges()
+	if !ok || !change.Allowed || change.QueryID != 77 {
+		t.Fatalf("want the last change with query id 77, got %+v", change)
+	}
+
+	var basic MessageV5
+	basic.SumType = "Sign"
+	basic.Sign.Actions = SendMessageList{Actions: actions.Actions}
+	if _, ok := basic.SignatureAuthChanges(); ok {
+		t.Fatalf("basic action list doesn't change signature auth")
+	}
+}
+
+func TestBuildHighloadOrdered(t *testing.T) {
+	amounts := []tlb.Grams{100, 300, 200, 300}
+	var msgs []RawMessage
+	for i, amount := range amounts {
+		msgs = append(msgs, mustRawMessage(t, Message{Amount: amount, Mode: byte(i)}))
+	}
+	payload := BuildHighloadOrdered(msgs, func(i, j int) bool {
+		return amounts[i] > amounts[j]
+	})
+	// the sort is stable, so messages with the same amount keep their order.
+	wantModes := []byte{1, 3, 2, 0}
+	var modes []byte
+	for _, msg := range payload {
+		modes = append(modes, msg.Mode)
+	}
+	if !reflect.DeepEqual(modes, wantModes) {
+		t.Fatalf("want modes %v, got %v", wantModes, modes)
+	}
+	for i, msg := range msgs {
+		if msg.Mode != byte(i) {
+			t.Fatalf("msgs must not be modified")
+		}
+	}
+	cell := boc.NewCell()
+	if err := tlb.Marshal(cell, payload); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	var decoded PayloadHighload
+	if err := tlb.Unmarshal(cell, &decoded); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	modes = modes[:0]
+	for _, msg := range decoded {
+		modes = append(modes, msg.Mode)
+	}
+	if !reflect.DeepEqual(modes, wantModes) {
+		t.Fatalf("want modes %v in key order, got %v", wantModes, modes)
+	}
+}
+
+func TestImportFee(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	hdr := MessageHeader{SubWalletId: DefaultSubWallet, ValidUntil: 1_700_000_000, Seqno: 5}
+	msg, err := AssembleExternalMessage(V4R2, privateKey, hdr, nil)
+	if err != nil {
+		t.Fatalf("AssembleExternalMessage() failed: %v", err)
+	}
+	fee, err := ImportFee(msg)
+	if err != nil {
+		t.Fatalf("ImportFee() failed: %v", err)
+	}
+	if fee != 0 {
+		t.Fatalf("want zero import fee, got %v", fee)
+	}
+	msg.ResetCounters()
+	var m tlb.Message
+	if err := tlb.Unmarshal(msg, &m); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	m.Info.ExtInMsgInfo.ImportFee = 1_000_000
+	withFee := boc.NewCell()
+	if err := tlb.Marshal(withFee, m); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	if fee, err = ImportFee(withFee); err != nil || fee != 1_000_000 {
+		t.Fatalf("want import fee 1000000, got %v, %v", fee, err)
+	}
+	internal := mustRawMessage(t, Message{Amount: 1_000, Mode: 3}).Message
+	if _, err := ImportFee(internal); err == nil {
+		t.Fatalf("ImportFee() had to fail for an internal message")
+	}
+}
+
+func TestDecodeMessageV5_Op(t *testing.T) {
+	bocs := []string{
+		"te6ccgECCAEAAZ4AAfGIAehvqHPiQ2Ru+zkowjJx/7oJbqEYRnlCOuPe5+2gm24WA5tLO3f////oAAAAAAADMY8YiAAAADPkc94coPiaMQo1EI1uuJWlVQGxiffff96PyOTGiQhUjkr733UkT8rfdXxuYcb9SMykg8Tlo7LNBB187eI+ymw2AQIKDsPIbQMCAwIKDsPIbQMEBQCpaAHob6hz4kNkbvs5KMIycf+6CW6hGEZ5Qjrj3uftoJtuFwAbM0yWoWMN5aT+uK8qrHCkGgxpOEKbDu0Tui2Fbyh0iAy3GwAAAAAAAAAAAAAAAAAAQAIKDsPIbQMGBwCpaAHob6hz4kNkbvs5KMIycf+6CW6hGEZ5Qjrj3uftoJtuFwAbM0yWoWMN5aT+uK8qrHCkGgxpOEKbDu0Tui2Fbyh0iAx6EgAAAAAAAAAAAAAAAAAAQAAAAKloAehvqHPiQ2Ru+zkowjJx/7oJbqEYRnlCOuPe5+2gm24XABszTJahYw3lpP64ryqscKQaDGk4QpsO7RO6LYVvKHSIDD0JAAAAAAAAAAAAAAAAAABA",
+		"te6ccgECCAEAAZ4AAfGIAVjXuMKpIWGwKJenbsOOEh1AEZo6J5Zu0R8EDI37LVyKA5tLO3f////oAAAAAAADMY9YuAAAAAFs/6Zj178nNgWPsbSM2UaEwrcyYPF0kSqZ4d+fhPMfynWRWKBCiVh2PtDewtHZ5FW1luvfXHDqGX0DtYSHfVwGAQIKDsPIbQMCAwIKDsPIbQMEBQCpaAFY17jCqSFhsCiXp27DjhIdQBGaOieWbtEfBAyN+y1ciwAbM0yWoWMN5aT+uK8qrHCkGgxpOEKbDu0Tui2Fbyh0iAy3GwAAAAAAAAAAAAAAAAAAQAIKDsPIbQMGBwCpaAFY17jCqSFhsCiXp27DjhIdQBGaOieWbtEfBAyN+y1ciwAbM0yWoWMN5aT+uK8qrHCkGgxpOEKbDu0Tui2Fbyh0iAx6EgAAAAAAAAAAAAAAAAAAQAAAAKloAVjXuMKpIWGwKJenbsOOEh1AEZo6J5Zu0R8EDI37LVyLABszTJahYw3lpP64ryqscKQaDGk4QpsO7RO6LYVvKHSIDD0JAAAAAAAAAAAAAAAAAABA",
+	}
+	for _, c := range NewBenchCorpus() {
+		if c.Version == V5R1 {
+			bocs = append(bocs, c.BOC)
+		}
+	}
+	for i, b := range bocs {
+		cell := mustFromHex(b)
+		var m tlb.Message
+		if err := tlb.Unmarshal(cell, &m); err != nil {
+			t.Fatalf("Unmarshal() failed: %v", err)
+		}
+		// op follows the opcode, wallet id, valid until and seqno.
+		body := boc.Cell(m.Body.Value)
+		if _, err := body.ReadBits(32 + 80 + 32 + 32); err != nil {
+			t.Fatalf("ReadBits() failed: %v", err)
+		}
+		wantOp, err := body.ReadBit()
+		if err != nil {
+			t.Fatalf("ReadBit() failed: %v", err)
+		}
+		cell.ResetCounters()
+		msg, err := DecodeMessageV5(cell)
+		if err != nil {
+			t.Fatalf("DecodeMessageV5() failed: %v", err)
+		}
+		if msg.SumType != "Sign" || msg.Sign.Op != wantOp {
+			t.Fatalf("message %v: want Sign with op %v, got %v with op %v", i, wantOp, msg.SumType, msg.Sign.Op)
+		}
+	}
+
+	// the decoded signature of a real message signs the request re-encoded from the decoded op and actions,
+	// so both the op bit and the signature are read from the positions the wallet owner signed.
+	keys := []ed25519.PublicKey{
+		mustPubkeyFromHex("406b63856ff6913fe2170a5c128113c6bd8256438a43340ea3bf6e0bbc56f9ca"),
+		mustPubkeyFromHex("cfa50eeb1c3293c92bd33d5aa672c1717accd8a21b96033debb6d30b5bb230df"),
+	}
+	for i, key := range keys {
+		msg, err := DecodeMessageV5(mustFromHex(bocs[i]))
+		if err != nil {
+			t.Fatalf("DecodeMessageV5() failed: %v", err)
+		}
+		if msg.Sign.Op || len(msg.Sign.Actions.Extended) != 0 || len(msg.RawMessages()) != 3 {
+			t.Fatalf("message %v: want a basic action list with 3 messages, got %+v", i, msg.Sign)
+		}
+		preimage, err := BuildV5SigningCell(msg.Sign.SubWalletId, msg.Sign.ValidUntil, msg.Sign.Seqno, msg.Sign.Op, msg.Sign.Actions)
+		if err != nil {
+			t.Fatalf("BuildV5SigningCell() failed: %v", err)
+		}
+		hash, err := preimage.Hash()
+		if err != nil {
+			t.Fatalf("Hash() failed: %v", err)
+		}
+		if !ed25519.Verify(key, hash, msg.Sign.Signature[:]) {
+			t.Fatalf("message %v: decoded signature doesn't sign the decoded request", i)
+		}
+	}
+
+	// the op bit is the tag of the action list, which follows the seqno.
+	transfer := mustRawMessage(t, Message{Amount: 1_000, Mode: 3})
+	var disableSignature V5ExtendedAction
+	disableSignature.SumType = "SetSignatureAuthAllowed"
+	for _, op := range []bool{false, true} {
+		actions := SendMessageList{Actions: []SendMessageAction{{Mode: transfer.Mode, Msg: transfer.Message}}}
+		if op {
+			actions.Extended = []V5ExtendedAction{disableSignature}
+		}
+		request, err := BuildV5SigningCell(tlb.Bits80{}, 1_700_000_000, 5, op, actions)
+		if err != nil {
+			t.Fatalf("BuildV5SigningCell() failed: %v", err)
+		}
+		if err := request.WriteBytes(make([]byte, 64)); err != nil {
+			t.Fatalf("WriteBytes() failed: %v", err)
+		}
+		var msg MessageV5
+		if err := tlb.Unmarshal(request, &msg); err != nil {
+			t.Fatalf("Unmarshal() failed: %v", err)
+		}
+		if msg.Sign.Op != op || msg.Sign.Seqno != 5 || msg.Sign.Signature != (tlb.Bits512{}) {
+			t.Fatalf("want op %v, got %+v", op, msg.Sign)
+		}
+	}
+}
+
+func TestDecodeMessageV5_ExtendedActionList(t *testing.T) {
+	extension := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	transfer := mustRawMessage(t, Message{Amount: 1_000, Mode: 3})
+	write := func(c *boc.Cell, val uint64, bits int) {
+		if err := c.WriteUint(val, bits); err != nil {
+			t.Fatalf("WriteUint() failed: %v", err)
+		}
+	}
+	addRef := func(c, ref *boc.Cell) {
+		if err := c.AddRef(ref); err != nil {
+			t.Fatalf("AddRef() failed: %v", err)
+		}
+	}
+	// out_list$_ prev:^(OutList 0) action:(action_send_msg#0ec3c86d mode:3 out_msg:^transfer)
+	outList := boc.NewCell()
+	addRef(outList, boc.NewCell())
+	write(outList, 0x0ec3c86d, 32)
+	write(outList, 3, 8)
+	addRef(outList, transfer.Message)
+	// action_list_basic$0 actions:^outList
+	basic := boc.NewCell()
+	write(basic, 0, 1)
+	addRef(basic, outList)
+	// action_list_extended$1 prev:^basic action:(action_add_ext#1c40db9f addr:addr_std$10 anycast:0 0:extension)
+	addExtension := boc.NewCell()
+	write(addExtension, 1, 1)
+	write(addExtension, 0x1c40db9f, 32)
+	write(addExtension, 0b100, 3)
+	write(addExtension, 0, 8)
+	if err := addExtension.WriteBytes(extension.Address[:]); err != nil {
+		t.Fatalf("WriteBytes() failed: %v", err)
+	}
+	addRef(addExtension, basic)
+	// external_signed#7369676e wallet_id:0 valid_until:1700000000 msg_seqno:5
+	// inner:(action_list_extended$1 prev:^addExtension action:(action_set_signature_auth_allowed#20cbb95a allowed:0))
+	request := boc.NewCell()
+	write(request, 0x7369676e, 32)
+	write(request, 0, 80)
+	write(request, 1_700_000_000, 32)
+	write(request, 5, 32)
+	write(request, 1, 1)
+	write(request, 0x20cbb95a, 32)
+	write(request, 0, 1)
+	addRef(request, addExtension)
+	signature := bytes.Repeat([]byte{0xaa}, 64)
+	if err := request.WriteBytes(signature); err != nil {
+		t.Fatalf("WriteBytes() failed: %v", err)
+	}
+
+	var msg MessageV5
+	if err := tlb.Unmarshal(request, &msg); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	if msg.SumType != "Sign" || !msg.Sign.Op || msg.Sign.Seqno != 5 || !bytes.Equal(msg.Sign.Signature[:], signature) {
+		t.Fatalf("unexpected request: %+v", msg.Sign)
+	}
+	extended := msg.Sign.Actions.Extended
+	if len(extended) != 2 || extended[0].SumType != "SetSignatureAuthAllowed" || extended[0].SetSignatureAuthAllowed.Allowed {
+		t.Fatalf("want set_signature_auth_allowed first, got %+v", extended)
+	}
+	if extended[1].SumType != "AddExtension" || extended[1].AddExtension.Addr != extension.ToMsgAddress() {
+		t.Fatalf("want add_ext second, got %+v", extended)
+	}
+	msgs := msg.RawMessages()
+	if len(msgs) != 1 || msgs[0].Mode != 3 {
+		t.Fatalf("unexpected messages: %v", msgs)
+	}
+	if got, _ := msgs[0].Message.HashString(); got != mustHashString(t, transfer.Message) {
+		t.Fatalf("unexpected message hash: %v", got)
+	}
+
+	encoded := boc.NewCell()
+	if err := tlb.Marshal(encoded, msg); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	wantHash := mustHashString(t, request)
+	if hash, err := encoded.HashString(); err != nil || hash != wantHash {
+		t.Fatalf("re-encoded request differs from the original one")
+	}
+	signing, err := BuildV5SigningCell(tlb.Bits80{}, 1_700_000_000, 5, true, msg.Sign.Actions)
+	if err != nil {
+		t.Fatalf("BuildV5SigningCell() failed: %v", err)
+	}
+	if err := signing.WriteBytes(signature); err != nil {
+		t.Fatalf("WriteBytes() failed: %v", err)
+	}
+	if hash, err := signing.HashString(); err != nil || hash != wantHash {
+		t.Fatalf("signing cell differs from the original request")
+	}
+	if _, err := BuildV5SigningCell(tlb.Bits80{}, 1_700_000_000, 5, false, msg.Sign.Actions); err == nil {
+		t.Fatalf("BuildV5SigningCell() had to fail for extended actions without op")
+	}
+}
+
+func mustHashString(t *testing.T, c *boc.Cell) string {
+	hash, err := c.HashString()
+	if err != nil {
+		t.Fatalf("HashString() failed: %v", err)
+	}
+	return hash
+}
+
+func TestDecodeMessageV5_NoActions(t *testing.T) {
+	// a request without actions still refers to the action list, which is an empty cell.
+	request, err := BuildV5SigningCell(tlb.Bits80{}, 1_700_000_000, 5, false, SendMessageList{})
+	if err != nil {
+		t.Fatalf("BuildV5SigningCell() failed: %v", err)
+	}
+	if request.RefsSize() != 1 {
+		t.Fatalf("want a ref to an empty action list, got %v refs", request.RefsSize())
+	}
+	if err := request.WriteBytes(make([]byte, 64)); err != nil {
+		t.Fatalf("WriteBytes() failed: %v", err)
+	}
+	var msg MessageV5
+	if err := tlb.Unmarshal(request, &msg); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	if len(msg.RawMessages()) != 0 {
+		t.Fatalf("want no messages, got %v", len(msg.RawMessages()))
+	}
+
+	// a request of an extension that only changes extensions omits the ref to the out list.
+	tail := boc.NewCell()
+	if err := tail.WriteBit(false); err != nil {
+		t.Fatalf("WriteBit() failed: %v", err)
+	}
+	extn := boc.NewCell()
+	for _, field := range []struct {
+		val  uint64
+		bits int
+	}{{0x6578746e, 32}, {77, 64}, {1, 1}, {0x20cbb95a, 32}, {1, 1}} {
+		if err := extn.WriteUint(field.val, field.bits); err != nil {
+			t.Fatalf("WriteUint() failed: %v", err)
+		}
+	}
+	if err := extn.AddRef(tail); err != nil {
+		t.Fatalf("AddRef() failed: %v", err)
+	}
+	msg = MessageV5{}
+	if err := tlb.Unmarshal(extn, &msg); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	change, ok := msg.SignatureAuthChanges()
+	if !ok || !change.Allowed || change.QueryID != 77 || len(msg.RawMessages()) != 0 {
+		t.Fatalf("want signature auth enabled and no messages, got %+v, %v", change, len(msg.RawMessages()))
+	}
+
+	// a request without any actions has no refs at all.
+	empty := boc.NewCell()
+	if err := empty.WriteUint(0x6578746e, 32); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	if err := empty.WriteUint(78, 64); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	if err := empty.WriteBit(false); err != nil {
+		t.Fatalf("WriteBit() failed: %v", err)
+	}
+	if versions := ProbeVersion(empty); len(versions) != 1 || versions[0] != V5R1 {
+		t.Fatalf("want v5 layout, got %v", versions)
+	}
+	msg = MessageV5{}
+	if err := tlb.Unmarshal(empty, &msg); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	if msg.SumType != "Extn" || msg.Extn.QueryID != 78 || msg.Extn.Op || len(msg.RawMessages()) != 0 {
+		t.Fatalf("unexpected request: %+v", msg.Extn)
+	}
+}
+
+func TestDecodeMessageStrict(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	msgs := []RawMessage{mustRawMessage(t, Message{Amount: 1_000, Mode: 3})}
+	hdr := MessageHeader{
+		SubWalletId:    DefaultSubWallet,
+		WalletID:       [10]byte{0xff, 0xff, 0xff, 0x11},
+		ValidUntil:     1_700_000_000,
+		Seqno:          5,
+		BoundedQueryID: 1_700_000_000 << 32,
+	}
+	decoders := map[Version]struct {
+		lenient func(*boc.Cell) error
+		strict  func(*boc.Cell) error
+	}{
+		V3R2: {
+			lenient: func(c *boc.Cell) error { _, err := DecodeMessageV3(c); return err },
+			strict:  func(c *boc.Cell) error { _, err := DecodeMessageV3Strict(c); return err },
+		},
+		V4R2: {
+			lenient: func(c *boc.Cell) error { _, err := DecodeMessageV4(c); return err },
+			strict:  func(c *boc.Cell) error { _, err := DecodeMessageV4Strict(c); return err },
+		},
+		HighLoadV2R2: {
+			lenient: func(c *boc.Cell) error { _, err := DecodeHighloadV2Message(c); return err },
+			strict:  func(c *boc.Cell) error { _, err := DecodeHighloadV2MessageStrict(c); return err },
+		},
+		V5R1: {
+			lenient: func(c *boc.Cell) error { _, err := DecodeMessageV5(c); return err },
+			strict:  func(c *boc.Cell) error { _, err := DecodeMessageV5Strict(c); return err },
+		},
+	}
+	for ver, decoder := range decoders {
+		t.Run(ver.ToString(), func(t *testing.T) {
+			msg, err := AssembleExternalMessage(ver, privateKey, hdr, msgs)
+			if err != nil {
+				t.Fatalf("AssembleExternalMessage() failed: %v", err)
+			}
+			if err := decoder.strict(msg); err != nil {
+				t.Fatalf("strict decoding failed: %v", err)
+			}
+			msg.ResetCounters()
+			var m tlb.Message
+			if err := tlb.Unmarshal(msg, &m); err != nil {
+				t.Fatalf("Unmarshal() failed: %v", err)
+			}
+			body := boc.Cell(m.Body.Value)
+			paddedBody := boc.NewCell()
+			if err := paddedBody.WriteBitString(body.ReadRemainingBits()); err != nil {
+				t.Fatalf("WriteBitString() failed: %v", err)
+			}
+			if err := paddedBody.WriteBit(true); err != nil {
+				t.Fatalf("WriteBit() failed: %v", err)
+			}
+			for _, ref := range body.Refs() {
+				if err := paddedBody.AddRef(ref); err != nil {
+					t.Fatalf("AddRef() failed: %v", err)
+				}
+			}
+			m.Body.IsRight = true
+			m.Body.Value = tlb.Any(*paddedBody)
+			padded := boc.NewCell()
+			if err := tlb.Marshal(padded, m); err != nil {
+				t.Fatalf("Marshal() failed: %v", err)
+			}
+			if err := decoder.lenient(padded); err != nil {
+				t.Fatalf("lenient decoding failed: %v", err)
+			}
+			padded.ResetCounters()
+			if err := decoder.strict(padded); !errors.Is(err, ErrTrailingData) {
+				t.Fatalf("want ErrTrailingData, got %v", err)
+			}
+		})
+	}
+}
+
+func TestExtractRawMessages_V5WalletIDInError(t *testing.T) {
+	var setCode V5Instruction
+	setCode.SumType = "SetCode"
+	setCode.SetCode.NewCode = boc.NewCell()
+	list := boc.NewCell()
+	if err := list.AddRef(boc.NewCell()); err != nil {
+		t.Fatalf("AddRef() failed: %v", err)
+	}
+	if err := tlb.Marshal(list, setCode); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	request := struct {
+		Magic      tlb.Magic `tlb:"#7369676e"`
+		WalletID   tlb.Bits80
+		ValidUntil uint32
+		Seqno      uint32
+		Op         bool
+		Signature  tlb.Bits512
+		Actions    *boc.Cell `tlb:"^"`
+	}{
+		WalletID: tlb.Bits80{0xff, 0xff, 0xff, 0x11, 0xff, 0, 0, 0, 0, 7},
+		Actions:  list,
+	}
+	body := boc.NewCell()
+	if err := tlb.Marshal(body, request); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	dest := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	var m tlb.Message
+	m.Info.SumType = "ExtInMsgInfo"
+	m.Info.ExtInMsgInfo = &struct {
+		Src       tlb.MsgAddress
+		Dest      tlb.MsgAddress
+		ImportFee tlb.Grams
+	}{
+		Src:  tlb.MsgAddress{SumType: "AddrNone"},
+		Dest: dest.ToMsgAddress(),
+	}
+	m.Body.IsRight = true
+	m.Body.Value = tlb.Any(*body)
+	_, err := ExtractRawMessagesFromTLBMessage(V5R1, &m)
+	if err == nil {
+		t.Fatalf("ExtractRawMessagesFromTLBMessage() had to fail but it didn't")
+	}
+	want := "(wallet id: network -239, workchain -1, version 0, subwallet 7)"
+	if !strings.Contains(err.Error(), want) {
+		t.Fatalf("want error containing %q, got %q", want, err)
+	}
+}
+
+func TestVerifySignatureStr(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	hdr := MessageHeader{
+		SubWalletId: DefaultSubWallet,
+		WalletID:    [10]byte{0xff, 0xff, 0xff, 0x11},
+		ValidUntil:  1_700_000_000,
+		Seqno:       5,
+	}
+	for _, ver := range []Version{V4R2, V5R1} {
+		cell, err := AssembleExternalMessage(ver, privateKey, hdr, nil)
+		if err != nil {
+			t.Fatalf("AssembleExternalMessage() failed: %v", err)
+		}
+		msg, err := cell.ToBocBase64()
+		if err != nil {
+			t.Fatalf("ToBocBase64() failed: %v", err)
+		}
+		keys := []string{
+			hex.EncodeToString(publicKey),
+			strings.ToUpper(hex.EncodeToString(publicKey)),
+			base64.StdEncoding.EncodeToString(publicKey),
+			base64.URLEncoding.EncodeToString(publicKey),
+		}
+		for _, key := range keys {
+			if err := VerifySignatureStr(ver, msg, key); err != nil {
+				t.Fatalf("VerifySignatureStr(%v, %v) failed: %v", ver.ToString(), key, err)
+			}
+		}
+		otherKey := make([]byte, ed25519.PublicKeySize)
+		if err := VerifySignatureStr(ver, msg, hex.EncodeToString(otherKey)); err != ErrBadSignature {
+			t.Fatalf("want ErrBadSignature, got %v", err)
+		}
+		if err := VerifySignatureStr(ver, msg, hex.EncodeToString(publicKey[:16])); err == nil {
+			t.Fatalf("VerifySignatureStr() had to fail for a short key")
+		}
+		if err := VerifySignatureStr(ver, "not a boc", hex.EncodeToString(publicKey)); err == nil {
+			t.Fatalf("VerifySignatureStr() had to fail for an invalid message")
+		}
+	}
+}
+
+func TestDecodeMessage_WithBodyPreprocessor(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	msgs := []RawMessage{mustRawMessage(t, Message{Amount: 1_000, Mode: 3})}
+	hdr := MessageHeader{
+		SubWalletId:    DefaultSubWallet,
+		WalletID:       [10]byte{0xff, 0xff, 0xff, 0x11},
+		ValidUntil:     1_700_000_000,
+		Seqno:          5,
+		BoundedQueryID: 1_700_000_000 << 32,
+	}
+	// a relayer wraps the signed body with a 32-bit tag and its fee.
+	const metaTag = 0x6d657461
+	stripMeta := func(body *boc.Cell) (*boc.Cell, error) {
+		tag, err := body.ReadUint(32)
+		if err != nil {
+			return nil, err
+		}
+		if tag != metaTag {
+			return nil, fmt.Errorf("unexpected tag %#x", tag)
+		}
+		if _, err := body.ReadUint(64); err != nil {
+			return nil, err
+		}
+		return body.NextRef()
+	}
+	for _, ver := range []Version{V3R2, V4R2, HighLoadV2R2, V5R1} {
+		t.Run(ver.ToString(), func(t *testing.T) {
+			msg, err := AssembleExternalMessage(ver, privateKey, hdr, msgs)
+			if err != nil {
+				t.Fatalf("AssembleExternalMessage() failed: %v", err)
+			}
+			var m tlb.Message
+			if err := tlb.Unmarshal(msg, &m); err != nil {
+				t.Fatalf("Unmarshal() failed: %v", err)
+			}
+			body := boc.Cell(m.Body.Value)
+			wrapped := boc.NewCell()
+			if err := wrapped.WriteUint(metaTag, 32); err != nil {
+				t.Fatalf("WriteUint() failed: %v", err)
+			}
+			if err := wrapped.WriteUint(50_000_000, 64); err != nil {
+				t.Fatalf("WriteUint() failed: %v", err)
+			}
+			if err := wrapped.AddRef(&body); err != nil {
+				t.Fatalf("AddRef() failed: %v", err)
+			}
+			m.Body.IsRight = true
+			m.Body.Value = tlb.Any(*wrapped)
+			wrappedMsg := boc.NewCell()
+			if err := tlb.Marshal(wrappedMsg, m); err != nil {
+				t.Fatalf("Marshal() failed: %v", err)
+			}
+			decoded, err := DecodeMessage(ver, wrappedMsg, WithBodyPreprocessor(stripMeta))
+			if err != nil {
+				t.Fatalf("DecodeMessage() failed: %v", err)
+			}
+			if decoded.ValidUntil != hdr.ValidUntil || len(decoded.RawMessages) != 1 {
+				t.Fatalf("unexpected message: %+v", decoded)
+			}
+			wrappedMsg.ResetCounters()
+			if _, err := DecodeMessage(ver, wrappedMsg); err == nil {
+				t.Fatalf("DecodeMessage() had to fail without the preprocessor")
+			}
+		})
+	}
+}
+
+func TestExtractRawMessagesDetailed(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	transfer := mustRawMessage(t, Message{Amount: 1_000, Mode: 3})
+	hdr := MessageHeader{WalletID: [10]byte{0xff, 0xff, 0xff, 0x11}, ValidUntil: 1_700_000_000, Seqno: 5}
+	msg, err := AssembleExternalMessage(V5R1, privateKey, hdr, []RawMessage{transfer})
+	if err != nil {
+		t.Fatalf("AssembleExternalMessage() failed: %v", err)
+	}
+	// the body is decoded once.
+	var preprocessed int
+	countCalls := func(body *boc.Cell) (*boc.Cell, error) {
+		preprocessed++
+		return body, nil
+	}
+	msgs, actions, err := ExtractRawMessagesDetailed(V5R1, msg, WithBodyPreprocessor(countCalls))
+	if err != nil {
+		t.Fatalf("ExtractRawMessagesDetailed() failed: %v", err)
+	}
+	if len(msgs) != 1 || len(actions) != 0 || preprocessed != 1 {
+		t.Fatalf("want 1 message and no extended actions after 1 preprocessing, got %v, %v and %v", len(msgs), len(actions), preprocessed)
+	}
+
+	msg.ResetCounters()
+	var m tlb.Message
+	if err := tlb.Unmarshal(msg, &m); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	body := boc.Cell(m.Body.Value)
+	extension := boc.NewCell()
+	if err := extension.WriteUint(0x02, 8); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	if err := body.AddRef(extension); err != nil {
+		t.Fatalf("AddRef() failed: %v", err)
+	}
+	m.Body.IsRight = true
+	m.Body.Value = tlb.Any(body)
+	withExtension := boc.NewCell()
+	if err := tlb.Marshal(withExtension, m); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	// unknown refs of the body are reported instead of being dropped.
+	if _, _, err = ExtractRawMessagesDetailed(V5R1, withExtension); !errors.Is(err, ErrTrailingData) {
+		t.Fatalf("want ErrTrailingData for an unknown ref, got %v", err)
+	}
+	msgs, actions, err = ExtractRawMessagesDetailed(V4R2, mustFromHex("te6ccgEBAgEAqgAB4YgA2ZpktQsYby0n9cV5VWOFINBjScIU2HdondFsK3lDpEAAQ+B903cV6YIMdtd4QtdyekehadSk+QjIgoIiRgjZD9v81PVGEXBKHPgPUknVvxvr/LGcKkLNhY+I1Wuwi/7ACU1NGLsi5dhQAAAA8AAcAQBoQgApn5hvK5EKvcI4+qgdz+LABkbBy/PLofvLWI8wTW1zT6WWgvAAAAAAAAAAAAAAAAAAAA=="))
+	if err != nil || len(msgs) != 1 || actions != nil {
+		t.Fatalf("want 1 message and no extended actions, got %v, %v, %v", msgs, actions, err)
+	}
+
+	// actions of an extended action list are reported in the order they are executed.
+	var addExtension, disableSignature V5ExtendedAction
+	addExtension.SumType = "AddExtension"
+	addExtension.AddExtension.Addr = hdr.Address.ToMsgAddress()
+	disableSignature.SumType = "SetSignatureAuthAllowed"
+	list := SendMessageList{
+		Actions:  []SendMessageAction{{Mode: transfer.Mode, Msg: transfer.Message}},
+		Extended: []V5ExtendedAction{addExtension, disableSignature},
+	}
+	request, err := BuildV5SigningCell(tlb.Bits80{}, 1_700_000_000, 5, true, list)
+	if err != nil {
+		t.Fatalf("BuildV5SigningCell() failed: %v", err)
+	}
+	if err := request.WriteBytes(make([]byte, 64)); err != nil {
+		t.Fatalf("WriteBytes() failed: %v", err)
+	}
+	extMsg, err := ton.CreateExternalMessage(hdr.Address, request, nil, 0)
+	if err != nil {
+		t.Fatalf("CreateExternalMessage() failed: %v", err)
+	}
+	extendedMsg := boc.NewCell()
+	if err := tlb.Marshal(extendedMsg, extMsg); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	msgs, actions, err = ExtractRawMessagesDetailed(V5R1, extendedMsg)
+	if err != nil {
+		t.Fatalf("ExtractRawMessagesDetailed() failed: %v", err)
+	}
+	if len(msgs) != 1 || len(actions) != 2 {
+		t.Fatalf("want 1 message and 2 extended actions, got %v and %v", len(msgs), len(actions))
+	}
+	if actions[0].SumType != "AddExtension" || actions[1].SumType != "SetSignatureAuthAllowed" {
+		t.Fatalf("want add_ext and set_signature_auth_allowed, got %+v", actions)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	recipient := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	transfer := mustRawMessage(t, SimpleTransfer{Amount: 1_000, Address: recipient, Comment: "hello"})
+	m, err := transfer.ToTLBMessage()
+	if err != nil {
+		t.Fatalf("ToTLBMessage() failed: %v", err)
+	}
+	m.Body.IsRight = !m.Body.IsRight
+	relaid := boc.NewCell()
+	if err := tlb.Marshal(relaid, m); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	fingerprint := func(seqno uint32, msgs ...RawMessage) string {
+		hdr := MessageHeader{SubWalletId: DefaultSubWallet, ValidUntil: 1_700_000_000 + seqno, Seqno: seqno}
+		msg, err := AssembleExternalMessage(V4R2, privateKey, hdr, msgs)
+		if err != nil {
+			t.Fatalf("AssembleExternalMessage() failed: %v", err)
+		}
+		f, err := Fingerprint(V4R2, msg)
+		if err != nil {
+			t.Fatalf("Fingerprint() failed: %v", err)
+		}
+		return f
+	}
+	original := fingerprint(5, transfer)
+	if resigned := fingerprint(6, RawMessage{Message: relaid, Mode: transfer.Mode}); resigned != original {
+		t.Fatalf("re-signed transfer must have the same fingerprint: %v != %v", resigned, original)
+	}
+	if other := fingerprint(5, mustRawMessage(t, SimpleTransfer{Amount: 2_000, Address: recipient, Comment: "hello"})); other == original {
+		t.Fatalf("different transfers must have different fingerprints")
+	}
+	if other := fingerprint(5, RawMessage{Message: transfer.Message, Mode: 1}); other == original {
+		t.Fatalf("transfers with different modes must have different fingerprints")
+	}
+}
+
+func TestExternalMessagesEquivalent(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	recipient := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	msgs := []RawMessage{mustRawMessage(t, SimpleTransfer{Amount: 1_000, Address: recipient, Comment: "hello"})}
+	newMessage := func(address ton.AccountID, seqno uint32) *boc.Cell {
+		hdr := MessageHeader{Address: address, SubWalletId: DefaultSubWallet, ValidUntil: 1_700_000_000, Seqno: seqno}
+		msg, err := AssembleExternalMessage(V4R2, privateKey, hdr, msgs)
+		if err != nil {
+			t.Fatalf("AssembleExternalMessage() failed: %v", err)
+		}
+		return msg
+	}
+	wallet := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	original := newMessage(wallet, 5)
+
+	// the same body moved to the other side of the either and sent in a differently framed BOC.
+	var m tlb.Message
+	if err := tlb.Unmarshal(original, &m); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	m.Body.IsRight = !m.Body.IsRight
+	relaid := boc.NewCell()
+	if err := tlb.Marshal(relaid, m); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	data, err := relaid.ToBocCustom(true, true, false, 0)
+	if err != nil {
+		t.Fatalf("ToBocCustom() failed: %v", err)
+	}
+	reframed, err := boc.DeserializeBoc(data)
+	if err != nil {
+		t.Fatalf("DeserializeBoc() failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		b    *boc.Cell
+		want bool
+	}{
+		{name: "same message", b: newMessage(wallet, 5), want: true},
+		{name: "reframed message", b: reframed[0], want: true},
+		{name: "another seqno", b: newMessage(wallet, 6)},
+		{name: "another wallet", b: newMessage(recipient, 5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExternalMessagesEquivalent(original, tt.b)
+			if err != nil {
+				t.Fatalf("ExternalMessagesEquivalent() failed: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
 		})
 	}
 }
@@ -3,6 +3,7 @@ package wallet
 import (
 	"context"
 	"crypto/ed25519"
+	"encoding/binary"
 	"fmt"
 	"time"
 
@@ -183,6 +184,51 @@ type WalletV5ID struct {
 	SubWalletID     uint32
 }
 
+// decodeWalletV5ID splits an 80-bit wallet id used by wallet v5 into its fields.
+func decodeWalletV5ID(id tlb.Bits80) WalletV5ID {
+	return WalletV5ID{
+		NetworkGlobalID: binary.BigEndian.Uint32(id[0:4]),
+		Workchain:       id[4],
+		WalletVersion:   id[5],
+		SubWalletID:     binary.BigEndian.Uint32(id[6:10]),
+	}
+}
+
+const (
+	MainnetGlobalID int32 = -239
+	TestnetGlobalID int32 = -3
+)
+
+// ValidateV5Network checks that an 80-bit wallet v5 id was created for the given network global id,
+// so a message signed for one network is rejected by a relay sending it to another one.
+func ValidateV5Network(id tlb.Bits80, network int32) error {
+	got := int32(decodeWalletV5ID(id).NetworkGlobalID)
+	if got != network {
+		return fmt.Errorf("wallet id belongs to network %v, expected %v", got, network)
+	}
+	return nil
+}
+
+// v5WalletVersion is the only wallet version byte of a wallet v5 id supported by this package.
+const v5WalletVersion = 0
+
+// ValidateV5WalletID checks that the given 80-bit wallet v5 id has known values in all its fields:
+// a mainnet or testnet global id, the basechain or masterchain workchain and a supported wallet version.
+// The subwallet number can be any.
+func ValidateV5WalletID(id tlb.Bits80) error {
+	walletID := decodeWalletV5ID(id)
+	if network := int32(walletID.NetworkGlobalID); network != MainnetGlobalID && network != TestnetGlobalID {
+		return fmt.Errorf("unknown network global id %v in wallet id", network)
+	}
+	if workchain := int8(walletID.Workchain); workchain != 0 && workchain != -1 {
+		return fmt.Errorf("unknown workchain %v in wallet id", workchain)
+	}
+	if walletID.WalletVersion != v5WalletVersion {
+		return fmt.Errorf("unknown wallet version %v in wallet id", walletID.WalletVersion)
+	}
+	return nil
+}
+
 type DataV5 struct {
 	Seqno      tlb.Uint33
 	WalletID   WalletV5ID
@@ -197,6 +243,25 @@ type DataV4 struct {
 	PluginDict  tlb.HashmapE[tlb.Bits264, tlb.Any] // TODO: find type and check size
 }
 
+// V4Plugins returns a list of plugins installed in a wallet v4 with the given data cell.
+func V4Plugins(data *boc.Cell) ([]ton.AccountID, error) {
+	cell := *data
+	cell.ResetCounters()
+	var dataV4 DataV4
+	if err := tlb.Unmarshal(&cell, &dataV4); err != nil {
+		return nil, err
+	}
+	keys := dataV4.PluginDict.Keys()
+	plugins := make([]ton.AccountID, 0, len(keys))
+	for _, key := range keys {
+		// a key of the plugin dictionary is workchain:int8 address:uint256
+		var address tlb.Bits256
+		copy(address[:], key[1:])
+		plugins = append(plugins, ton.AccountID{Workchain: int32(int8(key[0])), Address: address})
+	}
+	return plugins, nil
+}
+
 // DataHighloadV4 represents data of a highload-wallet contract.
 type DataHighloadV4 struct {
 	SubWalletId     uint32
@@ -1,7 +1,9 @@
 package wallet
 
 import (
+	"crypto/ed25519"
 	"fmt"
+	"math"
 	"math/rand"
 	"time"
 
@@ -13,16 +15,11 @@ import (
 func (w *Wallet) CreateMessage(lifetime time.Duration, messages ...Sendable) (*tlb.Message, error) {
 	var msgArray []RawMessage
 	for _, m := range messages {
-		intMsg, mode, err := m.ToInternal()
+		rawMsg, err := newRawMessage(m)
 		if err != nil {
 			return nil, err
 		}
-		cell := boc.NewCell()
-		err = tlb.Marshal(cell, intMsg)
-		if err != nil {
-			return nil, err
-		}
-		msgArray = append(msgArray, RawMessage{Message: cell, Mode: mode})
+		msgArray = append(msgArray, rawMsg)
 	}
 	err := checkMessagesLimit(len(msgArray), w.ver)
 	if err != nil {
@@ -31,7 +28,7 @@ func (w *Wallet) CreateMessage(lifetime time.Duration, messages ...Sendable) (*t
 	bodyCell := boc.NewCell()
 	switch w.ver {
 	case HighLoadV2R2:
-		boundedID := uint64(time.Now().Add(lifetime).UTC().Unix()<<32) + uint64(rand.Uint32())
+		boundedID := PackHighloadQueryID(uint32(time.Now().Add(lifetime).UTC().Unix()), rand.Uint32())
 		body := HighloadV2Message{
 			SubWalletId:    uint32(w.subWalletId),
 			BoundedQueryID: boundedID,
@@ -65,3 +62,155 @@ func (w *Wallet) CreateMessage(lifetime time.Duration, messages ...Sendable) (*t
 	}
 	return &extMsg, nil
 }
+
+// MessageHeader contains fields of an external message to a wallet besides the messages to send.
+type MessageHeader struct {
+	// Address is an address of the wallet.
+	Address ton.AccountID
+	// Init is attached to the message if the wallet has to be deployed.
+	Init *tlb.StateInit
+	// SubWalletId is used by v3, v4 and highload wallets.
+	SubWalletId uint32
+	// WalletID is an 80-bit wallet id used by v5 wallets instead of SubWalletId.
+	WalletID   tlb.Bits80
+	ValidUntil uint32
+	Seqno      uint32
+	// BoundedQueryID is used by highload wallets instead of ValidUntil and Seqno.
+	// It can be built with PackHighloadQueryID.
+	BoundedQueryID uint64
+}
+
+// AssembleExternalMessage builds and signs an external message to a wallet of the given version.
+// It is the inverse of ExtractRawMessages.
+func AssembleExternalMessage(ver Version, key ed25519.PrivateKey, hdr MessageHeader, msgs []RawMessage) (*boc.Cell, error) {
+	if err := checkMessagesLimit(len(msgs), ver); err != nil {
+		return nil, err
+	}
+	var signedBodyCell *boc.Cell
+	switch ver {
+	case V5R1:
+		actions := SendMessageList{Actions: make([]SendMessageAction, 0, len(msgs))}
+		for _, msg := range msgs {
+			actions.Actions = append(actions.Actions, SendMessageAction{Mode: msg.Mode, Msg: msg.Message})
+		}
+		bodyCell, err := BuildV5SigningCell(hdr.WalletID, hdr.ValidUntil, hdr.Seqno, false, actions)
+		if err != nil {
+			return nil, fmt.Errorf("can not marshal wallet message body: %v", err)
+		}
+		signBytes, err := bodyCell.Sign(key)
+		if err != nil {
+			return nil, fmt.Errorf("can not sign wallet message body: %v", err)
+		}
+		if err := bodyCell.WriteBytes(signBytes); err != nil {
+			return nil, fmt.Errorf("can not marshal signed body: %v", err)
+		}
+		signedBodyCell = bodyCell
+	default:
+		var body any
+		switch ver {
+		case V3R1, V3R2:
+			body = MessageV3{
+				SubWalletId: hdr.SubWalletId,
+				ValidUntil:  hdr.ValidUntil,
+				Seqno:       hdr.Seqno,
+				RawMessages: PayloadV1toV4(msgs),
+			}
+		case V4R1, V4R2:
+			body = MessageV4{
+				SubWalletId: hdr.SubWalletId,
+				ValidUntil:  hdr.ValidUntil,
+				Seqno:       hdr.Seqno,
+				RawMessages: PayloadV1toV4(msgs),
+			}
+		case HighLoadV2R2:
+			body = HighloadV2Message{
+				SubWalletId:    hdr.SubWalletId,
+				BoundedQueryID: hdr.BoundedQueryID,
+				RawMessages:    PayloadHighload(msgs),
+			}
+		case HighLoadV1R1, HighLoadV1R2:
+			body = HighloadV1Message{
+				SubWalletId: hdr.SubWalletId,
+				ValidUntil:  hdr.ValidUntil,
+				Seqno:       hdr.Seqno,
+				RawMessages: PayloadHighload(msgs),
+			}
+		default:
+			return nil, fmt.Errorf("message body generation for this wallet is not supported: %v", ver.ToString())
+		}
+		var err error
+		if signedBodyCell, err = signMsgBody(key, body); err != nil {
+			return nil, err
+		}
+	}
+	extMsg, err := ton.CreateExternalMessage(hdr.Address, signedBodyCell, hdr.Init, 0)
+	if err != nil {
+		return nil, fmt.Errorf("can not create external message: %v", err)
+	}
+	extMsgCell := boc.NewCell()
+	if err := tlb.Marshal(extMsgCell, extMsg); err != nil {
+		return nil, fmt.Errorf("can not marshal wallet external message: %v", err)
+	}
+	return extMsgCell, nil
+}
+
+// signMsgBody encodes the given body of a v3, v4 or highload wallet message and prepends its signature.
+func signMsgBody(key ed25519.PrivateKey, body any) (*boc.Cell, error) {
+	bodyCell := boc.NewCell()
+	if err := tlb.Marshal(bodyCell, body); err != nil {
+		return nil, fmt.Errorf("can not marshal wallet message body: %v", err)
+	}
+	signBytes, err := bodyCell.Sign(key)
+	if err != nil {
+		return nil, fmt.Errorf("can not sign wallet message body: %v", err)
+	}
+	signedBody := SignedMsgBody{
+		Message: tlb.Any(*bodyCell),
+	}
+	copy(signedBody.Sign[:], signBytes)
+	signedBodyCell := boc.NewCell()
+	if err := tlb.Marshal(signedBodyCell, signedBody); err != nil {
+		return nil, fmt.Errorf("can not marshal signed body: %v", err)
+	}
+	return signedBodyCell, nil
+}
+
+// BuildSignedMessageV4Batch signs a body of a wallet v4 message for each of the given payloads,
+// the first body gets startSeqno and every next one gets a seqno greater by one.
+// A wallet accepts only a message with its current seqno, so the bodies have to be sent in order,
+// each after the previous one is processed.
+// The returned cells are bodies of external messages, see ton.CreateExternalMessage.
+func BuildSignedMessageV4Batch(key ed25519.PrivateKey, subwallet, validUntil, startSeqno uint32, batches []PayloadV1toV4) ([]*boc.Cell, error) {
+	if uint64(startSeqno)+uint64(len(batches)) > math.MaxUint32+1 {
+		return nil, fmt.Errorf("seqno overflows for %v messages starting at %v", len(batches), startSeqno)
+	}
+	bodies := make([]*boc.Cell, 0, len(batches))
+	for i, payload := range batches {
+		if err := checkMessagesLimit(len(payload), V4R2); err != nil {
+			return nil, fmt.Errorf("batch %v: %w", i, err)
+		}
+		body, err := signMsgBody(key, MessageV4{
+			SubWalletId: subwallet,
+			ValidUntil:  validUntil,
+			Seqno:       startSeqno + uint32(i),
+			RawMessages: payload,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("batch %v: %w", i, err)
+		}
+		bodies = append(bodies, body)
+	}
+	return bodies, nil
+}
+
+// BuildSeqnoBumpV4 signs a body of a wallet v4 message without messages to send.
+// A wallet accepts such a message only with its current seqno and increments the seqno,
+// so it invalidates any pending message signed with the same seqno.
+// The returned cell is a body of an external message, see ton.CreateExternalMessage.
+func BuildSeqnoBumpV4(key ed25519.PrivateKey, subwallet, validUntil, seqno uint32) (*boc.Cell, error) {
+	return signMsgBody(key, MessageV4{
+		SubWalletId: subwallet,
+		ValidUntil:  validUntil,
+		Seqno:       seqno,
+	})
+}
@@ -0,0 +1,295 @@
+package wallet
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+)
+
+func TestAssembleExternalMessage(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	recipient := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	msgs := []RawMessage{
+		mustRawMessage(t, Message{Amount: 1_000, Address: recipient, Mode: 3}),
+		mustRawMessage(t, Message{Amount: 2_000, Address: recipient, Mode: 1}),
+	}
+	hdr := MessageHeader{
+		Address:        ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f"),
+		SubWalletId:    DefaultSubWallet,
+		WalletID:       [10]byte{0xff, 0xff, 0xff, 0x11, 0, 0, 0, 0, 0, 7},
+		ValidUntil:     1_700_000_000,
+		Seqno:          5,
+		BoundedQueryID: 1_700_000_000<<32 + 1,
+	}
+	for _, ver := range []Version{V3R2, V4R2, HighLoadV2R2, V5R1} {
+		t.Run(ver.ToString(), func(t *testing.T) {
+			cell, err := AssembleExternalMessage(ver, privateKey, hdr, msgs)
+			if err != nil {
+				t.Fatalf("AssembleExternalMessage() failed: %v", err)
+			}
+			detected, err := DetectVersion(cell)
+			if err != nil {
+				t.Fatalf("DetectVersion() failed: %v", err)
+			}
+			if detected != ver {
+				t.Fatalf("want version %v, got %v", ver.ToString(), detected.ToString())
+			}
+			cell.ResetCounters()
+			decoded, err := DecodeMessage(ver, cell)
+			if err != nil {
+				t.Fatalf("DecodeMessage() failed: %v", err)
+			}
+			if decoded.ValidUntil != hdr.ValidUntil || len(decoded.RawMessages) != len(msgs) {
+				t.Fatalf("unexpected decoded message: %+v", decoded)
+			}
+			for i, msg := range decoded.RawMessages {
+				if msg.Mode != msgs[i].Mode {
+					t.Fatalf("message %v: want mode %v, got %v", i, msgs[i].Mode, msg.Mode)
+				}
+			}
+			cell.ResetCounters()
+			publicKey := privateKey.Public().(ed25519.PublicKey)
+			if ver == V5R1 {
+				var m tlb.Message
+				if err := tlb.Unmarshal(cell, &m); err != nil {
+					t.Fatalf("Unmarshal() failed: %v", err)
+				}
+				if err := MessageV5VerifySignature(boc.Cell(m.Body.Value), publicKey); err != nil {
+					t.Fatalf("MessageV5VerifySignature() failed: %v", err)
+				}
+				return
+			}
+			if err := VerifySignature(ver, cell, publicKey); err != nil {
+				t.Fatalf("VerifySignature() failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestAssembleExternalMessage_HighloadV1(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	msgs := []RawMessage{mustRawMessage(t, Message{Amount: 1_000, Mode: 3})}
+	hdr := MessageHeader{SubWalletId: DefaultSubWallet, ValidUntil: 1_700_000_000, Seqno: 5}
+	cell, err := AssembleExternalMessage(HighLoadV1R2, privateKey, hdr, msgs)
+	if err != nil {
+		t.Fatalf("AssembleExternalMessage() failed: %v", err)
+	}
+	decoded, err := DecodeMessage(HighLoadV1R2, cell)
+	if err != nil {
+		t.Fatalf("DecodeMessage() failed: %v", err)
+	}
+	if decoded.ValidUntil != hdr.ValidUntil || decoded.Seqno != hdr.Seqno || len(decoded.RawMessages) != len(msgs) {
+		t.Fatalf("unexpected decoded message: %+v", decoded)
+	}
+	cell.ResetCounters()
+	if err := VerifySignature(HighLoadV1R2, cell, privateKey.Public().(ed25519.PublicKey)); err != nil {
+		t.Fatalf("VerifySignature() failed: %v", err)
+	}
+	cell.ResetCounters()
+	if _, _, equal, err := HashStable(HighLoadV1R2, cell); err != nil || !equal {
+		t.Fatalf("want a stable hash, got %v, %v", equal, err)
+	}
+}
+
+func TestVerifyV4WithData(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	recipient := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	hdr := MessageHeader{
+		Address:     ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f"),
+		SubWalletId: DefaultSubWallet,
+		ValidUntil:  1_700_000_000,
+		Seqno:       5,
+	}
+	msg, err := AssembleExternalMessage(V4R2, privateKey, hdr, []RawMessage{
+		mustRawMessage(t, Message{Amount: 1_000, Address: recipient, Mode: 3}),
+	})
+	if err != nil {
+		t.Fatalf("AssembleExternalMessage() failed: %v", err)
+	}
+	newData := func(key ed25519.PrivateKey) *boc.Cell {
+		data := DataV4{Seqno: 5, SubWalletId: DefaultSubWallet}
+		copy(data.PublicKey[:], key.Public().(ed25519.PublicKey))
+		cell := boc.NewCell()
+		if err := tlb.Marshal(cell, data); err != nil {
+			t.Fatalf("Marshal() failed: %v", err)
+		}
+		return cell
+	}
+	if err := VerifyV4WithData(msg, newData(privateKey)); err != nil {
+		t.Fatalf("VerifyV4WithData() failed: %v", err)
+	}
+	otherKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	msg.ResetCounters()
+	if err := VerifyV4WithData(msg, newData(otherKey)); err != ErrBadSignature {
+		t.Fatalf("want ErrBadSignature, got %v", err)
+	}
+}
+
+func TestVerifyV4AgainstContract(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	hdr := MessageHeader{SubWalletId: DefaultSubWallet, ValidUntil: 1_700_000_000, Seqno: 5}
+	msg, err := AssembleExternalMessage(V4R2, privateKey, hdr, nil)
+	if err != nil {
+		t.Fatalf("AssembleExternalMessage() failed: %v", err)
+	}
+	getter := func(key ed25519.PublicKey, err error) func() (ed25519.PublicKey, error) {
+		return func() (ed25519.PublicKey, error) { return key, err }
+	}
+	if err := VerifyV4AgainstContract(msg, getter(privateKey.Public().(ed25519.PublicKey), nil)); err != nil {
+		t.Fatalf("VerifyV4AgainstContract() failed: %v", err)
+	}
+	otherKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	msg.ResetCounters()
+	if err := VerifyV4AgainstContract(msg, getter(otherKey.Public().(ed25519.PublicKey), nil)); err != ErrBadSignature {
+		t.Fatalf("want ErrBadSignature, got %v", err)
+	}
+	errNotDeployed := fmt.Errorf("account is not deployed")
+	msg.ResetCounters()
+	if err := VerifyV4AgainstContract(msg, getter(nil, errNotDeployed)); !errors.Is(err, errNotDeployed) {
+		t.Fatalf("want the getter error, got %v", err)
+	}
+}
+
+func TestVerifySignatureWithKeyResolver(t *testing.T) {
+	keys := map[uint32]ed25519.PrivateKey{}
+	for _, subWalletID := range []uint32{DefaultSubWallet, DefaultSubWallet + 1} {
+		privateKey, err := SeedToPrivateKey(RandomSeed())
+		if err != nil {
+			t.Fatalf("SeedToPrivateKey() failed: %v", err)
+		}
+		keys[subWalletID] = privateKey
+	}
+	resolve := func(msg *DecodedMessage) (ed25519.PublicKey, error) {
+		key, ok := keys[msg.SubWalletId]
+		if !ok {
+			return nil, fmt.Errorf("unknown subwallet %v", msg.SubWalletId)
+		}
+		return key.Public().(ed25519.PublicKey), nil
+	}
+	for subWalletID, privateKey := range keys {
+		hdr := MessageHeader{SubWalletId: subWalletID, ValidUntil: 1_700_000_000, Seqno: 1}
+		msg, err := AssembleExternalMessage(V4R2, privateKey, hdr, nil)
+		if err != nil {
+			t.Fatalf("AssembleExternalMessage() failed: %v", err)
+		}
+		if err := VerifySignatureWithKeyResolver(V4R2, msg, resolve); err != nil {
+			t.Fatalf("VerifySignatureWithKeyResolver() failed: %v", err)
+		}
+	}
+	hdr := MessageHeader{SubWalletId: DefaultSubWallet, ValidUntil: 1_700_000_000, Seqno: 1}
+	msg, err := AssembleExternalMessage(V4R2, keys[DefaultSubWallet+1], hdr, nil)
+	if err != nil {
+		t.Fatalf("AssembleExternalMessage() failed: %v", err)
+	}
+	if err := VerifySignatureWithKeyResolver(V4R2, msg, resolve); err != ErrBadSignature {
+		t.Fatalf("want ErrBadSignature, got %v", err)
+	}
+	hdr.SubWalletId = 7
+	msg, err = AssembleExternalMessage(V4R2, keys[DefaultSubWallet], hdr, nil)
+	if err != nil {
+		t.Fatalf("AssembleExternalMessage() failed: %v", err)
+	}
+	if err := VerifySignatureWithKeyResolver(V4R2, msg, resolve); err == nil {
+		t.Fatalf("VerifySignatureWithKeyResolver() must fail for an unknown subwallet")
+	}
+}
+
+func TestBuildSignedMessageV4Batch(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	address := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	recipient := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	batches := []PayloadV1toV4{
+		{mustRawMessage(t, Message{Amount: 1_000, Address: recipient, Mode: 3})},
+		{},
+		{mustRawMessage(t, Message{Amount: 2_000, Address: recipient, Mode: 3}), mustRawMessage(t, Message{Amount: 3_000, Address: recipient, Mode: 3})},
+	}
+	bodies, err := BuildSignedMessageV4Batch(privateKey, DefaultSubWallet, 1_700_000_000, 10, batches)
+	if err != nil {
+		t.Fatalf("BuildSignedMessageV4Batch() failed: %v", err)
+	}
+	if len(bodies) != len(batches) {
+		t.Fatalf("want %v bodies, got %v", len(batches), len(bodies))
+	}
+	for i, body := range bodies {
+		extMsg, err := ton.CreateExternalMessage(address, body, nil, 0)
+		if err != nil {
+			t.Fatalf("CreateExternalMessage() failed: %v", err)
+		}
+		cell := boc.NewCell()
+		if err := tlb.Marshal(cell, extMsg); err != nil {
+			t.Fatalf("Marshal() failed: %v", err)
+		}
+		if err := VerifySignature(V4R2, cell, privateKey.Public().(ed25519.PublicKey)); err != nil {
+			t.Fatalf("VerifySignature() failed: %v", err)
+		}
+		cell.ResetCounters()
+		msg, err := DecodeMessageV4(cell)
+		if err != nil {
+			t.Fatalf("DecodeMessageV4() failed: %v", err)
+		}
+		if msg.Seqno != 10+uint32(i) || msg.SubWalletId != DefaultSubWallet || len(msg.RawMessages) != len(batches[i]) {
+			t.Fatalf("unexpected message %v: %+v", i, msg)
+		}
+	}
+	if _, err := BuildSignedMessageV4Batch(privateKey, DefaultSubWallet, 1_700_000_000, math.MaxUint32, batches); err == nil {
+		t.Fatalf("BuildSignedMessageV4Batch() had to fail on seqno overflow")
+	}
+}
+
+func TestBuildSeqnoBumpV4(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	address := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	body, err := BuildSeqnoBumpV4(privateKey, DefaultSubWallet, 1_700_000_000, 42)
+	if err != nil {
+		t.Fatalf("BuildSeqnoBumpV4() failed: %v", err)
+	}
+	extMsg, err := ton.CreateExternalMessage(address, body, nil, 0)
+	if err != nil {
+		t.Fatalf("CreateExternalMessage() failed: %v", err)
+	}
+	cell := boc.NewCell()
+	if err := tlb.Marshal(cell, extMsg); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	if err := VerifySignature(V4R2, cell, privateKey.Public().(ed25519.PublicKey)); err != nil {
+		t.Fatalf("VerifySignature() failed: %v", err)
+	}
+	cell.ResetCounters()
+	msg, err := DecodeMessageV4Strict(cell)
+	if err != nil {
+		t.Fatalf("DecodeMessageV4Strict() failed: %v", err)
+	}
+	if msg.Seqno != 42 || msg.SubWalletId != DefaultSubWallet || msg.ValidUntil != 1_700_000_000 || msg.Op != 0 || len(msg.RawMessages) != 0 {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+}
@@ -0,0 +1,67 @@
+package wallet
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// DecodeObserver is notified about every wallet message decoded by this package,
+// for example, to collect metrics.
+type DecodeObserver interface {
+	// ObserveDecode is called after a message is decoded.
+	// ver is V3R2, V4R2, HighLoadV1R2, HighLoadV2R2 or V5R1 for DecodeMessageV3, DecodeMessageV4,
+	// DecodeHighloadV1Message, DecodeHighloadV2Message and DecodeMessageV5,
+	// and the requested version for ExtractRawMessages.
+	ObserveDecode(ver Version, duration time.Duration, err error)
+}
+
+type decodeObserverHolder struct {
+	observer DecodeObserver
+}
+
+var decodeObserver atomic.Pointer[decodeObserverHolder]
+
+// SetDecodeObserver sets an observer notified about decoded messages.
+// nil removes the current observer.
+func SetDecodeObserver(o DecodeObserver) {
+	if o == nil {
+		decodeObserver.Store(nil)
+		return
+	}
+	decodeObserver.Store(&decodeObserverHolder{observer: o})
+}
+
+// observeDecode notifies the current observer, it is supposed to be deferred by decode entry points.
+func observeDecode(ver Version, start time.Time, err *error) {
+	holder := decodeObserver.Load()
+	if holder == nil {
+		return
+	}
+	holder.observer.ObserveDecode(ver, time.Since(start), *err)
+}
+
+// ActionListTracer is notified about every node of a wallet v5 action list walked by SendMessageList.UnmarshalTLB,
+// for example, to debug a malformed list built by a third-party SDK.
+type ActionListTracer interface {
+	// TraceActionListNode is called before a node is decoded.
+	// index is a position of the node counting from the root cell of the list,
+	// bits and refs are numbers of unread bits and refs of the node.
+	// The last node of a list is an empty cell.
+	TraceActionListNode(index int, bits int, refs int)
+}
+
+type actionListTracerHolder struct {
+	tracer ActionListTracer
+}
+
+var actionListTracer atomic.Pointer[actionListTracerHolder]
+
+// SetActionListTracer sets a tracer notified about nodes of decoded action lists.
+// nil removes the current tracer, there is no tracer by default.
+func SetActionListTracer(t ActionListTracer) {
+	if t == nil {
+		actionListTracer.Store(nil)
+		return
+	}
+	actionListTracer.Store(&actionListTracerHolder{tracer: t})
+}
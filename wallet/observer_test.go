@@ -0,0 +1,71 @@
+package wallet
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testDecodeObserver struct {
+	versions []Version
+	errors   int
+}
+
+func (o *testDecodeObserver) ObserveDecode(ver Version, duration time.Duration, err error) {
+	o.versions = append(o.versions, ver)
+	if err != nil {
+		o.errors++
+	}
+}
+
+func TestSetDecodeObserver(t *testing.T) {
+	observer := &testDecodeObserver{}
+	SetDecodeObserver(observer)
+	defer SetDecodeObserver(nil)
+
+	const v4 = "te6ccgEBAgEAqgAB4YgA2ZpktQsYby0n9cV5VWOFINBjScIU2HdondFsK3lDpEAAQ+B903cV6YIMdtd4QtdyekehadSk+QjIgoIiRgjZD9v81PVGEXBKHPgPUknVvxvr/LGcKkLNhY+I1Wuwi/7ACU1NGLsi5dhQAAAA8AAcAQBoQgApn5hvK5EKvcI4+qgdz+LABkbBy/PLofvLWI8wTW1zT6WWgvAAAAAAAAAAAAAAAAAAAA=="
+	if _, err := DecodeMessageV4(mustFromHex(v4)); err != nil {
+		t.Fatalf("DecodeMessageV4() failed: %v", err)
+	}
+	if _, err := DecodeMessageV5(mustFromHex(v4)); err == nil {
+		t.Fatalf("DecodeMessageV5() had to fail but it didn't")
+	}
+	if len(observer.versions) != 2 || observer.versions[0] != V4R2 || observer.versions[1] != V5R1 {
+		t.Fatalf("unexpected observed versions: %v", observer.versions)
+	}
+	if observer.errors != 1 {
+		t.Fatalf("want 1 error, got %v", observer.errors)
+	}
+}
+
+type actionListNode struct {
+	index, bits, refs int
+}
+
+type testActionListTracer struct {
+	nodes []actionListNode
+}
+
+func (t *testActionListTracer) TraceActionListNode(index int, bits int, refs int) {
+	t.nodes = append(t.nodes, actionListNode{index: index, bits: bits, refs: refs})
+}
+
+func TestSetActionListTracer(t *testing.T) {
+	tracer := &testActionListTracer{}
+	SetActionListTracer(tracer)
+	defer SetActionListTracer(nil)
+
+	const v5 = "te6ccgECCAEAAZ4AAfGIAehvqHPiQ2Ru+zkowjJx/7oJbqEYRnlCOuPe5+2gm24WA5tLO3f////oAAAAAAADMYd8kAAAAAEHzN670eqqNU3yWGkX1dOynyAbT7DN4cFDpE0r+nInTomGrifjPTaZvG3YxYzTHpLoNesGc9s5Q0tHlLNcFNQeAQIKDsPIbQMCAwIKDsPIbQMEBQCpaAHob6hz4kNkbvs5KMIycf+6CW6hGEZ5Qjrj3uftoJtuFwAbM0yWoWMN5aT+uK8qrHCkGgxpOEKbDu0Tui2Fbyh0iAy3GwAAAAAAAAAAAAAAAAAAQAIKDsPIbQMGBwCpaAHob6hz4kNkbvs5KMIycf+6CW6hGEZ5Qjrj3uftoJtuFwAbM0yWoWMN5aT+uK8qrHCkGgxpOEKbDu0Tui2Fbyh0iAx6EgAAAAAAAAAAAAAAAAAAQAAAAKloAehvqHPiQ2Ru+zkowjJx/7oJbqEYRnlCOuPe5+2gm24XABszTJahYw3lpP64ryqscKQaDGk4QpsO7RO6LYVvKHSIDD0JAAAAAAAAAAAAAAAAAABA"
+	if _, err := DecodeMessageV5(mustFromHex(v5)); err != nil {
+		t.Fatalf("DecodeMessageV5() failed: %v", err)
+	}
+	want := []actionListNode{
+		{index: 0, bits: sendMsgActionBits, refs: 2},
+		{index: 1, bits: sendMsgActionBits, refs: 2},
+		{index: 2, bits: sendMsgActionBits, refs: 2},
+		{index: 3, bits: 0, refs: 0},
+	}
+	if !reflect.DeepEqual(tracer.nodes, want) {
+		t.Fatalf("want nodes %v, got %v", want, tracer.nodes)
+	}
+}
@@ -0,0 +1,695 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+)
+
+var (
+	ErrNotInternalMessage = errors.New("not an internal message")
+	// ErrValueDependsOnBalance means that a message carries the remaining balance of a wallet (mode 128),
+	// so its value can't be calculated from the message alone.
+	ErrValueDependsOnBalance = errors.New("message value depends on the wallet balance")
+	ErrNotNFTTransfer        = errors.New("not an nft transfer")
+	ErrNotJettonTransfer     = errors.New("not a jetton transfer")
+	ErrNotTransfer           = errors.New("not a jetton or an nft transfer")
+	// ErrForwardDepthExceeded is returned when transfers are nested in forward payloads deeper than allowed,
+	// see WithMaxForwardDepth.
+	ErrForwardDepthExceeded = errors.New("forward payloads are nested too deep")
+)
+
+const (
+	nftTransferOpcode    = 0x5fcc3d14
+	jettonTransferOpcode = 0x0f8a7ea5
+)
+
+// DefaultMaxForwardDepth is a number of nested forward payloads TransferChain decodes by default.
+const DefaultMaxForwardDepth = 4
+
+// NFTTransferPayload is a body of a message transferring an NFT item, see TEP-62.
+type NFTTransferPayload struct {
+	QueryID             uint64
+	NewOwner            tlb.MsgAddress
+	ResponseDestination tlb.MsgAddress
+	CustomPayload       *tlb.Any `tlb:"maybe^"`
+	ForwardAmount       tlb.Grams
+	ForwardPayload      tlb.EitherRef[tlb.Any]
+}
+
+// JettonTransferPayload is a body of a message transferring jettons, see TEP-74.
+type JettonTransferPayload struct {
+	QueryID             uint64
+	Amount              tlb.VarUInteger16
+	Destination         tlb.MsgAddress
+	ResponseDestination tlb.MsgAddress
+	CustomPayload       *tlb.Any `tlb:"maybe^"`
+	ForwardTonAmount    tlb.VarUInteger16
+	ForwardPayload      tlb.EitherRef[tlb.Any]
+}
+
+// NewTransferMessage builds an internal message transferring the given amount to dest with an optional text comment.
+func NewTransferMessage(dest ton.AccountID, amount tlb.Grams, comment string, mode byte, bounce bool) (RawMessage, error) {
+	msg, err := newRawMessage(SimpleTransfer{
+		Amount:     amount,
+		Address:    dest,
+		Comment:    comment,
+		Bounceable: bounce,
+	})
+	if err != nil {
+		return RawMessage{}, err
+	}
+	msg.Mode = mode
+	return msg, nil
+}
+
+// newRawMessage encodes the given Sendable as an internal message.
+func newRawMessage(m Sendable) (RawMessage, error) {
+	intMsg, mode, err := m.ToInternal()
+	if err != nil {
+		return RawMessage{}, err
+	}
+	cell := boc.NewCell()
+	if err := tlb.Marshal(cell, intMsg); err != nil {
+		return RawMessage{}, err
+	}
+	return RawMessage{Message: cell, Mode: mode}, nil
+}
+
+// ToTLBMessage decodes the cell of this RawMessage into a tlb.Message with its info, state init and body.
+// The cell is read from the beginning, so it can be called repeatedly.
+func (m RawMessage) ToTLBMessage() (*tlb.Message, error) {
+	if m.Message == nil {
+		return nil, fmt.Errorf("raw message has no cell")
+	}
+	m.Message.ResetCounters()
+	var msg tlb.Message
+	if err := tlb.Unmarshal(m.Message, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// GasBudget estimates how much TON reaches the destination of this message once the wallet sends it.
+//
+// With mode 128 the message carries the whole remaining balance of a wallet, so ErrValueDependsOnBalance is returned.
+// With mode 64 the remaining value of the inbound message is added,
+// but an external message sent to a wallet has no value, so it adds nothing.
+// Unless PayFeesSeparately is set, the fees declared in the message are deducted from the value.
+// Declared fees are usually zero because the network calculates the forward fee itself,
+// so the result is an upper bound of what the recipient gets.
+func (m RawMessage) GasBudget() (forwardValue tlb.Grams, err error) {
+	msg, err := m.ToTLBMessage()
+	if err != nil {
+		return 0, err
+	}
+	if msg.Info.SumType != "IntMsgInfo" {
+		return 0, ErrNotInternalMessage
+	}
+	if IsMessageModeSet(int(m.Mode), AttachAllRemainingBalance) {
+		return 0, ErrValueDependsOnBalance
+	}
+	info := msg.Info.IntMsgInfo
+	value := info.Value.Grams
+	if IsMessageModeSet(int(m.Mode), PayFeesSeparately) {
+		return value, nil
+	}
+	fees := info.IhrFee + info.FwdFee
+	if fees >= value {
+		return 0, nil
+	}
+	return value - fees, nil
+}
+
+// Bounce returns the bounce and bounced flags of this internal message.
+// A bounceable message returns its value to the wallet if the recipient fails to process it or doesn't exist,
+// a non-bounceable message sent to a non-existent account stays there.
+// Bounced is set for messages bounced back to their sender, wallets don't send such messages themselves.
+func (m RawMessage) Bounce() (bounce bool, bounced bool, err error) {
+	msg, err := m.ToTLBMessage()
+	if err != nil {
+		return false, false, err
+	}
+	if msg.Info.SumType != "IntMsgInfo" {
+		return false, false, ErrNotInternalMessage
+	}
+	return msg.Info.IntMsgInfo.Bounce, msg.Info.IntMsgInfo.Bounced, nil
+}
+
+// DeploysContract reports whether this internal message carries a StateInit and returns it.
+// The message deploys a contract if its destination doesn't exist yet,
+// the address of the contract is the hash of the StateInit in the workchain of the destination.
+func (m RawMessage) DeploysContract() (bool, *tlb.StateInit, error) {
+	msg, err := m.ToTLBMessage()
+	if err != nil {
+		return false, nil, err
+	}
+	if msg.Info.SumType != "IntMsgInfo" {
+		return false, nil, ErrNotInternalMessage
+	}
+	if !msg.Init.Exists {
+		return false, nil, nil
+	}
+	init := msg.Init.Value.Value
+	return true, &init, nil
+}
+
+// DestroysAccount reports whether sending this message destroys the wallet.
+// It is true if the mode combines AttachAllRemainingBalance with DestroyAccount:
+// the message carries the whole balance away, so the balance becomes zero and the account is destroyed.
+// DestroyAccount alone destroys the wallet only if its balance happens to become zero,
+// which can't be told from the message.
+func (m RawMessage) DestroysAccount() bool {
+	return IsMessageModeSet(int(m.Mode), AttachAllRemainingBalance) && IsMessageModeSet(int(m.Mode), DestroyAccount)
+}
+
+// PayloadDestroysWallet reports whether any of the given messages destroys the wallet sending them, see RawMessage.DestroysAccount.
+func PayloadDestroysWallet(msgs []RawMessage) bool {
+	for _, msg := range msgs {
+		if msg.DestroysAccount() {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtraCurrencies returns amounts of extra currencies carried by this internal message in addition to Toncoin,
+// keyed by currency id. An empty map is returned if the message carries only Toncoin.
+func (m RawMessage) ExtraCurrencies() (map[uint32]tlb.VarUInteger32, error) {
+	msg, err := m.ToTLBMessage()
+	if err != nil {
+		return nil, err
+	}
+	if msg.Info.SumType != "IntMsgInfo" {
+		return nil, ErrNotInternalMessage
+	}
+	items := msg.Info.IntMsgInfo.Value.Other.Dict.Items()
+	currencies := make(map[uint32]tlb.VarUInteger32, len(items))
+	for _, item := range items {
+		currencies[uint32(item.Key)] = item.Value
+	}
+	return currencies, nil
+}
+
+// BodyOpcode returns the leading 32-bit opcode of the body of this message.
+// The returned bool is false if the body is shorter than 32 bits and has no opcode.
+// Zero opcode means that the body is a text comment.
+func (m RawMessage) BodyOpcode() (uint32, bool, error) {
+	msg, err := m.ToTLBMessage()
+	if err != nil {
+		return 0, false, err
+	}
+	body := boc.Cell(msg.Body.Value)
+	if body.BitsAvailableForRead() < 32 {
+		return 0, false, nil
+	}
+	op, err := body.ReadUint(32)
+	if err != nil {
+		return 0, false, err
+	}
+	return uint32(op), true, nil
+}
+
+// BodyKind is a kind of a message body returned by RawMessage.BodyKind.
+type BodyKind int
+
+const (
+	// BodyEmpty is a body without bits and refs.
+	BodyEmpty BodyKind = iota
+	// BodyText is a body starting with the zero opcode of a text comment.
+	BodyText
+	// BodyOp is a body starting with a non-zero 32-bit opcode.
+	BodyOp
+	// BodyBinary is a non-empty body too short to hold an opcode.
+	BodyBinary
+)
+
+// BodyKind classifies the body of this message by its first 32 bits.
+// The body itself is not decoded, so BodyText doesn't guarantee that the comment is a valid text.
+func (m RawMessage) BodyKind() (BodyKind, error) {
+	body, err := m.body()
+	if err != nil {
+		return 0, err
+	}
+	if body.BitsAvailableForRead() == 0 && body.RefsAvailableForRead() == 0 {
+		return BodyEmpty, nil
+	}
+	if body.BitsAvailableForRead() < 32 {
+		return BodyBinary, nil
+	}
+	op, err := body.ReadUint(32)
+	if err != nil {
+		return 0, err
+	}
+	if op == 0 {
+		return BodyText, nil
+	}
+	return BodyOp, nil
+}
+
+// BodyPrefix returns up to the first n bytes of data of the root cell of the body of this message.
+// A body shorter than n bytes returns all its data, and its last incomplete byte is padded with zero bits.
+// Refs of the body are not read.
+func (m RawMessage) BodyPrefix(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("invalid prefix length %v", n)
+	}
+	body, err := m.body()
+	if err != nil {
+		return nil, err
+	}
+	bits := body.BitsAvailableForRead()
+	if bits >= 8*n {
+		return body.ReadBytes(n)
+	}
+	prefix, err := body.ReadBytes(bits / 8)
+	if err != nil {
+		return nil, err
+	}
+	if rest := bits % 8; rest > 0 {
+		last, err := body.ReadUint(rest)
+		if err != nil {
+			return nil, err
+		}
+		prefix = append(prefix, byte(last<<(8-rest)))
+	}
+	return prefix, nil
+}
+
+// MatchesOp reports whether the given message body starts with the given 32-bit opcode.
+// The body is read from its current position, and the position is left unchanged.
+// A body shorter than 32 bits matches no opcode.
+func MatchesOp(body *boc.Cell, op uint32) (bool, error) {
+	if body == nil || body.BitsAvailableForRead() < 32 {
+		return false, nil
+	}
+	bodyOp, err := body.PickUint(32)
+	if err != nil {
+		return false, err
+	}
+	return uint32(bodyOp) == op, nil
+}
+
+// NFTTransfer decodes the body of this message as an NFT transfer.
+// ErrNotNFTTransfer is returned if the body doesn't start with the transfer opcode.
+func (m RawMessage) NFTTransfer() (*NFTTransferPayload, error) {
+	body, err := m.body()
+	if err != nil {
+		return nil, err
+	}
+	transfer, err := decodeTransfer(body)
+	if err != nil {
+		return nil, err
+	}
+	if transfer.NFT == nil {
+		return nil, ErrNotNFTTransfer
+	}
+	return transfer.NFT, nil
+}
+
+// JettonTransfer decodes the body of this message as a jetton transfer sent to a jetton wallet.
+// ErrNotJettonTransfer is returned if the body doesn't start with the transfer opcode.
+func (m RawMessage) JettonTransfer() (*JettonTransferPayload, error) {
+	body, err := m.body()
+	if err != nil {
+		return nil, err
+	}
+	transfer, err := decodeTransfer(body)
+	if err != nil {
+		return nil, err
+	}
+	if transfer.Jetton == nil {
+		return nil, ErrNotJettonTransfer
+	}
+	return transfer.Jetton, nil
+}
+
+// ResponseDestination returns an account excess TON is returned to by a jetton or an NFT transfer in the body of this message.
+// The returned bool is false if the body is not a transfer or the transfer has no response destination.
+func (m RawMessage) ResponseDestination() (ton.AccountID, bool, error) {
+	body, err := m.body()
+	if err != nil {
+		return ton.AccountID{}, false, err
+	}
+	transfer, err := decodeTransfer(body)
+	if err != nil {
+		return ton.AccountID{}, false, err
+	}
+	var addr tlb.MsgAddress
+	switch {
+	case transfer.Jetton != nil:
+		addr = transfer.Jetton.ResponseDestination
+	case transfer.NFT != nil:
+		addr = transfer.NFT.ResponseDestination
+	default:
+		return ton.AccountID{}, false, nil
+	}
+	dest, err := ton.AccountIDFromTlb(addr)
+	if err != nil {
+		return ton.AccountID{}, false, err
+	}
+	if dest == nil {
+		return ton.AccountID{}, false, nil
+	}
+	return *dest, true, nil
+}
+
+// Transfer is a jetton or an NFT transfer, only one of the fields is set.
+type Transfer struct {
+	Jetton *JettonTransferPayload
+	NFT    *NFTTransferPayload
+}
+
+// forwardPayload returns the forward payload of this transfer.
+func (t Transfer) forwardPayload() *boc.Cell {
+	var payload boc.Cell
+	if t.Jetton != nil {
+		payload = boc.Cell(t.Jetton.ForwardPayload.Value)
+	} else {
+		payload = boc.Cell(t.NFT.ForwardPayload.Value)
+	}
+	return &payload
+}
+
+// ForwardPayloadSize returns a number of bits and cells of the forward payload of a jetton or an NFT transfer
+// carried by the given message. Cells of the payload tree are counted once even if they are referenced several times.
+// A payload stored in the transfer body itself adds its bits but no cell,
+// a payload stored in a ref adds its root cell as well.
+// ErrNotTransfer is returned if the message body is neither a jetton nor an NFT transfer.
+func ForwardPayloadSize(m RawMessage) (bits int, cells int, err error) {
+	body, err := m.body()
+	if err != nil {
+		return 0, 0, err
+	}
+	transfer, err := decodeTransfer(body)
+	if err != nil {
+		return 0, 0, err
+	}
+	var payload tlb.EitherRef[tlb.Any]
+	switch {
+	case transfer.Jetton != nil:
+		payload = transfer.Jetton.ForwardPayload
+	case transfer.NFT != nil:
+		payload = transfer.NFT.ForwardPayload
+	default:
+		return 0, 0, ErrNotTransfer
+	}
+	root := boc.Cell(payload.Value)
+	if bits, cells, err = messageSize(&root); err != nil {
+		return 0, 0, err
+	}
+	bits += root.BitSize()
+	if payload.IsRight {
+		cells += 1
+	}
+	return bits, cells, nil
+}
+
+// TransferDecodeOptions configures decoding of nested transfers, see TransferChain.
+type TransferDecodeOptions struct {
+	// MaxForwardDepth is a number of nested forward payloads decoded after the transfer in the message body.
+	MaxForwardDepth int
+}
+
+type TransferDecodeOption func(o *TransferDecodeOptions)
+
+// WithMaxForwardDepth sets a number of nested forward payloads TransferChain decodes, DefaultMaxForwardDepth by default.
+func WithMaxForwardDepth(n int) TransferDecodeOption {
+	return func(o *TransferDecodeOptions) {
+		o.MaxForwardDepth = n
+	}
+}
+
+// TransferChain decodes the body of this message as a jetton or an NFT transfer
+// and then decodes its forward payload as long as the payload is a transfer too,
+// as routers of decentralized exchanges nest them.
+// The transfer in the message body comes first, each next one is taken from the forward payload of the previous one.
+// If a transfer is found deeper than the maximum forward depth, ErrForwardDepthExceeded is returned.
+// An empty chain is returned if the body is not a transfer.
+func (m RawMessage) TransferChain(opts ...TransferDecodeOption) ([]Transfer, error) {
+	options := TransferDecodeOptions{MaxForwardDepth: DefaultMaxForwardDepth}
+	for _, o := range opts {
+		o(&options)
+	}
+	body, err := m.body()
+	if err != nil {
+		return nil, err
+	}
+	var chain []Transfer
+	for depth := 0; ; depth++ {
+		transfer, err := decodeTransfer(body)
+		if err != nil {
+			return nil, fmt.Errorf("transfer %v: %w", depth, err)
+		}
+		if transfer.Jetton == nil && transfer.NFT == nil {
+			return chain, nil
+		}
+		if depth > options.MaxForwardDepth {
+			return nil, fmt.Errorf("%w: more than %v forward payloads", ErrForwardDepthExceeded, options.MaxForwardDepth)
+		}
+		chain = append(chain, transfer)
+		body = transfer.forwardPayload()
+	}
+}
+
+// body returns the body of this message.
+func (m RawMessage) body() (*boc.Cell, error) {
+	msg, err := m.ToTLBMessage()
+	if err != nil {
+		return nil, err
+	}
+	body := boc.Cell(msg.Body.Value)
+	return &body, nil
+}
+
+// decodeTransfer decodes the given body as a jetton or an NFT transfer.
+// An empty Transfer is returned if the body doesn't start with a transfer opcode.
+func decodeTransfer(body *boc.Cell) (Transfer, error) {
+	if body.BitsAvailableForRead() < 32 {
+		return Transfer{}, nil
+	}
+	op, err := body.ReadUint(32)
+	if err != nil {
+		return Transfer{}, err
+	}
+	switch op {
+	case nftTransferOpcode:
+		var payload NFTTransferPayload
+		if err := tlb.Unmarshal(body, &payload); err != nil {
+			return Transfer{}, fmt.Errorf("failed to decode nft transfer: %w", err)
+		}
+		return Transfer{NFT: &payload}, nil
+	case jettonTransferOpcode:
+		var payload JettonTransferPayload
+		if err := tlb.Unmarshal(body, &payload); err != nil {
+			return Transfer{}, fmt.Errorf("failed to decode jetton transfer: %w", err)
+		}
+		return Transfer{Jetton: &payload}, nil
+	default:
+		return Transfer{}, nil
+	}
+}
+
+// SameDestination reports whether this message and other are sent to the same account.
+// Both messages must be internal messages sent to standard addresses.
+func (m RawMessage) SameDestination(other RawMessage) (bool, error) {
+	dest, err := m.destination()
+	if err != nil {
+		return false, err
+	}
+	otherDest, err := other.destination()
+	if err != nil {
+		return false, err
+	}
+	return dest == otherDest, nil
+}
+
+// DuplicateDestinations returns groups of indexes of the given messages sent to the same account.
+// Only destinations with two or more messages are reported,
+// groups are ordered by the first message of each group and indexes in a group are ascending.
+// All messages must be internal messages sent to standard addresses.
+func DuplicateDestinations(msgs []RawMessage) ([][]int, error) {
+	groups := make(map[ton.AccountID][]int, len(msgs))
+	var order []ton.AccountID
+	for i, msg := range msgs {
+		dest, err := msg.destination()
+		if err != nil {
+			return nil, fmt.Errorf("message %v: %w", i, err)
+		}
+		if _, ok := groups[dest]; !ok {
+			order = append(order, dest)
+		}
+		groups[dest] = append(groups[dest], i)
+	}
+	var duplicates [][]int
+	for _, dest := range order {
+		if len(groups[dest]) > 1 {
+			duplicates = append(duplicates, groups[dest])
+		}
+	}
+	return duplicates, nil
+}
+
+// Destinations returns accounts the given internal messages are sent to, in the order of the messages.
+// External outbound messages are skipped.
+// If some messages can't be decoded, destinations of the rest are returned along with DecodeErrors.
+func Destinations(msgs []RawMessage) ([]ton.AccountID, error) {
+	dests := make([]ton.AccountID, 0, len(msgs))
+	var errs DecodeErrors
+	for i, msg := range msgs {
+		dest, err := msg.destination()
+		if errors.Is(err, ErrNotInternalMessage) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("message %v: %w", i, err))
+			continue
+		}
+		dests = append(dests, dest)
+	}
+	if len(errs) > 0 {
+		return dests, errs
+	}
+	return dests, nil
+}
+
+// PayloadSelfSends returns indexes of the given messages sent to walletAddr, the wallet sending them.
+// External outbound messages and messages that can't be decoded are skipped.
+func PayloadSelfSends(msgs []RawMessage, walletAddr ton.AccountID) []int {
+	var indexes []int
+	for i, msg := range msgs {
+		dest, err := msg.destination()
+		if err != nil {
+			continue
+		}
+		if dest == walletAddr {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// destination returns an account this internal message is sent to.
+func (m RawMessage) destination() (ton.AccountID, error) {
+	msg, err := m.ToTLBMessage()
+	if err != nil {
+		return ton.AccountID{}, err
+	}
+	if msg.Info.SumType != "IntMsgInfo" {
+		return ton.AccountID{}, ErrNotInternalMessage
+	}
+	dest, err := ton.AccountIDFromTlb(msg.Info.IntMsgInfo.Dest)
+	if err != nil {
+		return ton.AccountID{}, err
+	}
+	if dest == nil {
+		return ton.AccountID{}, fmt.Errorf("message has no destination")
+	}
+	return *dest, nil
+}
+
+// PayloadDiff describes how a list of messages sent by a wallet changed, see DiffPayloads.
+type PayloadDiff struct {
+	Added    []RawMessage
+	Removed  []RawMessage
+	Modified []ModifiedMessage
+}
+
+// ModifiedMessage is a message that was found in both payloads but with a different mode or content.
+type ModifiedMessage struct {
+	Old RawMessage
+	New RawMessage
+}
+
+// DiffPayloads compares two lists of messages, for example, a payload of a transaction and a payload of its re-signed replacement.
+//
+// Messages are matched regardless of their order.
+// First, messages with the same mode and the same cell hash are matched, they are considered unchanged and not reported.
+// Then, the remaining messages are matched by destination and value in the order they appear,
+// and each such pair is reported as modified because its mode or body differs.
+// Messages left unmatched are reported as removed from old or added to new.
+// A message that can't be decoded is only matched by its mode and cell hash.
+func DiffPayloads(old, new []RawMessage) PayloadDiff {
+	oldMatched := make([]bool, len(old))
+	newMatched := make([]bool, len(new))
+	oldHashes := payloadHashes(old)
+	newHashes := payloadHashes(new)
+	for i := range new {
+		for j := range old {
+			if oldMatched[j] || old[j].Mode != new[i].Mode || oldHashes[j] == "" || oldHashes[j] != newHashes[i] {
+				continue
+			}
+			oldMatched[j] = true
+			newMatched[i] = true
+			break
+		}
+	}
+	oldKeys := payloadKeys(old)
+	newKeys := payloadKeys(new)
+	var diff PayloadDiff
+	for i := range new {
+		if newMatched[i] || newKeys[i] == nil {
+			continue
+		}
+		for j := range old {
+			if oldMatched[j] || oldKeys[j] == nil || *oldKeys[j] != *newKeys[i] {
+				continue
+			}
+			oldMatched[j] = true
+			newMatched[i] = true
+			diff.Modified = append(diff.Modified, ModifiedMessage{Old: old[j], New: new[i]})
+			break
+		}
+	}
+	for j, matched := range oldMatched {
+		if !matched {
+			diff.Removed = append(diff.Removed, old[j])
+		}
+	}
+	for i, matched := range newMatched {
+		if !matched {
+			diff.Added = append(diff.Added, new[i])
+		}
+	}
+	return diff
+}
+
+type payloadKey struct {
+	destination ton.AccountID
+	value       tlb.Grams
+}
+
+func payloadHashes(msgs []RawMessage) []string {
+	hashes := make([]string, len(msgs))
+	for i, m := range msgs {
+		if m.Message == nil {
+			continue
+		}
+		if hash, err := m.Message.HashString(); err == nil {
+			hashes[i] = hash
+		}
+	}
+	return hashes
+}
+
+func payloadKeys(msgs []RawMessage) []*payloadKey {
+	keys := make([]*payloadKey, len(msgs))
+	for i, m := range msgs {
+		dest, err := m.destination()
+		if err != nil {
+			continue
+		}
+		msg, err := m.ToTLBMessage()
+		if err != nil {
+			continue
+		}
+		keys[i] = &payloadKey{
+			destination: dest,
+			value:       msg.Info.IntMsgInfo.Value.Grams,
+		}
+	}
+	return keys
+}
@@ -0,0 +1,754 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+)
+
+func mustRawMessage(t *testing.T, m Sendable) RawMessage {
+	msg, err := newRawMessage(m)
+	if err != nil {
+		t.Fatalf("newRawMessage() failed: %v", err)
+	}
+	return msg
+}
+
+func TestRawMessage_GasBudget(t *testing.T) {
+	recipient := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	tests := []struct {
+		name    string
+		mode    uint8
+		want    tlb.Grams
+		wantErr error
+	}{
+		{name: "mode 3", mode: 3, want: 1_000_000},
+		{name: "mode 0", mode: 0, want: 1_000_000},
+		{name: "mode 128", mode: 128, wantErr: ErrValueDependsOnBalance},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := mustRawMessage(t, Message{Amount: 1_000_000, Address: recipient, Mode: tt.mode})
+			got, err := msg.GasBudget()
+			if err != tt.wantErr {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDiffPayloads(t *testing.T) {
+	alice := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	bob := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	unchanged := mustRawMessage(t, Message{Amount: 1_000, Address: alice, Mode: 3})
+	toBob := mustRawMessage(t, Message{Amount: 2_000, Address: bob, Mode: 3})
+	toBobMode := mustRawMessage(t, Message{Amount: 2_000, Address: bob, Mode: 1})
+	removed := mustRawMessage(t, Message{Amount: 3_000, Address: alice, Mode: 3})
+	added := mustRawMessage(t, Message{Amount: 4_000, Address: bob, Mode: 3})
+
+	diff := DiffPayloads(
+		[]RawMessage{unchanged, toBob, removed},
+		[]RawMessage{added, toBobMode, unchanged},
+	)
+	if len(diff.Added) != 1 || diff.Added[0].Message != added.Message {
+		t.Fatalf("want one added message, got %v", len(diff.Added))
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].Message != removed.Message {
+		t.Fatalf("want one removed message, got %v", len(diff.Removed))
+	}
+	if len(diff.Modified) != 1 || diff.Modified[0].Old.Mode != 3 || diff.Modified[0].New.Mode != 1 {
+		t.Fatalf("want one modified message, got %v", diff.Modified)
+	}
+
+	diff = DiffPayloads([]RawMessage{unchanged, toBob}, []RawMessage{toBob, unchanged})
+	if len(diff.Added)+len(diff.Removed)+len(diff.Modified) != 0 {
+		t.Fatalf("reordered payloads must be equal, got %+v", diff)
+	}
+}
+
+func TestRawMessage_BodyOpcode(t *testing.T) {
+	recipient := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	comment := boc.NewCell()
+	if err := tlb.Marshal(comment, TextComment("hello")); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	transfer := boc.NewCell()
+	if err := transfer.WriteUint(0x0f8a7ea5, 32); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	tests := []struct {
+		name   string
+		body   *boc.Cell
+		want   uint32
+		wantOk bool
+	}{
+		{name: "empty body", body: nil},
+		{name: "comment", body: comment, want: 0, wantOk: true},
+		{name: "jetton transfer", body: transfer, want: 0x0f8a7ea5, wantOk: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := mustRawMessage(t, Message{Amount: 1_000, Address: recipient, Body: tt.body, Mode: 3})
+			op, ok, err := msg.BodyOpcode()
+			if err != nil {
+				t.Fatalf("BodyOpcode() failed: %v", err)
+			}
+			if op != tt.want || ok != tt.wantOk {
+				t.Fatalf("want (%#x, %v), got (%#x, %v)", tt.want, tt.wantOk, op, ok)
+			}
+		})
+	}
+}
+
+func TestRawMessage_BodyKind(t *testing.T) {
+	recipient := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	comment := boc.NewCell()
+	if err := tlb.Marshal(comment, TextComment("hello")); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	transfer := boc.NewCell()
+	if err := transfer.WriteUint(0x0f8a7ea5, 32); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	short := boc.NewCell()
+	if err := short.WriteUint(0xff, 8); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	refOnly := boc.NewCell()
+	if err := refOnly.AddRef(boc.NewCell()); err != nil {
+		t.Fatalf("AddRef() failed: %v", err)
+	}
+	tests := []struct {
+		name string
+		body *boc.Cell
+		want BodyKind
+	}{
+		{name: "empty body", body: nil, want: BodyEmpty},
+		{name: "comment", body: comment, want: BodyText},
+		{name: "jetton transfer", body: transfer, want: BodyOp},
+		{name: "short body", body: short, want: BodyBinary},
+		{name: "ref only", body: refOnly, want: BodyBinary},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := mustRawMessage(t, Message{Amount: 1_000, Address: recipient, Body: tt.body, Mode: 3})
+			kind, err := msg.BodyKind()
+			if err != nil {
+				t.Fatalf("BodyKind() failed: %v", err)
+			}
+			if kind != tt.want {
+				t.Fatalf("want %v, got %v", tt.want, kind)
+			}
+		})
+	}
+}
+
+func TestRawMessage_BodyPrefix(t *testing.T) {
+	recipient := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	body := boc.NewCell()
+	if err := body.WriteUint(0x0f8a7ea5, 32); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	if err := body.WriteUint(0xb, 4); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	tests := []struct {
+		name string
+		body *boc.Cell
+		n    int
+		want []byte
+	}{
+		{name: "empty body", body: nil, n: 4, want: []byte{}},
+		{name: "prefix", body: body, n: 2, want: []byte{0x0f, 0x8a}},
+		{name: "whole bytes", body: body, n: 4, want: []byte{0x0f, 0x8a, 0x7e, 0xa5}},
+		{name: "short body", body: body, n: 8, want: []byte{0x0f, 0x8a, 0x7e, 0xa5, 0xb0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := mustRawMessage(t, Message{Amount: 1_000, Address: recipient, Body: tt.body, Mode: 3})
+			got, err := msg.BodyPrefix(tt.n)
+			if err != nil {
+				t.Fatalf("BodyPrefix() failed: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("want %x, got %x", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMatchesOp(t *testing.T) {
+	transfer := boc.NewCell()
+	if err := transfer.WriteUint(0x0f8a7ea5, 32); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	short := boc.NewCell()
+	if err := short.WriteUint(0x0f8a, 16); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	tests := []struct {
+		name string
+		body *boc.Cell
+		op   uint32
+		want bool
+	}{
+		{name: "nil body", body: nil, op: 0},
+		{name: "short body", body: short, op: 0x0f8a7ea5},
+		{name: "matching opcode", body: transfer, op: 0x0f8a7ea5, want: true},
+		{name: "other opcode", body: transfer, op: 0x595f07bc},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MatchesOp(tt.body, tt.op)
+			if err != nil {
+				t.Fatalf("MatchesOp() failed: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+			if tt.body != nil && tt.body.BitsAvailableForRead() != tt.body.BitSize() {
+				t.Fatalf("MatchesOp() must not move the cursor")
+			}
+		})
+	}
+}
+
+func TestRawMessage_NFTTransfer(t *testing.T) {
+	item := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	newOwner := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	payload := NFTTransferPayload{
+		QueryID:             7,
+		NewOwner:            newOwner.ToMsgAddress(),
+		ResponseDestination: item.ToMsgAddress(),
+		ForwardAmount:       1,
+	}
+	body := boc.NewCell()
+	if err := body.WriteUint(0x5fcc3d14, 32); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	if err := tlb.Marshal(body, payload); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	msg := mustRawMessage(t, Message{Amount: 50_000_000, Address: item, Body: body, Mode: 3})
+	transfer, err := msg.NFTTransfer()
+	if err != nil {
+		t.Fatalf("NFTTransfer() failed: %v", err)
+	}
+	owner, err := ton.AccountIDFromTlb(transfer.NewOwner)
+	if err != nil {
+		t.Fatalf("AccountIDFromTlb() failed: %v", err)
+	}
+	if transfer.QueryID != 7 || transfer.ForwardAmount != 1 || owner == nil || *owner != newOwner {
+		t.Fatalf("unexpected transfer: %+v", transfer)
+	}
+
+	plain := mustRawMessage(t, Message{Amount: 50_000_000, Address: item, Mode: 3})
+	if _, err := plain.NFTTransfer(); err != ErrNotNFTTransfer {
+		t.Fatalf("want ErrNotNFTTransfer, got %v", err)
+	}
+}
+
+func TestRawMessage_ResponseDestination(t *testing.T) {
+	wallet := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	jettonWallet := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	jetton := func(response tlb.MsgAddress) *boc.Cell {
+		body := boc.NewCell()
+		if err := body.WriteUint(0x0f8a7ea5, 32); err != nil {
+			t.Fatalf("WriteUint() failed: %v", err)
+		}
+		payload := JettonTransferPayload{
+			QueryID:             1,
+			Amount:              tlb.VarUInteger16(*big.NewInt(1_000)),
+			Destination:         jettonWallet.ToMsgAddress(),
+			ResponseDestination: response,
+		}
+		if err := tlb.Marshal(body, payload); err != nil {
+			t.Fatalf("Marshal() failed: %v", err)
+		}
+		return body
+	}
+	// a transfer opcode followed by a query id only.
+	truncated := boc.NewCell()
+	if err := truncated.WriteUint(0x0f8a7ea5, 32); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	if err := truncated.WriteUint(1, 64); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	tests := []struct {
+		name    string
+		msg     RawMessage
+		want    ton.AccountID
+		wantOk  bool
+		wantErr bool
+	}{
+		{name: "transfer", msg: mustRawMessage(t, Message{Amount: 1_000, Address: jettonWallet, Body: jetton(wallet.ToMsgAddress()), Mode: 3}), want: wallet, wantOk: true},
+		{name: "no response destination", msg: mustRawMessage(t, Message{Amount: 1_000, Address: jettonWallet, Body: jetton(tlb.MsgAddress{SumType: "AddrNone"}), Mode: 3})},
+		{name: "empty body", msg: mustRawMessage(t, Message{Amount: 1_000, Address: jettonWallet, Mode: 3})},
+		{name: "truncated transfer", msg: mustRawMessage(t, Message{Amount: 1_000, Address: jettonWallet, Body: truncated, Mode: 3}), wantErr: true},
+		{name: "no message", msg: RawMessage{Mode: 3}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest, ok, err := tt.msg.ResponseDestination()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if dest != tt.want || ok != tt.wantOk {
+				t.Fatalf("want (%v, %v), got (%v, %v)", tt.want, tt.wantOk, dest, ok)
+			}
+		})
+	}
+}
+
+func TestRawMessage_TransferChain(t *testing.T) {
+	wallet := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	router := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	// transfer returns a body of a transfer with the given opcode and payload.
+	transfer := func(op uint32, payload any) *boc.Cell {
+		body := boc.NewCell()
+		if err := body.WriteUint(uint64(op), 32); err != nil {
+			t.Fatalf("WriteUint() failed: %v", err)
+		}
+		if err := tlb.Marshal(body, payload); err != nil {
+			t.Fatalf("Marshal() failed: %v", err)
+		}
+		return body
+	}
+	jetton := func(forward *boc.Cell) *boc.Cell {
+		payload := JettonTransferPayload{
+			QueryID:             1,
+			Amount:              tlb.VarUInteger16(*big.NewInt(1_000)),
+			Destination:         router.ToMsgAddress(),
+			ResponseDestination: wallet.ToMsgAddress(),
+		}
+		if forward != nil {
+			payload.ForwardPayload = tlb.EitherRef[tlb.Any]{IsRight: true, Value: tlb.Any(*forward)}
+		}
+		return transfer(0x0f8a7ea5, payload)
+	}
+	nft := func(forward *boc.Cell) *boc.Cell {
+		payload := NFTTransferPayload{
+			QueryID:             2,
+			NewOwner:            router.ToMsgAddress(),
+			ResponseDestination: wallet.ToMsgAddress(),
+		}
+		if forward != nil {
+			payload.ForwardPayload = tlb.EitherRef[tlb.Any]{IsRight: true, Value: tlb.Any(*forward)}
+		}
+		return transfer(0x5fcc3d14, payload)
+	}
+	msg := mustRawMessage(t, Message{Amount: 50_000_000, Address: wallet, Body: jetton(nft(jetton(nil))), Mode: 3})
+
+	single, err := msg.JettonTransfer()
+	if err != nil {
+		t.Fatalf("JettonTransfer() failed: %v", err)
+	}
+	if single.QueryID != 1 {
+		t.Fatalf("unexpected transfer: %+v", single)
+	}
+	if _, err := msg.NFTTransfer(); err != ErrNotNFTTransfer {
+		t.Fatalf("want ErrNotNFTTransfer, got %v", err)
+	}
+	chain, err := msg.TransferChain()
+	if err != nil {
+		t.Fatalf("TransferChain() failed: %v", err)
+	}
+	if len(chain) != 3 || chain[0].Jetton == nil || chain[1].NFT == nil || chain[2].Jetton == nil {
+		t.Fatalf("unexpected chain: %+v", chain)
+	}
+	if _, err := msg.TransferChain(WithMaxForwardDepth(2)); err != nil {
+		t.Fatalf("TransferChain() failed: %v", err)
+	}
+	if _, err := msg.TransferChain(WithMaxForwardDepth(1)); !errors.Is(err, ErrForwardDepthExceeded) {
+		t.Fatalf("want ErrForwardDepthExceeded, got %v", err)
+	}
+	for _, body := range []*boc.Cell{jetton(nil), nft(nil)} {
+		dest, ok, err := mustRawMessage(t, Message{Amount: 50_000_000, Address: router, Body: body, Mode: 3}).ResponseDestination()
+		if err != nil || !ok || dest != wallet {
+			t.Fatalf("want response destination %v, got %v, %v, %v", wallet, dest, ok, err)
+		}
+	}
+	plain := mustRawMessage(t, Message{Amount: 50_000_000, Address: wallet, Mode: 3})
+	if _, ok, err := plain.ResponseDestination(); err != nil || ok {
+		t.Fatalf("want no response destination, got %v, %v", ok, err)
+	}
+	if chain, err := plain.TransferChain(); err != nil || len(chain) != 0 {
+		t.Fatalf("want an empty chain, got %v, %v", chain, err)
+	}
+	if _, err := plain.JettonTransfer(); err != ErrNotJettonTransfer {
+		t.Fatalf("want ErrNotJettonTransfer, got %v", err)
+	}
+}
+
+func TestNewTransferMessage(t *testing.T) {
+	dest := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	msg, err := NewTransferMessage(dest, 1_000_000, "hello", 3, true)
+	if err != nil {
+		t.Fatalf("NewTransferMessage() failed: %v", err)
+	}
+	if msg.Mode != 3 {
+		t.Fatalf("want mode 3, got %v", msg.Mode)
+	}
+	decoded := DecodedMessage{RawMessages: []RawMessage{msg}}
+	data, err := decoded.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON() failed: %v", err)
+	}
+	want := `"messages":[{"destination":"0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512","value":"1000000","mode":3,"mode_flags":["pay_fees_separately","ignore_errors"],"comment":"hello"}]`
+	if !strings.Contains(string(data), want) {
+		t.Fatalf("unexpected message: %s", data)
+	}
+	parsed, err := msg.ToTLBMessage()
+	if err != nil {
+		t.Fatalf("ToTLBMessage() failed: %v", err)
+	}
+	if !parsed.Info.IntMsgInfo.Bounce || !parsed.Info.IntMsgInfo.IhrDisabled {
+		t.Fatalf("unexpected message flags")
+	}
+}
+
+func TestRawMessage_SameDestination(t *testing.T) {
+	alice := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	bob := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	toAlice := mustRawMessage(t, Message{Amount: 1_000, Address: alice, Mode: 3})
+	// the same account in a user-friendly form.
+	toAliceAgain := mustRawMessage(t, SimpleTransfer{Amount: 2_000, Address: ton.MustParseAccountID(alice.ToHuman(true, false)), Comment: "hi"})
+	toBob := mustRawMessage(t, Message{Amount: 1_000, Address: bob, Mode: 3})
+
+	same, err := toAlice.SameDestination(toAliceAgain)
+	if err != nil {
+		t.Fatalf("SameDestination() failed: %v", err)
+	}
+	if !same {
+		t.Fatalf("messages to the same account must have the same destination")
+	}
+	same, err = toAlice.SameDestination(toBob)
+	if err != nil {
+		t.Fatalf("SameDestination() failed: %v", err)
+	}
+	if same {
+		t.Fatalf("messages to different accounts must have different destinations")
+	}
+}
+
+func TestRawMessage_ToTLBMessage(t *testing.T) {
+	recipient := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	body := boc.NewCell()
+	if err := body.WriteUint(0x0f8a7ea5, 32); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	msg := mustRawMessage(t, Message{Amount: 1_000, Address: recipient, Body: body, Code: boc.NewCell(), Data: boc.NewCell(), Mode: 3})
+	for i := 0; i < 2; i++ {
+		parsed, err := msg.ToTLBMessage()
+		if err != nil {
+			t.Fatalf("ToTLBMessage() failed: %v", err)
+		}
+		if parsed.Info.SumType != "IntMsgInfo" || parsed.Info.IntMsgInfo.Value.Grams != 1_000 {
+			t.Fatalf("unexpected message info: %+v", parsed.Info)
+		}
+		if !parsed.Init.Exists || !parsed.Init.Value.Value.Code.Exists || !parsed.Init.Value.Value.Data.Exists {
+			t.Fatalf("message must have a state init with code and data")
+		}
+		parsedBody := boc.Cell(parsed.Body.Value)
+		if op, err := parsedBody.ReadUint(32); err != nil || op != 0x0f8a7ea5 {
+			t.Fatalf("unexpected body opcode: %#x, %v", op, err)
+		}
+	}
+	if _, err := (RawMessage{Mode: 3}).ToTLBMessage(); err == nil {
+		t.Fatalf("ToTLBMessage() must fail without a cell")
+	}
+}
+
+func TestDuplicateDestinations(t *testing.T) {
+	alice := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	bob := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	carol := ton.MustParseAccountID("-1:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	to := func(dest ton.AccountID) RawMessage {
+		return mustRawMessage(t, Message{Amount: 1_000, Address: dest, Mode: 3})
+	}
+	tests := []struct {
+		name string
+		msgs []RawMessage
+		want [][]int
+	}{
+		{name: "no messages"},
+		{name: "distinct", msgs: []RawMessage{to(alice), to(bob), to(carol)}},
+		{name: "duplicates", msgs: []RawMessage{to(bob), to(alice), to(bob), to(carol), to(alice), to(bob)}, want: [][]int{{0, 2, 5}, {1, 4}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DuplicateDestinations(tt.msgs)
+			if err != nil {
+				t.Fatalf("DuplicateDestinations() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+	if _, err := DuplicateDestinations([]RawMessage{to(alice), {Mode: 3}}); err == nil {
+		t.Fatalf("DuplicateDestinations() had to fail but it didn't")
+	}
+}
+
+func TestDestinations(t *testing.T) {
+	alice := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	bob := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	to := func(dest ton.AccountID) RawMessage {
+		return mustRawMessage(t, Message{Amount: 1_000, Address: dest, Mode: 3})
+	}
+	// ext_out_msg_info$11 with addr_none source and destination, zero lt and time, no state init and an empty body.
+	extOut := boc.NewCell()
+	for _, x := range []struct {
+		value uint64
+		bits  int
+	}{{3, 2}, {0, 2}, {0, 2}, {0, 64}, {0, 32}, {0, 1}, {0, 1}} {
+		if err := extOut.WriteUint(x.value, x.bits); err != nil {
+			t.Fatalf("WriteUint() failed: %v", err)
+		}
+	}
+	broken := boc.NewCell()
+	if err := broken.WriteUint(0xff, 8); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	dests, err := Destinations([]RawMessage{to(alice), {Message: extOut, Mode: 3}, to(bob)})
+	if err != nil {
+		t.Fatalf("Destinations() failed: %v", err)
+	}
+	if want := []ton.AccountID{alice, bob}; !reflect.DeepEqual(dests, want) {
+		t.Fatalf("want %v, got %v", want, dests)
+	}
+	dests, err = Destinations([]RawMessage{to(alice), {Message: broken, Mode: 3}, to(bob)})
+	var errs DecodeErrors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("want one decode error, got %v", err)
+	}
+	if want := []ton.AccountID{alice, bob}; !reflect.DeepEqual(dests, want) {
+		t.Fatalf("want partial result %v, got %v", want, dests)
+	}
+}
+
+func TestPayloadSelfSends(t *testing.T) {
+	wallet := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	bob := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	masterchainWallet := ton.AccountID{Workchain: -1, Address: wallet.Address}
+	to := func(dest ton.AccountID) RawMessage {
+		return mustRawMessage(t, Message{Amount: 1_000, Address: dest, Mode: 3})
+	}
+	tests := []struct {
+		name string
+		msgs []RawMessage
+		want []int
+	}{
+		{name: "no messages"},
+		{name: "no self-sends", msgs: []RawMessage{to(bob), to(masterchainWallet)}},
+		{name: "self-sends", msgs: []RawMessage{to(wallet), to(bob), {Mode: 3}, to(wallet)}, want: []int{0, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PayloadSelfSends(tt.msgs, wallet); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRawMessage_ExtraCurrencies(t *testing.T) {
+	recipient := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	intMsg, _, err := Message{Amount: 1_000, Address: recipient}.ToInternal()
+	if err != nil {
+		t.Fatalf("ToInternal() failed: %v", err)
+	}
+	intMsg.Info.IntMsgInfo.Value.Other.Dict = tlb.NewHashmapE(
+		[]tlb.Uint32{100, 239},
+		[]tlb.VarUInteger32{tlb.VarUInteger32(*big.NewInt(5_000)), tlb.VarUInteger32(*big.NewInt(42))},
+	)
+	cell := boc.NewCell()
+	if err := tlb.Marshal(cell, intMsg); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	currencies, err := RawMessage{Message: cell, Mode: 3}.ExtraCurrencies()
+	if err != nil {
+		t.Fatalf("ExtraCurrencies() failed: %v", err)
+	}
+	if len(currencies) != 2 {
+		t.Fatalf("want 2 currencies, got %v", len(currencies))
+	}
+	for id, want := range map[uint32]int64{100: 5_000, 239: 42} {
+		amount, ok := currencies[id]
+		if !ok {
+			t.Fatalf("currency %v not found", id)
+		}
+		if value := big.Int(amount); value.Int64() != want {
+			t.Fatalf("currency %v: want %v, got %v", id, want, value.String())
+		}
+	}
+	currencies, err = mustRawMessage(t, Message{Amount: 1_000, Address: recipient, Mode: 3}).ExtraCurrencies()
+	if err != nil {
+		t.Fatalf("ExtraCurrencies() failed: %v", err)
+	}
+	if len(currencies) != 0 {
+		t.Fatalf("want no currencies, got %v", currencies)
+	}
+}
+
+func TestRawMessage_Bounce(t *testing.T) {
+	recipient := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	for _, want := range []bool{false, true} {
+		msg := mustRawMessage(t, Message{Amount: 1_000, Address: recipient, Bounce: want, Mode: 3})
+		bounce, bounced, err := msg.Bounce()
+		if err != nil {
+			t.Fatalf("Bounce() failed: %v", err)
+		}
+		if bounce != want || bounced {
+			t.Fatalf("want (%v, false), got (%v, %v)", want, bounce, bounced)
+		}
+	}
+	// ext_out_msg_info$11 with addr_none source and destination, zero lt and time, no state init and an empty body.
+	extOut := boc.NewCell()
+	for _, x := range []struct {
+		value uint64
+		bits  int
+	}{{3, 2}, {0, 2}, {0, 2}, {0, 64}, {0, 32}, {0, 1}, {0, 1}} {
+		if err := extOut.WriteUint(x.value, x.bits); err != nil {
+			t.Fatalf("WriteUint() failed: %v", err)
+		}
+	}
+	if _, _, err := (RawMessage{Message: extOut}).Bounce(); err != ErrNotInternalMessage {
+		t.Fatalf("want ErrNotInternalMessage, got %v", err)
+	}
+}
+
+func TestRawMessage_DeploysContract(t *testing.T) {
+	recipient := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	code := GetCodeByVer(V4R2)
+	data := boc.NewCell()
+	if err := data.WriteUint(7, 32); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	msg := mustRawMessage(t, Message{Amount: 1_000, Address: recipient, Code: code, Data: data, Mode: 3})
+	deploys, init, err := msg.DeploysContract()
+	if err != nil {
+		t.Fatalf("DeploysContract() failed: %v", err)
+	}
+	if !deploys || init == nil || !init.Code.Exists || !init.Data.Exists {
+		t.Fatalf("want a state init with code and data, got %v %+v", deploys, init)
+	}
+	if ver, ok := VersionFromStateInit(*init); !ok || ver != V4R2 {
+		t.Fatalf("want code of %v, got %v", V4R2, ver)
+	}
+	deploys, init, err = mustRawMessage(t, Message{Amount: 1_000, Address: recipient, Mode: 3}).DeploysContract()
+	if err != nil {
+		t.Fatalf("DeploysContract() failed: %v", err)
+	}
+	if deploys || init != nil {
+		t.Fatalf("message without a state init doesn't deploy anything")
+	}
+	// ext_out_msg_info$11 with addr_none source and destination, zero lt and time, no state init and an empty body.
+	extOut := boc.NewCell()
+	for _, x := range []struct {
+		value uint64
+		bits  int
+	}{{3, 2}, {0, 2}, {0, 2}, {0, 64}, {0, 32}, {0, 1}, {0, 1}} {
+		if err := extOut.WriteUint(x.value, x.bits); err != nil {
+			t.Fatalf("WriteUint() failed: %v", err)
+		}
+	}
+	if _, _, err := (RawMessage{Message: extOut}).DeploysContract(); err != ErrNotInternalMessage {
+		t.Fatalf("want ErrNotInternalMessage, got %v", err)
+	}
+}
+
+func TestRawMessage_DestroysAccount(t *testing.T) {
+	tests := []struct {
+		mode byte
+		want bool
+	}{
+		{mode: 3},
+		{mode: 128},
+		{mode: 32},
+		{mode: 128 + 32, want: true},
+		{mode: 128 + 32 + 2, want: true},
+	}
+	for _, tt := range tests {
+		if got := (RawMessage{Mode: tt.mode}).DestroysAccount(); got != tt.want {
+			t.Fatalf("mode %v: want %v, got %v", tt.mode, tt.want, got)
+		}
+	}
+	if PayloadDestroysWallet([]RawMessage{{Mode: 3}, {Mode: 128}}) {
+		t.Fatalf("payload doesn't destroy the wallet")
+	}
+	if !PayloadDestroysWallet([]RawMessage{{Mode: 3}, {Mode: 160}}) {
+		t.Fatalf("payload destroys the wallet")
+	}
+}
+
+func TestForwardPayloadSize(t *testing.T) {
+	wallet := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	router := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	child := boc.NewCell()
+	if err := child.WriteUint(0xff, 8); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	forward := boc.NewCell()
+	if err := forward.WriteUint(0xdeadbeef, 32); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	if err := forward.AddRef(child); err != nil {
+		t.Fatalf("AddRef() failed: %v", err)
+	}
+	inline := boc.NewCell()
+	if err := inline.WriteUint(0xbeef, 16); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	tests := []struct {
+		name      string
+		payload   tlb.EitherRef[tlb.Any]
+		wantBits  int
+		wantCells int
+	}{
+		{name: "no payload", wantBits: 0, wantCells: 0},
+		{name: "payload in a ref", payload: tlb.EitherRef[tlb.Any]{IsRight: true, Value: tlb.Any(*forward)}, wantBits: 40, wantCells: 2},
+		{name: "inline payload", payload: tlb.EitherRef[tlb.Any]{Value: tlb.Any(*inline)}, wantBits: 16, wantCells: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := boc.NewCell()
+			if err := body.WriteUint(0x0f8a7ea5, 32); err != nil {
+				t.Fatalf("WriteUint() failed: %v", err)
+			}
+			if err := tlb.Marshal(body, JettonTransferPayload{
+				QueryID:             1,
+				Amount:              tlb.VarUInteger16(*big.NewInt(1_000)),
+				Destination:         router.ToMsgAddress(),
+				ResponseDestination: wallet.ToMsgAddress(),
+				ForwardPayload:      tt.payload,
+			}); err != nil {
+				t.Fatalf("Marshal() failed: %v", err)
+			}
+			msg := mustRawMessage(t, Message{Amount: 50_000_000, Address: wallet, Body: body, Mode: 3})
+			bits, cells, err := ForwardPayloadSize(msg)
+			if err != nil {
+				t.Fatalf("ForwardPayloadSize() failed: %v", err)
+			}
+			if bits != tt.wantBits || cells != tt.wantCells {
+				t.Fatalf("want (%v, %v), got (%v, %v)", tt.wantBits, tt.wantCells, bits, cells)
+			}
+		})
+	}
+	plain := mustRawMessage(t, Message{Amount: 50_000_000, Address: wallet, Mode: 3})
+	if _, _, err := ForwardPayloadSize(plain); err != ErrNotTransfer {
+		t.Fatalf("want ErrNotTransfer, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package wallet
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+)
+
+// SeenMessages remembers external messages sent to wallets to reject the ones that were already forwarded.
+// It keeps up to a given number of most recently seen messages, each of them for a given time.
+//
+// Messages are identified by the hash of their body, because the body is what a wallet signs.
+// Anyone can wrap the same body into another external message with a different StateInit or import fee,
+// and such messages are considered the same.
+//
+// SeenMessages is safe for concurrent use.
+type SeenMessages struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	items    map[ton.Bits256]*list.Element
+	order    *list.List
+	now      func() time.Time
+}
+
+type seenMessage struct {
+	hash      ton.Bits256
+	expiresAt time.Time
+}
+
+// NewSeenMessages returns a SeenMessages keeping up to capacity messages, each of them for ttl.
+// Zero ttl means that messages are forgotten only when the capacity is exceeded.
+func NewSeenMessages(capacity int, ttl time.Duration) *SeenMessages {
+	return &SeenMessages{
+		capacity: capacity,
+		ttl:      ttl,
+		items:    make(map[ton.Bits256]*list.Element, capacity),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+// Seen reports whether the given external message was already seen and remembers it otherwise.
+// The message is read from the beginning, so it can be decoded before.
+func (s *SeenMessages) Seen(msg *boc.Cell) (seen bool, err error) {
+	msg.ResetCounters()
+	var m tlb.Message
+	if err := tlb.Unmarshal(msg, &m); err != nil {
+		return false, err
+	}
+	body := boc.Cell(m.Body.Value)
+	if m.Body.IsRight {
+		// a body stored in a ref is the whole ref cell.
+		body.ResetCounters()
+	}
+	h, err := body.CopyRemaining().Hash256()
+	if err != nil {
+		return false, err
+	}
+	hash := ton.Bits256(h)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if item, ok := s.items[hash]; ok {
+		seenMsg := item.Value.(*seenMessage)
+		if s.ttl == 0 || now.Before(seenMsg.expiresAt) {
+			s.order.MoveToFront(item)
+			return true, nil
+		}
+		s.order.Remove(item)
+		delete(s.items, hash)
+	}
+	for s.order.Len() > 0 && s.order.Len() >= s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.items, oldest.Value.(*seenMessage).hash)
+	}
+	if s.capacity <= 0 {
+		return false, nil
+	}
+	s.items[hash] = s.order.PushFront(&seenMessage{hash: hash, expiresAt: now.Add(s.ttl)})
+	return false, nil
+}
@@ -0,0 +1,93 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+)
+
+func TestSeenMessages(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	address := ton.MustParseAccountID("0:533f30de5722157b8471f5503b9fc5800c8d8397e79743f796b11e609adae69f")
+	newMsg := func(seqno uint32) *boc.Cell {
+		cell, err := AssembleExternalMessage(V4R2, privateKey, MessageHeader{Address: address, Seqno: seqno}, nil)
+		if err != nil {
+			t.Fatalf("AssembleExternalMessage() failed: %v", err)
+		}
+		return cell
+	}
+	now := time.Unix(1_700_000_000, 0)
+	seen := NewSeenMessages(2, time.Minute)
+	seen.now = func() time.Time { return now }
+
+	check := func(msg *boc.Cell, want bool) {
+		t.Helper()
+		msg.ResetCounters()
+		got, err := seen.Seen(msg)
+		if err != nil {
+			t.Fatalf("Seen() failed: %v", err)
+		}
+		if got != want {
+			t.Fatalf("want seen %v, got %v", want, got)
+		}
+	}
+	first, second, third := newMsg(1), newMsg(2), newMsg(3)
+	check(first, false)
+	check(first, true)
+
+	// the same body with a StateInit attached is the same message.
+	var m tlb.Message
+	first.ResetCounters()
+	if err := tlb.Unmarshal(first, &m); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	body := boc.Cell(m.Body.Value)
+	withInit, err := ton.CreateExternalMessage(address, body.CopyRemaining(), &tlb.StateInit{}, 0)
+	if err != nil {
+		t.Fatalf("CreateExternalMessage() failed: %v", err)
+	}
+	withInitCell := boc.NewCell()
+	if err := tlb.Marshal(withInitCell, withInit); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	check(withInitCell, true)
+
+	check(second, false)
+	check(third, false)
+	check(second, true)
+	check(first, false)
+
+	now = now.Add(2 * time.Minute)
+	check(first, false)
+
+	// messages that were already read are recognized.
+	seen = NewSeenMessages(2, time.Minute)
+	seen.now = func() time.Time { return now }
+	for i, tt := range []struct {
+		msg  *boc.Cell
+		want bool
+	}{{msg: withInitCell}, {msg: withInitCell, want: true}, {msg: first, want: true}} {
+		tt.msg.ResetCounters()
+		if _, err := DecodeMessage(V4R2, tt.msg); err != nil {
+			t.Fatalf("DecodeMessage() failed: %v", err)
+		}
+		for _, ref := range tt.msg.Refs() {
+			if _, err := ref.ReadBits(ref.BitsAvailableForRead()); err != nil {
+				t.Fatalf("ReadBits() failed: %v", err)
+			}
+		}
+		got, err := seen.Seen(tt.msg)
+		if err != nil {
+			t.Fatalf("Seen() failed: %v", err)
+		}
+		if got != tt.want {
+			t.Fatalf("message %v: want seen %v, got %v", i, tt.want, got)
+		}
+	}
+}
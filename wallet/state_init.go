@@ -0,0 +1,117 @@
+package wallet
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+)
+
+var (
+	ErrNoStateInitData = errors.New("state init has no data")
+	// ErrNoStateInit is returned by StateInitHashes if a message doesn't carry a StateInit.
+	ErrNoStateInit = errors.New("message has no state init")
+)
+
+// PublicKeyFromV5StateInit returns a public key stored in the data of a wallet v5 StateInit.
+// It can be used to verify a deploy message against the key embedded in the message itself.
+func PublicKeyFromV5StateInit(init tlb.StateInit) (ed25519.PublicKey, error) {
+	if !init.Data.Exists {
+		return nil, ErrNoStateInitData
+	}
+	dataCell := init.Data.Value.Value
+	dataCell.ResetCounters()
+	var data DataV5
+	if err := tlb.Unmarshal(&dataCell, &data); err != nil {
+		return nil, err
+	}
+	return ed25519.PublicKey(data.PublicKey[:]), nil
+}
+
+// IsDeployMessage reports whether the given message carries a StateInit.
+// Only the message info header is decoded, the body is not touched.
+func IsDeployMessage(msg *boc.Cell) (bool, error) {
+	cell := *msg
+	cell.ResetCounters()
+	var info tlb.CommonMsgInfo
+	if err := tlb.Unmarshal(&cell, &info); err != nil {
+		return false, err
+	}
+	return cell.ReadBit()
+}
+
+// StateInitHashes returns hashes of the code and the data of a StateInit carried by the given message.
+// The hashes are representation hashes of the cells as they are stored in the message,
+// a library cell is not resolved. A zero hash is returned for a missing code or data.
+// ErrNoStateInit is returned if the message doesn't carry a StateInit.
+func StateInitHashes(msg *boc.Cell) (codeHash, dataHash tlb.Bits256, err error) {
+	cell := *msg
+	cell.ResetCounters()
+	var m tlb.Message
+	if err := tlb.Unmarshal(&cell, &m); err != nil {
+		return tlb.Bits256{}, tlb.Bits256{}, err
+	}
+	if !m.Init.Exists {
+		return tlb.Bits256{}, tlb.Bits256{}, ErrNoStateInit
+	}
+	init := m.Init.Value.Value
+	if init.Code.Exists {
+		code := init.Code.Value.Value
+		hash, err := code.Hash256()
+		if err != nil {
+			return tlb.Bits256{}, tlb.Bits256{}, err
+		}
+		codeHash = tlb.Bits256(hash)
+	}
+	if init.Data.Exists {
+		data := init.Data.Value.Value
+		hash, err := data.Hash256()
+		if err != nil {
+			return tlb.Bits256{}, tlb.Bits256{}, err
+		}
+		dataHash = tlb.Bits256(hash)
+	}
+	return codeHash, dataHash, nil
+}
+
+// VersionFromStateInit returns a version of a wallet by the code of the given StateInit.
+// The code is looked up by its own hash first, as wallets whose standard code is a library cell are registered this way.
+// A library cell whose own hash is unknown is then looked up by the hash of the library code it refers to,
+// so the version is found without resolving the library.
+// It returns (0, false) if the StateInit has no code or the code is unknown.
+func VersionFromStateInit(init tlb.StateInit) (Version, bool) {
+	if !init.Code.Exists {
+		return 0, false
+	}
+	code := init.Code.Value.Value
+	hash, err := code.Hash256()
+	if err != nil {
+		return 0, false
+	}
+	if ver, ok := GetVerByCodeHash(tlb.Bits256(hash)); ok {
+		return ver, true
+	}
+	if code.CellType() != boc.LibraryCell {
+		return 0, false
+	}
+	libHash, err := libraryCodeHash(&code)
+	if err != nil {
+		return 0, false
+	}
+	return GetVerByCodeHash(libHash)
+}
+
+// libraryCodeHash returns the hash of the library code stored in the given library cell after an 8-bit type tag.
+func libraryCodeHash(code *boc.Cell) (tlb.Bits256, error) {
+	c := *code
+	c.ResetCounters()
+	if _, err := c.ReadUint(8); err != nil {
+		return tlb.Bits256{}, err
+	}
+	var hash tlb.Bits256
+	if err := tlb.Unmarshal(&c, &hash); err != nil {
+		return tlb.Bits256{}, err
+	}
+	return hash, nil
+}
@@ -0,0 +1,170 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+)
+
+func TestPublicKeyFromV5StateInit(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	data := DataV5{
+		WalletID: WalletV5ID{
+			NetworkGlobalID: 0xffffff11,
+			SubWalletID:     0,
+		},
+	}
+	copy(data.PublicKey[:], publicKey)
+	dataCell := boc.NewCell()
+	if err := tlb.Marshal(dataCell, data); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	init := tlb.StateInit{
+		Code: tlb.Maybe[tlb.Ref[boc.Cell]]{Exists: true, Value: tlb.Ref[boc.Cell]{Value: *GetCodeByVer(V5R1)}},
+		Data: tlb.Maybe[tlb.Ref[boc.Cell]]{Exists: true, Value: tlb.Ref[boc.Cell]{Value: *dataCell}},
+	}
+	got, err := PublicKeyFromV5StateInit(init)
+	if err != nil {
+		t.Fatalf("PublicKeyFromV5StateInit() failed: %v", err)
+	}
+	if !bytes.Equal(got, publicKey) {
+		t.Fatalf("want public key %x, got %x", publicKey, got)
+	}
+	if _, err := PublicKeyFromV5StateInit(tlb.StateInit{}); err != ErrNoStateInitData {
+		t.Fatalf("want ErrNoStateInitData, got %v", err)
+	}
+}
+
+func TestIsDeployMessage(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	w, err := New(privateKey, V4R2, 0, nil, nil)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	init, err := w.getInit()
+	if err != nil {
+		t.Fatalf("getInit() failed: %v", err)
+	}
+	tests := []struct {
+		name string
+		init *tlb.StateInit
+		want bool
+	}{
+		{name: "with state init", init: &init, want: true},
+		{name: "without state init", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := ton.CreateExternalMessage(w.GetAddress(), boc.NewCell(), tt.init, 0)
+			if err != nil {
+				t.Fatalf("CreateExternalMessage() failed: %v", err)
+			}
+			cell := boc.NewCell()
+			if err := tlb.Marshal(cell, msg); err != nil {
+				t.Fatalf("Marshal() failed: %v", err)
+			}
+			got, err := IsDeployMessage(cell)
+			if err != nil {
+				t.Fatalf("IsDeployMessage() failed: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestVersionFromStateInit(t *testing.T) {
+	// a library cell holds its type tag 2 followed by the hash of the library code,
+	// here it is the code of a wallet v4 stored in a library.
+	codeHash := GetCodeHashByVer(V4R2)
+	library := boc.NewCellExotic(boc.LibraryCell)
+	if err := library.WriteUint(2, 8); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	if err := library.WriteBytes(codeHash[:]); err != nil {
+		t.Fatalf("WriteBytes() failed: %v", err)
+	}
+	unknown := boc.NewCell()
+	if err := unknown.WriteUint(0xdeadbeef, 32); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	withCode := func(code *boc.Cell) tlb.StateInit {
+		return tlb.StateInit{Code: tlb.Maybe[tlb.Ref[boc.Cell]]{Exists: true, Value: tlb.Ref[boc.Cell]{Value: *code}}}
+	}
+	tests := []struct {
+		name   string
+		init   tlb.StateInit
+		want   Version
+		wantOk bool
+	}{
+		{name: "code", init: withCode(GetCodeByVer(V4R2)), want: V4R2, wantOk: true},
+		{name: "standard v5 code", init: withCode(GetCodeByVer(V5R1)), want: V5R1, wantOk: true},
+		{name: "library", init: withCode(library), want: V4R2, wantOk: true},
+		{name: "unknown code", init: withCode(unknown)},
+		{name: "no code"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ver, ok := VersionFromStateInit(tt.init)
+			if ok != tt.wantOk || ver != tt.want {
+				t.Fatalf("want (%v, %v), got (%v, %v)", tt.want, tt.wantOk, ver, ok)
+			}
+		})
+	}
+}
+
+func TestStateInitHashes(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	w, err := New(privateKey, V4R2, 0, nil, nil)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	init, err := w.getInit()
+	if err != nil {
+		t.Fatalf("getInit() failed: %v", err)
+	}
+	newMessage := func(init *tlb.StateInit) *boc.Cell {
+		msg, err := ton.CreateExternalMessage(w.GetAddress(), boc.NewCell(), init, 0)
+		if err != nil {
+			t.Fatalf("CreateExternalMessage() failed: %v", err)
+		}
+		cell := boc.NewCell()
+		if err := tlb.Marshal(cell, msg); err != nil {
+			t.Fatalf("Marshal() failed: %v", err)
+		}
+		return cell
+	}
+	codeHash, dataHash, err := StateInitHashes(newMessage(&init))
+	if err != nil {
+		t.Fatalf("StateInitHashes() failed: %v", err)
+	}
+	if codeHash != GetCodeHashByVer(V4R2) {
+		t.Fatalf("unexpected code hash: %x", codeHash)
+	}
+	data := init.Data.Value.Value
+	wantDataHash, err := data.Hash256()
+	if err != nil {
+		t.Fatalf("Hash256() failed: %v", err)
+	}
+	if dataHash != tlb.Bits256(wantDataHash) {
+		t.Fatalf("unexpected data hash: %x", dataHash)
+	}
+	if _, _, err := StateInitHashes(newMessage(nil)); err != ErrNoStateInit {
+		t.Fatalf("want ErrNoStateInit, got %v", err)
+	}
+}
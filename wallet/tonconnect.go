@@ -0,0 +1,104 @@
+package wallet
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+)
+
+// TonConnectMessage is a message of a TON Connect sendTransaction request.
+type TonConnectMessage struct {
+	// Address is a destination in the raw or user-friendly form.
+	Address string `json:"address"`
+	// Amount is a number of nanotons to send as a decimal string.
+	Amount string `json:"amount"`
+	// Payload is an optional base64-encoded BOC with the body of the message.
+	Payload string `json:"payload,omitempty"`
+	// StateInit is an optional base64-encoded BOC with the state init of the destination.
+	StateInit string `json:"stateInit,omitempty"`
+}
+
+// RawMessagesFromTonConnect builds messages a wallet sends to fulfil a TON Connect sendTransaction request.
+//
+// A message is bounceable unless its address is a non-bounceable user-friendly address,
+// addresses in the raw form are treated as bounceable.
+// All messages are sent with DefaultMessageMode.
+func RawMessagesFromTonConnect(items []TonConnectMessage) ([]RawMessage, error) {
+	msgs := make([]RawMessage, 0, len(items))
+	for i, item := range items {
+		msg, err := rawMessageFromTonConnect(item)
+		if err != nil {
+			return nil, fmt.Errorf("message %v: %w", i, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+func rawMessageFromTonConnect(item TonConnectMessage) (RawMessage, error) {
+	dest, bounce, err := parseTonConnectAddress(item.Address)
+	if err != nil {
+		return RawMessage{}, err
+	}
+	amount, err := strconv.ParseUint(item.Amount, 10, 64)
+	if err != nil {
+		return RawMessage{}, fmt.Errorf("invalid amount %q: %w", item.Amount, err)
+	}
+	m := Message{
+		Amount:  tlb.Grams(amount),
+		Address: dest,
+		Bounce:  bounce,
+		Mode:    DefaultMessageMode,
+	}
+	if item.Payload != "" {
+		if m.Body, err = boc.DeserializeSinglRootBase64(item.Payload); err != nil {
+			return RawMessage{}, fmt.Errorf("invalid payload: %w", err)
+		}
+	}
+	intMsg, mode, err := m.ToInternal()
+	if err != nil {
+		return RawMessage{}, err
+	}
+	if item.StateInit != "" {
+		cell, err := boc.DeserializeSinglRootBase64(item.StateInit)
+		if err != nil {
+			return RawMessage{}, fmt.Errorf("invalid state init: %w", err)
+		}
+		var stateInit tlb.StateInit
+		if err := tlb.Unmarshal(cell, &stateInit); err != nil {
+			return RawMessage{}, fmt.Errorf("invalid state init: %w", err)
+		}
+		intMsg.Init.Exists = true
+		intMsg.Init.Value.IsRight = true
+		intMsg.Init.Value.Value = stateInit
+	}
+	cell := boc.NewCell()
+	if err := tlb.Marshal(cell, intMsg); err != nil {
+		return RawMessage{}, err
+	}
+	return RawMessage{Message: cell, Mode: mode}, nil
+}
+
+// parseTonConnectAddress parses an address and returns the bounce flag of a user-friendly address.
+func parseTonConnectAddress(s string) (ton.AccountID, bool, error) {
+	if dest, err := ton.AccountIDFromRaw(s); err == nil {
+		return dest, true, nil
+	}
+	dest, err := ton.AccountIDFromBase64Url(s)
+	if err != nil {
+		return ton.AccountID{}, false, fmt.Errorf("invalid address %q: %w", s, err)
+	}
+	// AccountIDFromBase64Url has already checked the length and the checksum.
+	data, err := base64.URLEncoding.DecodeString(strings.NewReplacer("+", "-", "/", "_").Replace(s))
+	if err != nil {
+		return ton.AccountID{}, false, err
+	}
+	// the tag is 0x11 for bounceable and 0x51 for non-bounceable addresses, 0x80 is added for testnet ones.
+	bounce := data[0]&0x40 == 0
+	return dest, bounce, nil
+}
@@ -0,0 +1,90 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+)
+
+func TestRawMessagesFromTonConnect(t *testing.T) {
+	recipient := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	comment := boc.NewCell()
+	if err := tlb.Marshal(comment, TextComment("hello")); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	payload, err := comment.ToBocBase64()
+	if err != nil {
+		t.Fatalf("ToBocBase64() failed: %v", err)
+	}
+	code := boc.NewCell()
+	if err := code.WriteUint(0xdeadbeef, 32); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	stateInitCell := boc.NewCell()
+	stateInit := tlb.StateInit{Code: tlb.Maybe[tlb.Ref[boc.Cell]]{Exists: true, Value: tlb.Ref[boc.Cell]{Value: *code}}}
+	if err := tlb.Marshal(stateInitCell, stateInit); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	stateInitBoc, err := stateInitCell.ToBocBase64()
+	if err != nil {
+		t.Fatalf("ToBocBase64() failed: %v", err)
+	}
+	tests := []struct {
+		name          string
+		item          TonConnectMessage
+		wantBounce    bool
+		wantComment   bool
+		wantStateInit bool
+	}{
+		{name: "raw address", item: TonConnectMessage{Address: recipient.ToRaw(), Amount: "1000"}, wantBounce: true},
+		{name: "bounceable address", item: TonConnectMessage{Address: recipient.ToHuman(true, false), Amount: "1000"}, wantBounce: true},
+		{name: "non-bounceable address", item: TonConnectMessage{Address: recipient.ToHuman(false, false), Amount: "1000"}},
+		{name: "testnet non-bounceable address", item: TonConnectMessage{Address: recipient.ToHuman(false, true), Amount: "1000"}},
+		{name: "payload", item: TonConnectMessage{Address: recipient.ToRaw(), Amount: "1000", Payload: payload}, wantBounce: true, wantComment: true},
+		{name: "state init", item: TonConnectMessage{Address: recipient.ToHuman(false, false), Amount: "1000", StateInit: stateInitBoc}, wantStateInit: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs, err := RawMessagesFromTonConnect([]TonConnectMessage{tt.item})
+			if err != nil {
+				t.Fatalf("RawMessagesFromTonConnect() failed: %v", err)
+			}
+			if len(msgs) != 1 || msgs[0].Mode != DefaultMessageMode {
+				t.Fatalf("unexpected messages: %v", msgs)
+			}
+			msg, err := msgs[0].ToTLBMessage()
+			if err != nil {
+				t.Fatalf("ToTLBMessage() failed: %v", err)
+			}
+			info := msg.Info.IntMsgInfo
+			dest, err := ton.AccountIDFromTlb(info.Dest)
+			if err != nil || dest == nil || *dest != recipient {
+				t.Fatalf("unexpected destination: %v, %v", dest, err)
+			}
+			if info.Value.Grams != 1000 || info.Bounce != tt.wantBounce {
+				t.Fatalf("unexpected message info: value %v, bounce %v", info.Value.Grams, info.Bounce)
+			}
+			body := boc.Cell(msg.Body.Value)
+			if hasBody := body.BitsAvailableForRead() > 0; hasBody != tt.wantComment {
+				t.Fatalf("want body %v, got %v", tt.wantComment, hasBody)
+			}
+			if msg.Init.Exists != tt.wantStateInit {
+				t.Fatalf("want state init %v, got %v", tt.wantStateInit, msg.Init.Exists)
+			}
+			if tt.wantStateInit && !msg.Init.Value.Value.Code.Exists {
+				t.Fatalf("state init must have code")
+			}
+		})
+	}
+	for _, item := range []TonConnectMessage{
+		{Address: "not an address", Amount: "1000"},
+		{Address: recipient.ToRaw(), Amount: "-1"},
+		{Address: recipient.ToRaw(), Amount: "1000", Payload: "not a boc"},
+	} {
+		if _, err := RawMessagesFromTonConnect([]TonConnectMessage{item}); err == nil {
+			t.Fatalf("RawMessagesFromTonConnect(%+v) had to fail but it didn't", item)
+		}
+	}
+}
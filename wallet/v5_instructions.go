@@ -0,0 +1,249 @@
+package wallet
+
+import (
+	"fmt"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+)
+
+// maxV5Instructions is a maximum number of actions a c5 out list can hold.
+const maxV5Instructions = 255
+
+// V5Instruction is an action a wallet v5 performs: either an action of an out list the wallet installs into c5
+// or an extended action the wallet executes itself, see V5ExtendedAction.
+//
+//	action_send_msg#0ec3c86d mode:(## 8) out_msg:^(MessageRelaxed Any) = OutAction;
+//	action_set_code#ad4de08e new_code:^Cell = OutAction;
+//	action_reserve_currency#36e6b809 mode:(## 8) currency:CurrencyCollection = OutAction;
+//	action_change_library#26fa1dd4 mode:(## 7) libref:LibRef = OutAction;
+type V5Instruction struct {
+	tlb.SumType
+	SendMsg struct {
+		Mode uint8
+		Msg  *boc.Cell `tlb:"^"`
+	} `tlbSumType:"action_send_msg#0ec3c86d"`
+	SetCode struct {
+		NewCode *boc.Cell `tlb:"^"`
+	} `tlbSumType:"action_set_code#ad4de08e"`
+	ReserveCurrency struct {
+		Mode     uint8
+		Currency tlb.CurrencyCollection
+	} `tlbSumType:"action_reserve_currency#36e6b809"`
+	ChangeLibrary struct {
+		Mode   tlb.Uint7
+		LibRef V5LibRef
+	} `tlbSumType:"action_change_library#26fa1dd4"`
+	AddExtension struct {
+		Addr tlb.MsgAddress
+	} `tlbSumType:"action_add_ext#1c40db9f"`
+	DeleteExtension struct {
+		Addr tlb.MsgAddress
+	} `tlbSumType:"action_delete_ext#5eaef4a4"`
+	SetSignatureAuthAllowed struct {
+		Allowed bool
+	} `tlbSumType:"action_set_signature_auth_allowed#20cbb95a"`
+}
+
+// V5LibRef identifies a library changed by V5Instruction.ChangeLibrary.
+//
+//	libref_hash$0 lib_hash:bits256 = LibRef;
+//	libref_ref$1 library:^Cell = LibRef;
+type V5LibRef struct {
+	tlb.SumType
+	Hash struct {
+		LibHash tlb.Bits256
+	} `tlbSumType:"libref_hash$0"`
+	Ref struct {
+		Library *boc.Cell `tlb:"^"`
+	} `tlbSumType:"libref_ref$1"`
+}
+
+// V5ExtendedAction is an action of an extended action list of a wallet v5 request, see decodeV5ActionList.
+//
+//	action_add_ext#1c40db9f addr:MsgAddressInt = ExtendedAction;
+//	action_delete_ext#5eaef4a4 addr:MsgAddressInt = ExtendedAction;
+//	action_set_signature_auth_allowed#20cbb95a allowed:(## 1) = ExtendedAction;
+type V5ExtendedAction struct {
+	tlb.SumType
+	AddExtension struct {
+		Addr tlb.MsgAddress
+	} `tlbSumType:"action_add_ext#1c40db9f"`
+	DeleteExtension struct {
+		Addr tlb.MsgAddress
+	} `tlbSumType:"action_delete_ext#5eaef4a4"`
+	SetSignatureAuthAllowed struct {
+		Allowed bool
+	} `tlbSumType:"action_set_signature_auth_allowed#20cbb95a"`
+}
+
+// decodeV5ActionList decodes an action list of a wallet v5 request and returns its tag, which is the Op bit of the request.
+//
+//	action_list_basic$0 {n:#} actions:^(OutList n) = ActionList n 0;
+//	action_list_extended$1 {m:#} {n:#} prev:^(ActionList n m) action:ExtendedAction = ActionList n (m+1);
+//
+// An extended node holds its action inline after the tag and refers to the rest of the list,
+// which is another tagged node. Extended actions are returned in the order the wallet executes them,
+// starting from the root node, and the out list of the basic node is executed after all of them.
+// A basic node without a ref is decoded as an empty out list,
+// so a request of an extension that only changes extensions can omit it.
+func decodeV5ActionList(c *boc.Cell, decoder *tlb.Decoder) (bool, SendMessageList, error) {
+	op, err := c.ReadBit()
+	if err != nil {
+		return false, SendMessageList{}, err
+	}
+	var list SendMessageList
+	extended := op
+	for extended {
+		var action V5ExtendedAction
+		if err := decoder.Unmarshal(c, &action); err != nil {
+			return false, SendMessageList{}, fmt.Errorf("failed to decode extended action %v: %w", len(list.Extended), err)
+		}
+		list.Extended = append(list.Extended, action)
+		if c, err = c.NextRef(); err != nil {
+			return false, SendMessageList{}, err
+		}
+		if extended, err = c.ReadBit(); err != nil {
+			return false, SendMessageList{}, err
+		}
+	}
+	if c.RefsAvailableForRead() == 0 {
+		// the ref to the out list is treated as a maybe ref, a missing one means no send actions.
+		return op, list, nil
+	}
+	actions, err := c.NextRef()
+	if err != nil {
+		return false, SendMessageList{}, err
+	}
+	var basic SendMessageList
+	if err := decoder.Unmarshal(actions, &basic); err != nil {
+		return false, SendMessageList{}, err
+	}
+	list.Actions = basic.Actions
+	return op, list, nil
+}
+
+// encodeV5ActionList encodes the given actions as an action list of a wallet v5 request, see decodeV5ActionList.
+// op is the tag of the list, it is true if and only if the list has extended actions.
+func encodeV5ActionList(c *boc.Cell, op bool, list SendMessageList, encoder *tlb.Encoder) error {
+	if op != (len(list.Extended) > 0) {
+		return fmt.Errorf("op %v doesn't match %v extended actions", op, len(list.Extended))
+	}
+	if err := c.WriteBit(op); err != nil {
+		return err
+	}
+	if !op {
+		actions := boc.NewCell()
+		if err := encoder.Marshal(actions, list); err != nil {
+			return err
+		}
+		return c.AddRef(actions)
+	}
+	if err := encoder.Marshal(c, list.Extended[0]); err != nil {
+		return err
+	}
+	rest := SendMessageList{Actions: list.Actions, Extended: list.Extended[1:]}
+	prev := boc.NewCell()
+	if err := encodeV5ActionList(prev, len(rest.Extended) > 0, rest, encoder); err != nil {
+		return err
+	}
+	return c.AddRef(prev)
+}
+
+// SigAuthChange is a change of whether a wallet v5 accepts requests authenticated by a signature,
+// see MessageV5.SignatureAuthChanges.
+type SigAuthChange struct {
+	// Allowed is false if the wallet rejects signed requests after this message,
+	// so only its extensions can use it.
+	Allowed bool
+	// QueryID is the query id of the request of an extension, signed requests have no query id.
+	QueryID uint64
+}
+
+// SignatureAuthChanges returns the set_signature_auth_allowed action of this message, if any.
+// If there are several ones, the last one is returned because it takes effect.
+func (m *MessageV5) SignatureAuthChanges() (*SigAuthChange, bool) {
+	actions, ok := m.actions()
+	if !ok {
+		return nil, false
+	}
+	var change *SigAuthChange
+	for _, action := range actions.Extended {
+		if action.SumType != "SetSignatureAuthAllowed" {
+			continue
+		}
+		change = &SigAuthChange{Allowed: action.SetSignatureAuthAllowed.Allowed}
+		if m.SumType == "Extn" {
+			change.QueryID = m.Extn.QueryID
+		}
+	}
+	return change, change != nil
+}
+
+// Instructions returns the actions of this message in the order the wallet executes them:
+// extended actions of the action list go first, the wallet executes them while processing the request,
+// and the send_msg actions of the out list follow, they are performed in the action phase.
+// Only send_msg actions can be stored in the out list of a request decoded by DecodeMessageV5.
+func (m *MessageV5) Instructions() ([]V5Instruction, error) {
+	actions, ok := m.actions()
+	if !ok {
+		return nil, fmt.Errorf("unknown v5 message type: %v", m.SumType)
+	}
+	instructions := make([]V5Instruction, 0, len(actions.Extended)+len(actions.Actions))
+	for _, action := range actions.Extended {
+		var instruction V5Instruction
+		instruction.SumType = action.SumType
+		switch action.SumType {
+		case "AddExtension":
+			instruction.AddExtension = action.AddExtension
+		case "DeleteExtension":
+			instruction.DeleteExtension = action.DeleteExtension
+		case "SetSignatureAuthAllowed":
+			instruction.SetSignatureAuthAllowed = action.SetSignatureAuthAllowed
+		default:
+			return nil, fmt.Errorf("unknown extended action: %v", action.SumType)
+		}
+		instructions = append(instructions, instruction)
+	}
+	for _, action := range actions.Actions {
+		var instruction V5Instruction
+		instruction.SumType = "SendMsg"
+		instruction.SendMsg.Mode = action.Mode
+		instruction.SendMsg.Msg = action.Msg
+		instructions = append(instructions, instruction)
+	}
+	return instructions, nil
+}
+
+// DecodeV5Instructions decodes the given c5 out list and returns its actions in the order they are executed.
+// The root cell of the list holds the last action, and its first ref points to the rest of the list.
+// Unlike Instructions, it accepts any out action, so it can decode the c5 register a wallet v5 leaves
+// after its compute phase, as emulators and transaction traces report it.
+// Extended actions are rejected because the wallet executes them itself and never stores them in c5.
+func DecodeV5Instructions(actions *boc.Cell) ([]V5Instruction, error) {
+	var instructions []V5Instruction
+	node := actions
+	for node.BitsAvailableForRead() > 0 || node.RefsAvailableForRead() > 0 {
+		if len(instructions) == maxV5Instructions {
+			return nil, fmt.Errorf("out list has more than %v actions", maxV5Instructions)
+		}
+		prev, err := node.NextRef()
+		if err != nil {
+			return nil, err
+		}
+		var instruction V5Instruction
+		if err := tlb.Unmarshal(node, &instruction); err != nil {
+			return nil, fmt.Errorf("failed to decode action %v from the end: %w", len(instructions), err)
+		}
+		switch instruction.SumType {
+		case "AddExtension", "DeleteExtension", "SetSignatureAuthAllowed":
+			return nil, fmt.Errorf("extended action %v can't be stored in an out list", instruction.SumType)
+		}
+		instructions = append(instructions, instruction)
+		node = prev
+	}
+	for i, j := 0, len(instructions)-1; i < j; i, j = i+1, j-1 {
+		instructions[i], instructions[j] = instructions[j], instructions[i]
+	}
+	return instructions, nil
+}
@@ -0,0 +1,97 @@
+package wallet
+
+import (
+	"crypto/ed25519"
+	"fmt"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+)
+
+const (
+	// maxMsgBits and maxMsgCells are default size limits of a message, see config param 43.
+	maxMsgBits  = 1 << 21
+	maxMsgCells = 1 << 13
+)
+
+// ValidateExternalMessage checks the given external message sent to a wallet of the given version before it is broadcast.
+// It verifies the signature with the given public key, checks that the message isn't expired at now
+// unless it is a request of a wallet v5 extension, which has no valid-until time,
+// that the modes of all messages are valid, that the wallet can send that many messages
+// and that the message fits default size limits of the network.
+// All problems found are returned, nil means that the message passed all checks.
+// If the message can't be decoded, only the decoding error is returned.
+func ValidateExternalMessage(ver Version, msg *boc.Cell, key ed25519.PublicKey, now uint32) []error {
+	decoded, err := DecodeMessage(ver, msg)
+	if err != nil {
+		return []error{err}
+	}
+	var errs []error
+	if err := verifyExternalMessage(ver, msg, key); err != nil {
+		errs = append(errs, fmt.Errorf("signature: %w", err))
+	}
+	// requests of wallet v5 extensions have no valid-until time, so they never expire.
+	if !isV5ExtensionRequest(ver, msg) && decoded.ValidUntil <= now {
+		errs = append(errs, ErrMessageExpired)
+	}
+	for i, rawMsg := range decoded.RawMessages {
+		if err := validateMessageMode(rawMsg.Mode); err != nil {
+			errs = append(errs, fmt.Errorf("message %v: %w", i, err))
+		}
+	}
+	if err := checkMessagesLimit(len(decoded.RawMessages), ver); err != nil {
+		errs = append(errs, err)
+	}
+	bits, cells, err := messageSize(msg)
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		// messageSize doesn't count the root cell.
+		bits += msg.BitSize()
+		cells += 1
+		if bits > maxMsgBits || cells > maxMsgCells {
+			errs = append(errs, fmt.Errorf("message is too large: %v bits, %v cells", bits, cells))
+		}
+	}
+	return errs
+}
+
+// verifyExternalMessage checks the signature of the given external message sent to a wallet of the given version.
+func verifyExternalMessage(ver Version, msg *boc.Cell, key ed25519.PublicKey) error {
+	if ver != V5R1 {
+		return VerifySignature(ver, msg, key)
+	}
+	var m tlb.Message
+	if err := tlb.Unmarshal(msg, &m); err != nil {
+		return err
+	}
+	return MessageV5VerifySignature(boc.Cell(m.Body.Value), key)
+}
+
+// isV5ExtensionRequest reports whether the given message carries a request of an extension of a wallet v5.
+func isV5ExtensionRequest(ver Version, msg *boc.Cell) bool {
+	if ver != V5R1 {
+		return false
+	}
+	var m tlb.Message
+	if err := tlb.Unmarshal(msg, &m); err != nil {
+		return false
+	}
+	body := boc.Cell(m.Body.Value)
+	prefix, err := body.PickUint(32)
+	return err == nil && prefix == 0x6578746e
+}
+
+// validateMessageMode checks that the given send mode has only known flags
+// and doesn't combine AttachAllRemainingBalance with AttachAllRemainingBalanceOfInboundMessage.
+func validateMessageMode(mode byte) error {
+	known := AttachAllRemainingBalance | AttachAllRemainingBalanceOfInboundMessage | DestroyAccount |
+		BounceOnActionFail | IgnoreErrors | PayFeesSeparately
+	if MessageMode(mode)&^known != 0 {
+		return fmt.Errorf("unknown send mode flags: %v", mode)
+	}
+	if IsMessageModeSet(int(mode), AttachAllRemainingBalance) && IsMessageModeSet(int(mode), AttachAllRemainingBalanceOfInboundMessage) {
+		return fmt.Errorf("send mode %v combines 64 and 128", mode)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package wallet
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+
+	"github.com/tonkeeper/tongo/boc"
+	"github.com/tonkeeper/tongo/tlb"
+	"github.com/tonkeeper/tongo/ton"
+)
+
+func TestValidateExternalMessage(t *testing.T) {
+	privateKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	otherKey, err := SeedToPrivateKey(RandomSeed())
+	if err != nil {
+		t.Fatalf("SeedToPrivateKey() failed: %v", err)
+	}
+	recipient := ton.MustParseAccountID("0:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	const now = 1_700_000_000
+	hdr := MessageHeader{
+		SubWalletId:    DefaultSubWallet,
+		WalletID:       [10]byte{0xff, 0xff, 0xff, 0x11},
+		ValidUntil:     now + 60,
+		Seqno:          5,
+		BoundedQueryID: (now + 60) << 32,
+	}
+	for _, ver := range []Version{V3R2, V4R2, HighLoadV2R2, V5R1} {
+		t.Run(ver.ToString(), func(t *testing.T) {
+			msgs := []RawMessage{mustRawMessage(t, Message{Amount: 1_000, Address: recipient, Mode: 3})}
+			msg, err := AssembleExternalMessage(ver, privateKey, hdr, msgs)
+			if err != nil {
+				t.Fatalf("AssembleExternalMessage() failed: %v", err)
+			}
+			if errs := ValidateExternalMessage(ver, msg, publicKey, now); errs != nil {
+				t.Fatalf("want no errors, got %v", errs)
+			}
+			if errs := ValidateExternalMessage(ver, msg, otherKey.Public().(ed25519.PublicKey), now+60); len(errs) != 2 ||
+				!errors.Is(errs[0], ErrBadSignature) || !errors.Is(errs[1], ErrMessageExpired) {
+				t.Fatalf("want a bad signature and an expired message, got %v", errs)
+			}
+		})
+	}
+	msgs := []RawMessage{
+		mustRawMessage(t, Message{Amount: 1_000, Address: recipient, Mode: 3}),
+		mustRawMessage(t, Message{Amount: 1_000, Address: recipient, Mode: 4}),
+		mustRawMessage(t, Message{Amount: 1_000, Address: recipient, Mode: 192}),
+	}
+	msg, err := AssembleExternalMessage(V4R2, privateKey, hdr, msgs)
+	if err != nil {
+		t.Fatalf("AssembleExternalMessage() failed: %v", err)
+	}
+	if errs := ValidateExternalMessage(V4R2, msg, publicKey, now); len(errs) != 2 {
+		t.Fatalf("want 2 invalid modes, got %v", errs)
+	}
+
+	// a request of an extension has no valid-until time to check.
+	var extn MessageV5
+	extn.SumType = "Extn"
+	extn.Extn.QueryID = 7
+	extn.Extn.Actions = SendMessageList{Actions: []SendMessageAction{{Mode: msgs[0].Mode, Msg: msgs[0].Message}}}
+	body := boc.NewCell()
+	if err := tlb.Marshal(body, extn); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	extMsg, err := ton.CreateExternalMessage(recipient, body, nil, 0)
+	if err != nil {
+		t.Fatalf("CreateExternalMessage() failed: %v", err)
+	}
+	extnMsg := boc.NewCell()
+	if err := tlb.Marshal(extnMsg, extMsg); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	for _, err := range ValidateExternalMessage(V5R1, extnMsg, publicKey, now) {
+		if errors.Is(err, ErrMessageExpired) {
+			t.Fatalf("extension request must not expire, got %v", err)
+		}
+	}
+}
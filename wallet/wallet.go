@@ -174,7 +174,7 @@ func (w *Wallet) RawSendV2(
 		}
 		err = tlb.Marshal(bodyCell, body)
 	case HighLoadV2R2:
-		boundedID := uint64(time.Now().Add(DefaultMessageLifetime).UTC().Unix()<<32) + uint64(rand.Uint32())
+		boundedID := PackHighloadQueryID(uint32(time.Now().Add(DefaultMessageLifetime).UTC().Unix()), rand.Uint32())
 		body := HighloadV2Message{
 			SubWalletId:    w.subWalletId,
 			BoundedQueryID: boundedID,
@@ -269,10 +269,14 @@ func checkMessagesLimit(msgQty int, ver Version) error { // TODO: maybe return b
 		if msgQty > 4 {
 			return fmt.Errorf("%v wallet support up to 4 internal messages", ver.ToString())
 		}
-	case HighLoadV2R2:
+	case HighLoadV1R1, HighLoadV1R2, HighLoadV2R2:
 		if msgQty > 254 {
 			return fmt.Errorf("%v wallet support up to 254 internal messages", ver.ToString())
 		}
+	case V5R1:
+		if msgQty > 255 {
+			return fmt.Errorf("%v wallet support up to 255 internal messages", ver.ToString())
+		}
 	default:
 		return fmt.Errorf("message qty checking is not implemented for %v wallet", ver.ToString())
 	}
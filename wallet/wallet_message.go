@@ -0,0 +1,120 @@
+package wallet
+
+import (
+	"fmt"
+
+	"github.com/tonkeeper/tongo/boc"
+)
+
+// WalletMessage is implemented by bodies of external messages of all supported wallet versions.
+// MessageV3, MessageV4, HighloadV1Message and HighloadV2Message have RawMessages and Seqno fields,
+// so the methods are named differently.
+type WalletMessage interface {
+	// Messages returns a list of messages the wallet is asked to send.
+	Messages() []RawMessage
+	// WalletVersion returns a version of a wallet the message is built for.
+	// Revisions of the same wallet share a message layout, so the latest revision is returned.
+	WalletVersion() Version
+	// MessageSeqno returns a seqno of the message.
+	// The returned bool is false if the wallet doesn't use seqno.
+	MessageSeqno() (uint32, bool)
+}
+
+var (
+	_ WalletMessage = &MessageV3{}
+	_ WalletMessage = &MessageV4{}
+	_ WalletMessage = &MessageV5{}
+	_ WalletMessage = &HighloadV1Message{}
+	_ WalletMessage = &HighloadV2Message{}
+)
+
+func (m *MessageV3) Messages() []RawMessage {
+	return m.RawMessages
+}
+
+func (m *MessageV3) WalletVersion() Version {
+	return V3R2
+}
+
+func (m *MessageV3) MessageSeqno() (uint32, bool) {
+	return m.Seqno, true
+}
+
+func (m *MessageV4) Messages() []RawMessage {
+	return m.RawMessages
+}
+
+func (m *MessageV4) WalletVersion() Version {
+	return V4R2
+}
+
+func (m *MessageV4) MessageSeqno() (uint32, bool) {
+	return m.Seqno, true
+}
+
+func (m *HighloadV2Message) Messages() []RawMessage {
+	return m.RawMessages
+}
+
+func (m *HighloadV2Message) WalletVersion() Version {
+	return HighLoadV2R2
+}
+
+// MessageSeqno always returns false because highload wallets use query ids instead of seqno.
+func (m *HighloadV2Message) MessageSeqno() (uint32, bool) {
+	return 0, false
+}
+
+func (m *HighloadV1Message) Messages() []RawMessage {
+	return m.RawMessages
+}
+
+func (m *HighloadV1Message) WalletVersion() Version {
+	return HighLoadV1R2
+}
+
+func (m *HighloadV1Message) MessageSeqno() (uint32, bool) {
+	return m.Seqno, true
+}
+
+func (m *MessageV5) Messages() []RawMessage {
+	return m.RawMessages()
+}
+
+func (m *MessageV5) WalletVersion() Version {
+	return V5R1
+}
+
+func (m *MessageV5) MessageSeqno() (uint32, bool) {
+	request := m.signedRequest()
+	if request == nil {
+		return 0, false
+	}
+	return request.Seqno, true
+}
+
+// DecodeWalletMessage decodes an external message sent to a wallet of the given version.
+func DecodeWalletMessage(ver Version, msg *boc.Cell, opts ...DecodeOption) (WalletMessage, error) {
+	var (
+		m   WalletMessage
+		err error
+	)
+	switch ver {
+	case V5R1:
+		m, err = DecodeMessageV5(msg, opts...)
+	case V4R1, V4R2:
+		m, err = DecodeMessageV4(msg, opts...)
+	case V3R1, V3R2:
+		m, err = DecodeMessageV3(msg, opts...)
+	case HighLoadV2R2:
+		m, err = DecodeHighloadV2Message(msg, opts...)
+	case HighLoadV1R1, HighLoadV1R2:
+		m, err = DecodeHighloadV1Message(msg, opts...)
+	default:
+		return nil, fmt.Errorf("wallet version is not supported: %v", ver)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
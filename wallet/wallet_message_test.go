@@ -0,0 +1,49 @@
+package wallet
+
+import (
+	"testing"
+)
+
+func TestDecodeWalletMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		ver       Version
+		boc       string
+		wantSeqno uint32
+		wantOk    bool
+		wantMsgs  int
+	}{
+		{
+			name:      "v5",
+			ver:       V5R1,
+			boc:       "te6ccgECCAEAAZ4AAfGIAehvqHPiQ2Ru+zkowjJx/7oJbqEYRnlCOuPe5+2gm24WA5tLO3f////oAAAAAAADMY8YiAAAADPkc94coPiaMQo1EI1uuJWlVQGxiffff96PyOTGiQhUjkr733UkT8rfdXxuYcb9SMykg8Tlo7LNBB187eI+ymw2AQIKDsPIbQMCAwIKDsPIbQMEBQCpaAHob6hz4kNkbvs5KMIycf+6CW6hGEZ5Qjrj3uftoJtuFwAbM0yWoWMN5aT+uK8qrHCkGgxpOEKbDu0Tui2Fbyh0iAy3GwAAAAAAAAAAAAAAAAAAQAIKDsPIbQMGBwCpaAHob6hz4kNkbvs5KMIycf+6CW6hGEZ5Qjrj3uftoJtuFwAbM0yWoWMN5aT+uK8qrHCkGgxpOEKbDu0Tui2Fbyh0iAx6EgAAAAAAAAAAAAAAAAAAQAAAAKloAehvqHPiQ2Ru+zkowjJx/7oJbqEYRnlCOuPe5+2gm24XABszTJahYw3lpP64ryqscKQaDGk4QpsO7RO6LYVvKHSIDD0JAAAAAAAAAAAAAAAAAABA",
+			wantSeqno: 6,
+			wantOk:    true,
+			wantMsgs:  3,
+		},
+		{
+			name:     "highload",
+			ver:      HighLoadV2R2,
+			boc:      "te6ccgECCQEAAUMAAUWIAbeTPaOhIeFpX00pVBankGP2F/kaObq5EAdGLvI+omE+DAEBmXzKceTPz+weyz8nYZbOkpsBYbvy6gN7h38ZVL6RTqln7XbUzHkQqxRp1B1ZYkBgMW1NtE7r8Jwg26HcS3qPiwYAAYiUZMJyTpfTrVXAAgIFngACAwQBAwDgBQEDAOAHAWJCADZmmS1CxhvLSf1xXlVY4Ug0GNJwhTYd2id0WwreUOkQCKAAAAAAAAAAAAAAAAABBgBQAAAAADcwMzBhYzQ2LWI5NWMtNDRjNy04ZDdiLTYxMjMyNmU2ZTUxMgFiQgA2ZpktQsYby0n9cV5VWOFINBjScIU2HdondFsK3lDpEAlAAAAAAAAAAAAAAAAAAQgAUAAAAAAzYjA2OTU1YS03YjRjLTQ1YWEtOTVlNy0wNTI4ZWZhYjAyM2E=",
+			wantMsgs: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := DecodeWalletMessage(tt.ver, mustFromHex(tt.boc))
+			if err != nil {
+				t.Fatalf("DecodeWalletMessage() failed: %v", err)
+			}
+			if msg.WalletVersion() != tt.ver {
+				t.Fatalf("want version %v, got %v", tt.ver.ToString(), msg.WalletVersion().ToString())
+			}
+			seqno, ok := msg.MessageSeqno()
+			if seqno != tt.wantSeqno || ok != tt.wantOk {
+				t.Fatalf("want seqno (%v, %v), got (%v, %v)", tt.wantSeqno, tt.wantOk, seqno, ok)
+			}
+			if len(msg.Messages()) != tt.wantMsgs {
+				t.Fatalf("want %v messages, got %v", tt.wantMsgs, len(msg.Messages()))
+			}
+		})
+	}
+}
@@ -169,3 +169,60 @@ func TestDeserializeMessage(t *testing.T) {
 		panic(err)
 	}
 }
+
+func TestV4Plugins(t *testing.T) {
+	plugin := ton.MustParseAccountID("-1:507dea7d606f22d9e85678d3eede39bbe133a868d2a0e3e07f5502cb70b8a512")
+	var key tlb.Bits264
+	key[0] = byte(int8(plugin.Workchain))
+	copy(key[1:], plugin.Address[:])
+	value := boc.NewCell()
+	if err := value.WriteUint(0, 8); err != nil {
+		t.Fatalf("WriteUint() failed: %v", err)
+	}
+	data := DataV4{
+		Seqno:       3,
+		SubWalletId: DefaultSubWallet,
+		PluginDict:  tlb.NewHashmapE([]tlb.Bits264{key}, []tlb.Any{tlb.Any(*value)}),
+	}
+	dataCell := boc.NewCell()
+	if err := tlb.Marshal(dataCell, data); err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	plugins, err := V4Plugins(dataCell)
+	if err != nil {
+		t.Fatalf("V4Plugins() failed: %v", err)
+	}
+	if len(plugins) != 1 || plugins[0] != plugin {
+		t.Fatalf("want plugins %v, got %v", []ton.AccountID{plugin}, plugins)
+	}
+}
+
+func TestValidateV5Network(t *testing.T) {
+	id := tlb.Bits80{0xff, 0xff, 0xff, 0x11, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	if err := ValidateV5Network(id, MainnetGlobalID); err != nil {
+		t.Fatalf("ValidateV5Network() failed: %v", err)
+	}
+	if err := ValidateV5Network(id, TestnetGlobalID); err == nil {
+		t.Fatalf("mainnet wallet id must not be valid for testnet")
+	}
+}
+
+func TestValidateV5WalletID(t *testing.T) {
+	for _, id := range []tlb.Bits80{
+		{0xff, 0xff, 0xff, 0x11, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		{0xff, 0xff, 0xff, 0xfd, 0xff, 0x00, 0x12, 0x34, 0x56, 0x78},
+	} {
+		if err := ValidateV5WalletID(id); err != nil {
+			t.Fatalf("ValidateV5WalletID(%x) failed: %v", id, err)
+		}
+	}
+	for _, id := range []tlb.Bits80{
+		{0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		{0xff, 0xff, 0xff, 0x11, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00},
+		{0xff, 0xff, 0xff, 0x11, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00},
+	} {
+		if err := ValidateV5WalletID(id); err == nil {
+			t.Fatalf("ValidateV5WalletID(%x) had to fail but it didn't", id)
+		}
+	}
+}